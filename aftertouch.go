@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+)
+
+// pressureTarget is what aftertouch is routed to.
+type pressureTarget int
+
+const (
+	pressureOff        pressureTarget = iota
+	pressureModulation                // vibrato depth, like the mod wheel
+	pressureExpression                // loudness
+)
+
+func parsePressureTarget(s string) (pressureTarget, error) {
+	switch s {
+	case "off":
+		return pressureOff, nil
+	case "mod":
+		return pressureModulation, nil
+	case "expression":
+		return pressureExpression, nil
+	}
+	return pressureOff, fmt.Errorf("unknown aftertouch target %q (want off, mod or expression)", s)
+}
+
+// channelPressure applies a channel aftertouch message.
+func (h *midiHandler) channelPressure(channel, pressure int32) {
+	for _, ch := range h.targetChannels(channel) {
+		h.applyPressure(ch, h.aftertouch, pressure)
+	}
+}
+
+// polyPressure applies a polyphonic key pressure message. meltysynth can
+// only modulate whole channels, so each synthesizer channel follows the
+// highest pressure among its keys. Retuned notes usually have a channel to
+// themselves, which makes the pressure truly per note there.
+func (h *midiHandler) polyPressure(channel int32, key byte, pressure int32) {
+	if h.polyTarget == pressureOff {
+		return
+	}
+	synthChannel, synthKey, ok := h.noteLocation(channel, key)
+	if !ok {
+		return
+	}
+	if h.keyPressure[synthChannel] == nil {
+		h.keyPressure[synthChannel] = make(map[int32]int32)
+	}
+	h.keyPressure[synthChannel][synthKey] = pressure
+	h.updateKeyPressure(synthChannel)
+}
+
+// releasePressure forgets the pressure of a key that is being released.
+func (h *midiHandler) releasePressure(channel int32, key byte) {
+	synthChannel, synthKey, ok := h.noteLocation(channel, key)
+	if !ok || h.keyPressure[synthChannel] == nil {
+		return
+	}
+	if _, pressed := h.keyPressure[synthChannel][synthKey]; !pressed {
+		return
+	}
+	delete(h.keyPressure[synthChannel], synthKey)
+	h.updateKeyPressure(synthChannel)
+}
+
+// updateKeyPressure applies the highest key pressure of a channel.
+func (h *midiHandler) updateKeyPressure(synthChannel int32) {
+	var highest int32
+	for _, pressure := range h.keyPressure[synthChannel] {
+		highest = max(highest, pressure)
+	}
+	h.applyPressure(synthChannel, h.polyTarget, highest)
+}
+
+// noteLocation returns the synthesizer channel and key an incoming note
+// sounds on.
+func (h *midiHandler) noteLocation(channel int32, key byte) (int32, int32, bool) {
+	if h.retuned(channel) {
+		return h.tuning.lookup(channel, key)
+	}
+	return channel, int32(key), true
+}
+
+// applyPressure sends pressure to a synthesizer channel as the controller
+// of the given target. Modulation takes the same path as the mod wheel, so
+// it drives the handler's vibrato when there is one.
+func (h *midiHandler) applyPressure(channel int32, target pressureTarget, pressure int32) {
+	switch target {
+	case pressureModulation:
+		if !h.modulationPressure(channel, pressure) {
+			h.synthesizer.ProcessMidiMessage(channel, 0xB0, 0x01, pressure)
+		}
+	case pressureExpression:
+		h.synthesizer.ProcessMidiMessage(channel, 0xB0, 0x0B, expressionFromPressure(pressure))
+	}
+}
+
+// expressionFromPressure maps pressure to expression. Keys rest at zero
+// pressure, so pressure only covers the upper half of the expression range
+// to keep notes audible before the key is pushed down.
+func expressionFromPressure(pressure int32) int32 {
+	return 64 + pressure/2
+}
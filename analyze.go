@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+const (
+	analyzeSampleRate = 48000
+	analyzeFrameSize  = 2048 // FFT size for the spectral centroid
+	analyzeRelease    = 500 * time.Millisecond
+	maxGainTrimDb     = 24
+)
+
+// presetLevel is the measured output of one preset at one velocity.
+type presetLevel struct {
+	velocity int32
+	peak     float64 // linear, over the note and its release
+	rms      float64 // linear, while the note is held
+	centroid float64 // Hz, while the note is held
+}
+
+// gainTrim is one entry of the normalization table written by analyze.
+type gainTrim struct {
+	Bank    int32   `json:"bank"`
+	Program int32   `json:"program"`
+	Name    string  `json:"name"`
+	GainDb  float64 `json:"gainDb"`
+}
+
+// runAnalyze implements the analyze command: it renders every preset at
+// several velocities, prints their levels and optionally writes a table of
+// gain trims that bring every preset to the median level of the font.
+func runAnalyze(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	soundFontPath := fs.String("soundfont", "Mergedsoundfont.sf2", "SoundFont file to analyze")
+	velocityList := fs.String("velocities", "32,64,96,127", "comma separated note velocities to render")
+	hold := fs.Duration("duration", time.Second, "how long each note is held")
+	outPath := fs.String("out", "", "write the gain normalization table to this JSON file")
+	fs.Parse(args)
+
+	var velocities []int32
+	for _, field := range strings.Split(*velocityList, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || v < 1 || v > 127 {
+			fatal("Invalid velocity", "velocity", field)
+		}
+		velocities = append(velocities, int32(v))
+	}
+
+	soundFont, err := loadSoundFont(*soundFontPath)
+	if err != nil {
+		fatal("Failed to load sound font", "err", err)
+	}
+	settings := meltysynth.NewSynthesizerSettings(analyzeSampleRate)
+	settings.EnableReverbAndChorus = false
+	synthesizer, err := meltysynth.NewSynthesizer(soundFont, settings)
+	if err != nil {
+		fatal("Failed to create synthesizer", "err", err)
+	}
+
+	presets := sortedPresets(soundFont)
+	table := make([]gainTrim, 0, len(presets))
+	var levels []float64
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Bank\tProg\tVel\tPeak dBFS\tRMS dBFS\tCentroid Hz\t Name")
+	for _, preset := range presets {
+		var sum float64
+		var measured int
+		for _, velocity := range velocities {
+			level := analyzePreset(synthesizer, preset, velocity, *hold)
+			fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\t%.0f\t %s\n",
+				preset.BankNumber, preset.PatchNumber, velocity,
+				formatDb(level.peak), formatDb(level.rms), level.centroid, preset.Name)
+			if level.rms > 0 {
+				sum += toDb(level.rms)
+				measured++
+			}
+		}
+		if measured == 0 {
+			// Silent presets can't be leveled.
+			continue
+		}
+		rmsDb := sum / float64(measured)
+		levels = append(levels, rmsDb)
+		table = append(table, gainTrim{
+			Bank:    preset.BankNumber,
+			Program: preset.PatchNumber,
+			Name:    preset.Name,
+			GainDb:  rmsDb, // replaced by the trim once the median is known
+		})
+	}
+	w.Flush()
+
+	if *outPath == "" || len(table) == 0 {
+		return
+	}
+	sort.Float64s(levels)
+	reference := levels[len(levels)/2]
+	for i := range table {
+		trim := reference - table[i].GainDb
+		table[i].GainDb = math.Round(max(-maxGainTrimDb, min(maxGainTrimDb, trim))*10) / 10
+	}
+	data, err := json.MarshalIndent(table, "", "  ")
+	if err != nil {
+		fatal("Failed to encode normalization table", "err", err)
+	}
+	if err := os.WriteFile(*outPath, append(data, '\n'), 0o644); err != nil {
+		fatal("Failed to write normalization table", "err", err)
+	}
+	fmt.Printf("Wrote gain trims for %d presets to %s (reference %.1f dBFS RMS)\n", len(table), *outPath, reference)
+}
+
+// sortedPresets returns the presets of a font ordered by bank and program.
+func sortedPresets(soundFont *meltysynth.SoundFont) []*meltysynth.Preset {
+	presets := append([]*meltysynth.Preset(nil), soundFont.Presets...)
+	sort.Slice(presets, func(i, j int) bool {
+		if presets[i].BankNumber != presets[j].BankNumber {
+			return presets[i].BankNumber < presets[j].BankNumber
+		}
+		return presets[i].PatchNumber < presets[j].PatchNumber
+	})
+	return presets
+}
+
+// analyzePreset plays middle C (or kick, snare and hi-hat for drum kits) and
+// measures the result.
+func analyzePreset(synthesizer *meltysynth.Synthesizer, preset *meltysynth.Preset, velocity int32, hold time.Duration) presetLevel {
+	synthesizer.Reset()
+	synthesizer.ProcessMidiMessage(0, 0xB0, 0x00, preset.BankNumber)
+	synthesizer.ProcessMidiMessage(0, 0xC0, preset.PatchNumber, 0)
+
+	keys := []int32{60}
+	if preset.BankNumber >= 128 {
+		keys = []int32{36, 38, 42}
+	}
+
+	holdSamples := int(hold.Seconds() * analyzeSampleRate)
+	releaseSamples := int(analyzeRelease.Seconds() * analyzeSampleRate)
+	left := make([]float32, holdSamples+releaseSamples)
+	right := make([]float32, len(left))
+
+	level := presetLevel{velocity: velocity}
+	var sumSquares, centroidNum, centroidDen float64
+	var heldCount int
+	for _, key := range keys {
+		synthesizer.NoteOn(0, key, velocity)
+		synthesizer.Render(left[:holdSamples], right[:holdSamples])
+		synthesizer.NoteOff(0, key)
+		synthesizer.Render(left[holdSamples:], right[holdSamples:])
+
+		for i := range left {
+			level.peak = max(level.peak, math.Abs(float64(left[i])), math.Abs(float64(right[i])))
+		}
+		mono := make([]float64, holdSamples)
+		for i := range mono {
+			l, r := float64(left[i]), float64(right[i])
+			sumSquares += (l*l + r*r) / 2
+			mono[i] = (l + r) / 2
+		}
+		heldCount += holdSamples
+		num, den := spectralCentroid(mono, analyzeSampleRate)
+		centroidNum += num
+		centroidDen += den
+	}
+
+	if heldCount > 0 {
+		level.rms = math.Sqrt(sumSquares / float64(heldCount))
+	}
+	if centroidDen > 0 {
+		level.centroid = centroidNum / centroidDen
+	}
+	return level
+}
+
+// spectralCentroid returns the magnitude-weighted frequency sum and the
+// magnitude sum over Hann-windowed frames of signal, so several signals can
+// be combined before dividing.
+func spectralCentroid(signal []float64, sampleRate float64) (num, den float64) {
+	frame := make([]complex128, analyzeFrameSize)
+	binWidth := sampleRate / analyzeFrameSize
+	for start := 0; start+analyzeFrameSize <= len(signal); start += analyzeFrameSize {
+		for i := range frame {
+			window := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/(analyzeFrameSize-1))
+			frame[i] = complex(signal[start+i]*window, 0)
+		}
+		fft(frame)
+		for bin := 1; bin < analyzeFrameSize/2; bin++ {
+			magnitude := cmplx.Abs(frame[bin])
+			num += float64(bin) * binWidth * magnitude
+			den += magnitude
+		}
+	}
+	return num, den
+}
+
+// fft is an in-place radix-2 FFT; len(x) must be a power of two.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
+
+// toDb converts a linear amplitude to decibels.
+func toDb(amplitude float64) float64 {
+	return 20 * math.Log10(amplitude)
+}
+
+func formatDb(amplitude float64) string {
+	if amplitude <= 0 {
+		return "-inf"
+	}
+	return fmt.Sprintf("%.1f", toDb(amplitude))
+}
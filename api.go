@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// controlServer serves the HTTP control API.
+type controlServer struct {
+	handler *midiHandler
+	voices  *voiceMeter
+	mux     *http.ServeMux
+}
+
+func newControlServer(handler *midiHandler, voices *voiceMeter) *controlServer {
+	s := &controlServer{handler: handler, voices: voices, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /api/soundfont", s.handleSoundFont)
+	s.mux.HandleFunc("GET /api/soundfont/presets/{bank}/{program}", s.handlePreset)
+	s.mux.HandleFunc("GET /api/soundfont/instruments/{index}", s.handleInstrument)
+	s.mux.HandleFunc("GET /api/clock", s.handleClock)
+	s.mux.HandleFunc("GET /api/channels", s.handleChannels)
+	s.mux.HandleFunc("GET /api/voices", s.handleVoices)
+	s.mux.HandleFunc("PUT /api/channels/{channel}/{setting}", s.handleChannelSetting)
+	s.mux.HandleFunc("DELETE /api/channels/{channel}/{setting}", s.handleChannelSetting)
+	s.mux.HandleFunc("PUT /api/scene/{scene}", s.handleScene)
+	return s
+}
+
+// listen starts serving the API in the background.
+func (s *controlServer) listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := http.Serve(ln, s.mux); err != nil {
+			slog.Error("Control API stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+// writeJSON sends v as the response body.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// soundFontJSON describes the loaded sound font.
+type soundFontJSON struct {
+	Name        string           `json:"name"`
+	Version     string           `json:"version"`
+	Author      string           `json:"author,omitempty"`
+	Copyright   string           `json:"copyright,omitempty"`
+	Comments    string           `json:"comments,omitempty"`
+	Presets     []presetJSON     `json:"presets"`
+	Instruments []instrumentJSON `json:"instruments"`
+	SampleCount int              `json:"sampleCount"`
+}
+
+type presetJSON struct {
+	Bank    int32              `json:"bank"`
+	Program int32              `json:"program"`
+	Name    string             `json:"name"`
+	Regions []presetRegionJSON `json:"regions,omitempty"`
+}
+
+type presetRegionJSON struct {
+	Instrument int      `json:"instrument"` // index into the instrument list
+	Keys       [2]int32 `json:"keys"`
+	Velocities [2]int32 `json:"velocities"`
+}
+
+type instrumentJSON struct {
+	Index   int                    `json:"index"`
+	Name    string                 `json:"name"`
+	Regions []instrumentRegionJSON `json:"regions,omitempty"`
+}
+
+type instrumentRegionJSON struct {
+	Sample     string   `json:"sample"`
+	SampleRate int32    `json:"sampleRate"`
+	RootKey    int32    `json:"rootKey"`
+	Keys       [2]int32 `json:"keys"`
+	Velocities [2]int32 `json:"velocities"`
+	Looped     bool     `json:"looped"`
+}
+
+// loadedSoundFont returns the sound font and its preset index. Loading
+// another font replaces both under h.mu, so they're read together.
+func (h *midiHandler) loadedSoundFont() (*meltysynth.SoundFont, presetIndex) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.synthesizer.SoundFont, h.presets
+}
+
+// handleSoundFont lists the font's presets and instruments without their
+// regions; use the preset and instrument endpoints for those.
+func (s *controlServer) handleSoundFont(w http.ResponseWriter, r *http.Request) {
+	soundFont, _ := s.handler.loadedSoundFont()
+	info := soundFont.Info
+	result := soundFontJSON{
+		Name:        info.BankName,
+		Version:     fmt.Sprintf("%d.%d", info.Version.Major, info.Version.Minor),
+		Author:      info.Auther,
+		Copyright:   info.Copyright,
+		Comments:    info.Comments,
+		SampleCount: len(soundFont.SampleHeaders),
+	}
+	for _, preset := range sortedPresets(soundFont) {
+		result.Presets = append(result.Presets, presetJSON{
+			Bank:    preset.BankNumber,
+			Program: preset.PatchNumber,
+			Name:    preset.Name,
+		})
+	}
+	for i, instrument := range soundFont.Instruments {
+		result.Instruments = append(result.Instruments, instrumentJSON{Index: i, Name: instrument.Name})
+	}
+	writeJSON(w, result)
+}
+
+// handlePreset describes one preset and the instruments its regions use.
+func (s *controlServer) handlePreset(w http.ResponseWriter, r *http.Request) {
+	soundFont, presets := s.handler.loadedSoundFont()
+	bank, err1 := strconv.Atoi(r.PathValue("bank"))
+	program, err2 := strconv.Atoi(r.PathValue("program"))
+	if err1 != nil || err2 != nil {
+		http.Error(w, "invalid bank or program", http.StatusBadRequest)
+		return
+	}
+	preset := presets.lookup(int32(bank), int32(program))
+	if preset == nil {
+		http.Error(w, "preset not found", http.StatusNotFound)
+		return
+	}
+
+	instruments := soundFont.Instruments
+	result := presetJSON{Bank: preset.BankNumber, Program: preset.PatchNumber, Name: preset.Name}
+	for _, region := range preset.Regions {
+		result.Regions = append(result.Regions, presetRegionJSON{
+			Instrument: instrumentIndex(instruments, region.Instrument),
+			Keys:       [2]int32{region.GetKeyRangeStart(), region.GetKeyRangeEnd()},
+			Velocities: [2]int32{region.GetVelocityRangeStart(), region.GetVelocityRangeEnd()},
+		})
+	}
+	writeJSON(w, result)
+}
+
+// handleInstrument describes one instrument and its samples.
+func (s *controlServer) handleInstrument(w http.ResponseWriter, r *http.Request) {
+	soundFont, _ := s.handler.loadedSoundFont()
+	instruments := soundFont.Instruments
+	index, err := strconv.Atoi(r.PathValue("index"))
+	if err != nil || index < 0 || index >= len(instruments) {
+		http.Error(w, "instrument not found", http.StatusNotFound)
+		return
+	}
+
+	instrument := instruments[index]
+	result := instrumentJSON{Index: index, Name: instrument.Name}
+	for _, region := range instrument.Regions {
+		result.Regions = append(result.Regions, instrumentRegionJSON{
+			Sample:     region.Sample.Name,
+			SampleRate: region.Sample.SampleRate,
+			RootKey:    region.GetRootKey(),
+			Keys:       [2]int32{region.GetKeyRangeStart(), region.GetKeyRangeEnd()},
+			Velocities: [2]int32{region.GetVelocityRangeStart(), region.GetVelocityRangeEnd()},
+			Looped:     region.GetSampleModes() != 0,
+		})
+	}
+	writeJSON(w, result)
+}
+
+func instrumentIndex(instruments []*meltysynth.Instrument, instrument *meltysynth.Instrument) int {
+	for i, candidate := range instruments {
+		if candidate == instrument {
+			return i
+		}
+	}
+	return -1
+}
+
+// clockJSON reports the state of the external MIDI clock.
+type clockJSON struct {
+	Running bool    `json:"running"`
+	BPM     float64 `json:"bpm"`
+	Beat    float64 `json:"beat"` // quarter notes since the start of the song
+}
+
+func (s *controlServer) handleClock(w http.ResponseWriter, r *http.Request) {
+	clock := s.handler.clock
+	running, pulse := clock.state()
+	writeJSON(w, clockJSON{
+		Running: running,
+		BPM:     math.Round(clock.bpm()*10) / 10,
+		Beat:    float64(pulse) / pulsesPerQuarter,
+	})
+}
+
+// channelJSON describes the state of a MIDI channel. Channels are numbered
+// 1-16 in the API.
+type channelJSON struct {
+	Channel    int    `json:"channel"`
+	Bank       int32  `json:"bank"`
+	Program    int32  `json:"program"`
+	Instrument string `json:"instrument"` // General MIDI name of the program
+	Preset     string `json:"preset"`     // sound font preset playing it
+	Volume     int32  `json:"volume"`
+	Expression int32  `json:"expression"`
+	Pan        int32  `json:"pan"`
+	Transpose  int32  `json:"transpose"`
+	Muted      bool   `json:"muted"`
+	Soloed     bool   `json:"soloed"`
+	Audible    bool   `json:"audible"`
+}
+
+func (s *controlServer) handleChannels(w http.ResponseWriter, r *http.Request) {
+	h := s.handler
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	channels := make([]channelJSON, channelCount)
+	for ch := range int32(channelCount) {
+		mix := h.mix[ch]
+		channels[ch] = channelJSON{
+			Channel:    int(ch) + 1,
+			Bank:       h.banks[ch],
+			Program:    mix.program,
+			Instrument: gmName(h.banks[ch], mix.program),
+			Preset:     mix.preset,
+			Volume:     mix.volume,
+			Expression: mix.expression,
+			Pan:        mix.pan,
+			Transpose:  mix.transpose,
+			Muted:      h.muted[ch],
+			Soloed:     h.soloed[ch],
+			Audible:    h.audible(ch),
+		}
+	}
+	writeJSON(w, channels)
+}
+
+// handleChannelSetting turns mute or solo on (PUT) or off (DELETE) for a
+// channel. Notes already sounding are left to finish.
+func (s *controlServer) handleChannelSetting(w http.ResponseWriter, r *http.Request) {
+	channel, err := strconv.Atoi(r.PathValue("channel"))
+	if err != nil || channel < 1 || channel > channelCount {
+		http.Error(w, "channel not found", http.StatusNotFound)
+		return
+	}
+	h := s.handler
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	on := r.Method == http.MethodPut
+	switch r.PathValue("setting") {
+	case "mute":
+		h.muted[channel-1] = on
+	case "solo":
+		h.soloed[channel-1] = on
+	default:
+		http.Error(w, "unknown setting", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleScene switches to a scene by name or number.
+func (s *controlServer) handleScene(w http.ResponseWriter, r *http.Request) {
+	if err := s.handler.selectScene(r.PathValue("scene")); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// voicesJSON reports voice usage. Peak is the highest count since the
+// previous report, by this endpoint or -voices.
+type voicesJSON struct {
+	Active  int `json:"active"`
+	Peak    int `json:"peak"`
+	Maximum int `json:"maximum"`
+}
+
+func (s *controlServer) handleVoices(w http.ResponseWriter, r *http.Request) {
+	active, peak := s.voices.report()
+	writeJSON(w, voicesJSON{Active: active, Peak: peak, Maximum: s.voices.maximum})
+}
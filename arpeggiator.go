@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// arpMode is the order the arpeggiator plays held notes in.
+type arpMode int
+
+const (
+	arpUp arpMode = iota
+	arpDown
+	arpUpDown
+	arpRandom
+)
+
+func parseArpMode(s string) (arpMode, error) {
+	switch s {
+	case "up":
+		return arpUp, nil
+	case "down":
+		return arpDown, nil
+	case "up-down":
+		return arpUpDown, nil
+	case "random":
+		return arpRandom, nil
+	}
+	return arpUp, fmt.Errorf("unknown arpeggiator mode %q (want up, down, up-down or random)", s)
+}
+
+// parseArpRate converts a note value such as "1/16" or "1/8t" (triplet) to
+// clock pulses per step.
+func parseArpRate(s string) (int64, error) {
+	triplet := strings.HasSuffix(s, "t")
+	denominator, ok := strings.CutPrefix(strings.TrimSuffix(s, "t"), "1/")
+	n, err := strconv.Atoi(denominator)
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid arpeggiator rate %q (want a note value like 1/16 or 1/8t)", s)
+	}
+	whole := 4 * pulsesPerQuarter
+	if triplet {
+		whole = whole * 2 / 3
+	}
+	if whole%n != 0 {
+		return 0, fmt.Errorf("arpeggiator rate %q doesn't fit the clock resolution", s)
+	}
+	return int64(whole / n), nil
+}
+
+// arpNote is a key held on the keyboard.
+type arpNote struct {
+	channel  int32
+	key      byte
+	velocity byte
+}
+
+// arpeggiator turns held notes into a sequence played on a timebase. It sits
+// in front of the synthesizer: the handler passes it the notes of melodic
+// channels, and it starts and stops notes through the handler.
+type arpeggiator struct {
+	handler *midiHandler
+	mode    arpMode
+	octaves int
+	rate    int64   // clock pulses per step
+	gate    float64 // fraction of a step each note sounds
+	latch   bool    // keep playing released notes until a new chord starts
+
+	held    []arpNote // in the order they were pressed
+	pressed int       // keys physically down, for latch
+	step    int
+	playing *arpNote // note currently sounding
+	offAt   int64    // pulse at which playing is released
+}
+
+// press adds a held note.
+func (a *arpeggiator) press(note arpNote) {
+	if a.latch && a.pressed == 0 {
+		// First key of a new chord replaces the latched one.
+		a.held = a.held[:0]
+		a.step = 0
+	}
+	a.pressed++
+	a.held = slices.DeleteFunc(a.held, func(n arpNote) bool {
+		return n.channel == note.channel && n.key == note.key
+	})
+	a.held = append(a.held, note)
+}
+
+// release removes a held note, unless latched.
+func (a *arpeggiator) release(channel int32, key byte) {
+	if a.pressed > 0 {
+		a.pressed--
+	}
+	if a.latch {
+		return
+	}
+	a.held = slices.DeleteFunc(a.held, func(n arpNote) bool {
+		return n.channel == channel && n.key == key
+	})
+	if len(a.held) == 0 {
+		a.stopPlaying()
+		a.step = 0
+	}
+}
+
+// sequence returns the notes of one arpeggio cycle.
+func (a *arpeggiator) sequence() []arpNote {
+	sorted := slices.Clone(a.held)
+	slices.SortFunc(sorted, func(x, y arpNote) int { return int(x.key) - int(y.key) })
+
+	var notes []arpNote
+	for octave := range a.octaves {
+		for _, note := range sorted {
+			key := int(note.key) + 12*octave
+			if key > 127 {
+				continue
+			}
+			note.key = byte(key)
+			notes = append(notes, note)
+		}
+	}
+
+	switch a.mode {
+	case arpDown:
+		slices.Reverse(notes)
+	case arpUpDown:
+		// Don't repeat the top and bottom notes at the turns.
+		for i := len(notes) - 2; i > 0; i-- {
+			notes = append(notes, notes[i])
+		}
+	}
+	return notes
+}
+
+func (a *arpeggiator) clockPulse(pulse int64) {
+	if a.playing != nil && pulse >= a.offAt {
+		a.stopPlaying()
+	}
+	if pulse%a.rate != 0 || len(a.held) == 0 {
+		return
+	}
+	a.stopPlaying()
+
+	notes := a.sequence()
+	if len(notes) == 0 {
+		return
+	}
+	var note arpNote
+	if a.mode == arpRandom {
+		note = notes[rand.IntN(len(notes))]
+	} else {
+		note = notes[a.step%len(notes)]
+		a.step++
+	}
+	a.handler.startNote(note.channel, note.key, note.velocity)
+	a.playing = &note
+	a.offAt = pulse + max(1, int64(math.Round(float64(a.rate)*a.gate)))
+}
+
+func (a *arpeggiator) clockStop() {
+	a.stopPlaying()
+	a.step = 0
+}
+
+func (a *arpeggiator) stopPlaying() {
+	if a.playing != nil {
+		a.handler.stopNote(a.playing.channel, a.playing.key)
+		a.playing = nil
+	}
+}
+
+// newArpeggiator creates an arpeggiator, validating its settings.
+func newArpeggiator(handler *midiHandler, mode string, octaves int, rate string, gate float64, latch bool) (*arpeggiator, error) {
+	a := &arpeggiator{handler: handler, octaves: octaves, gate: gate, latch: latch}
+	var err error
+	if a.mode, err = parseArpMode(mode); err != nil {
+		return nil, err
+	}
+	if a.rate, err = parseArpRate(rate); err != nil {
+		return nil, err
+	}
+	if octaves < 1 || octaves > 4 {
+		return nil, fmt.Errorf("octave range %d out of range 1-4", octaves)
+	}
+	if gate <= 0 || gate > 1 {
+		return nil, fmt.Errorf("gate %v out of range 0-1", gate)
+	}
+	return a, nil
+}
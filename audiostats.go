@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// audioStats measures the audio path. Rendering load is the time spent in
+// the synthesizer relative to the duration of the audio produced. Latency is
+// estimated by comparing the audio produced so far with the time that has
+// passed: whatever is ahead of the clock is queued for playback. Underruns
+// are counted when the output finds the ring buffer short of audio.
+type audioStats struct {
+	mu         sync.Mutex
+	sampleRate float64
+	start      time.Time
+	frames     int64        // frames produced since start
+	underruns  atomic.Int64 // counted without the lock, from the output callback
+	lastWarn   time.Time
+
+	// Since the last report:
+	renderTime time.Duration
+	audioTime  time.Duration
+	peakLoad   float64
+	latency    time.Duration // latest estimate
+}
+
+func newAudioStats(sampleRate int) *audioStats {
+	return &audioStats{sampleRate: float64(sampleRate)}
+}
+
+// record accounts for one render call.
+func (s *audioStats) record(frames int, renderTime time.Duration) {
+	if frames == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.start.IsZero() {
+		s.start = now
+	}
+	audio := time.Duration(float64(frames) / s.sampleRate * float64(time.Second))
+	s.renderTime += renderTime
+	s.audioTime += audio
+	load := float64(renderTime) / float64(audio)
+	s.peakLoad = max(s.peakLoad, load)
+
+	produced := time.Duration(float64(s.frames) / s.sampleRate * float64(time.Second))
+	played := now.Sub(s.start)
+	if played > produced {
+		// The output ran dry; start counting again from here.
+		s.start = now.Add(-produced)
+		played = produced
+	}
+	s.frames += int64(frames)
+	s.latency = produced + audio - played
+
+	if load > 1 && now.Sub(s.lastWarn) > time.Second {
+		s.lastWarn = now
+		slog.Warn("The synthesizer can't keep up", "renderTime", renderTime.Round(time.Microsecond), "audio", audio.Round(time.Microsecond))
+	}
+}
+
+// underrun counts an output callback that couldn't be filled.
+func (s *audioStats) underrun() {
+	s.underruns.Add(1)
+}
+
+// logReport logs a summary and starts a new measurement interval.
+func (s *audioStats) logReport() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var load float64
+	if s.audioTime > 0 {
+		load = float64(s.renderTime) / float64(s.audioTime)
+	}
+	slog.Info("Audio",
+		"load", fmt.Sprintf("%.0f%%", load*100),
+		"peakLoad", fmt.Sprintf("%.0f%%", s.peakLoad*100),
+		"underruns", s.underruns.Load(),
+		"latency", s.latency.Round(time.Millisecond))
+	s.renderTime, s.audioTime, s.peakLoad = 0, 0, 0
+}
+
+// printEvery prints a report at every interval, in the background.
+func (s *audioStats) printEvery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			s.logReport()
+		}
+	}()
+}
@@ -0,0 +1,61 @@
+package main
+
+import "fmt"
+
+// bankSelectMode is how Bank Select MSB (CC0) and LSB (CC32) map to sound
+// font banks.
+type bankSelectMode int
+
+const (
+	bankSelectMSB  bankSelectMode = iota // bank = MSB, as GS and most sound fonts use
+	bankSelectLSB                        // bank = LSB, with MSB 127 for drum kits, as XG uses
+	bankSelectBoth                       // bank = MSB*128 + LSB
+	bankSelectOff                        // ignore bank select
+)
+
+func parseBankSelectMode(s string) (bankSelectMode, error) {
+	switch s {
+	case "msb":
+		return bankSelectMSB, nil
+	case "lsb":
+		return bankSelectLSB, nil
+	case "both":
+		return bankSelectBoth, nil
+	case "off":
+		return bankSelectOff, nil
+	}
+	return bankSelectOff, fmt.Errorf("unknown bank select mode %q (want msb, lsb, both or off)", s)
+}
+
+// bankSelect holds the last Bank Select values of a channel.
+type bankSelect struct {
+	msb, lsb int32
+}
+
+// bankSelectChange handles CC0 and CC32. As on hardware, the new bank only
+// takes effect at the next program change. Drum channels keep the drum
+// bank, because sequencers often send bank 0 to them along with the kit.
+func (h *midiHandler) bankSelectChange(channel, cc, value int32) {
+	sel := &h.bankSel[channel]
+	if cc == 0x00 {
+		sel.msb = value
+	} else {
+		sel.lsb = value
+	}
+	bank := h.banks[channel]
+	switch h.bankMode {
+	case bankSelectMSB:
+		bank = sel.msb
+	case bankSelectLSB:
+		bank = sel.lsb
+		if sel.msb == 127 {
+			bank = 128
+		}
+	case bankSelectBoth:
+		bank = sel.msb*128 + sel.lsb
+	}
+	if h.drums[channel] && bank != 128 {
+		return
+	}
+	h.banks[channel] = bank
+}
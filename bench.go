@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"runtime"
+	"time"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// runBench implements the bench command: it renders a storm of notes as
+// fast as possible and reports the throughput, so polyphony and block size
+// can be chosen for a machine.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	soundFontPath := fs.String("soundfont", "Mergedsoundfont.sf2", "SoundFont file to render; the built-in fallback if empty")
+	sampleRate := fs.Int("sample-rate", 48000, "audio sample rate in Hz")
+	blockFrames := fs.Int("audio-block", renderBlockFrames, "frames rendered at a time (8-1024)")
+	polyphony := fs.Int("polyphony", 500, "maximum number of voices")
+	notes := fs.Int("notes", 64, "notes held at once")
+	noteRate := fs.Float64("note-rate", 100, "notes started per second, each replacing the oldest")
+	duration := fs.Duration("duration", 10*time.Second, "length of audio to render")
+	reverb := fs.Bool("reverb", false, "enable reverb and chorus")
+	fs.Parse(args)
+
+	if *blockFrames < 8 || *blockFrames > 1024 {
+		fatal("Invalid audio block size", "frames", *blockFrames)
+	}
+	if *notes < 1 {
+		fatal("Invalid note count", "notes", *notes)
+	}
+	var soundFont *meltysynth.SoundFont
+	var err error
+	if *soundFontPath == "" {
+		soundFont, err = fallbackSoundFont()
+	} else {
+		soundFont, err = loadSoundFont(*soundFontPath)
+	}
+	if err != nil {
+		fatal("Failed to load sound font", "err", err)
+	}
+	synthesizer, err := meltysynth.NewSynthesizer(soundFont, &meltysynth.SynthesizerSettings{
+		SampleRate:            int32(*sampleRate),
+		BlockSize:             int32(*blockFrames),
+		MaximumPolyphony:      int32(*polyphony),
+		EnableReverbAndChorus: *reverb,
+	})
+	if err != nil {
+		fatal("Failed to create synthesizer", "err", err)
+	}
+
+	// Spread the notes over every channel, with a different program on
+	// each, so the storm mixes instruments like a busy song.
+	for ch := range int32(channelCount) {
+		synthesizer.ProcessMidiMessage(ch, 0xC0, ch*8, 0)
+	}
+	storm := &noteStorm{rng: rand.New(rand.NewPCG(1, 2)), size: *notes}
+	for range *notes {
+		storm.next(synthesizer)
+	}
+
+	left := make([]float32, *blockFrames)
+	right := make([]float32, *blockFrames)
+	blocks := int(duration.Seconds() * float64(*sampleRate) / float64(*blockFrames))
+	notesPerBlock := *noteRate * float64(*blockFrames) / float64(*sampleRate)
+	var due float64
+	var voices, peakVoices int
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for range blocks {
+		for due += notesPerBlock; due >= 1; due-- {
+			storm.next(synthesizer)
+		}
+		synthesizer.Render(left, right)
+		active := activeVoices(synthesizer)
+		voices += active
+		peakVoices = max(peakVoices, active)
+	}
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	rendered := time.Duration(float64(blocks*(*blockFrames)) / float64(*sampleRate) * float64(time.Second))
+	fmt.Printf("Rendered %v of audio in %v (%d blocks of %d frames at %d Hz)\n",
+		rendered.Round(time.Millisecond), elapsed.Round(time.Millisecond), blocks, *blockFrames, *sampleRate)
+	fmt.Printf("Blocks per second:  %.0f (%.0f needed for real time)\n",
+		float64(blocks)/elapsed.Seconds(), float64(*sampleRate)/float64(*blockFrames))
+	fmt.Printf("Real-time factor:   %.1fx\n", rendered.Seconds()/elapsed.Seconds())
+	fmt.Printf("Time per block:     %v of %v available\n",
+		(elapsed / time.Duration(blocks)).Round(time.Microsecond), (rendered / time.Duration(blocks)).Round(time.Microsecond))
+	fmt.Printf("Voices:             %.0f on average, %d at most\n", float64(voices)/float64(blocks), peakVoices)
+	fmt.Printf("Allocations:        %.1f per block, %d bytes in total\n",
+		float64(after.Mallocs-before.Mallocs)/float64(blocks), after.TotalAlloc-before.TotalAlloc)
+}
+
+// noteStorm keeps a number of random notes held, releasing the oldest
+// whenever another starts.
+type noteStorm struct {
+	rng  *rand.Rand
+	size int
+	held [][2]int32 // channel and key, oldest first
+}
+
+func (s *noteStorm) next(synthesizer *meltysynth.Synthesizer) {
+	if len(s.held) >= s.size {
+		synthesizer.NoteOff(s.held[0][0], s.held[0][1])
+		s.held = s.held[1:]
+	}
+	channel, key := s.rng.Int32N(channelCount), 36+s.rng.Int32N(60)
+	synthesizer.NoteOn(channel, key, 64+s.rng.Int32N(64))
+	s.held = append(s.held, [2]int32{channel, key})
+}
@@ -0,0 +1,49 @@
+package main
+
+import "fmt"
+
+// bendRangeConfig sets the power-on pitch bend range of a channel, e.g.
+// {"channel": 5, "semitones": 12} for guitar-style bends. RPN 0 can still
+// change it while playing.
+type bendRangeConfig struct {
+	Channel   int   `json:"channel"` // 1-16
+	Semitones int32 `json:"semitones"`
+	Cents     int32 `json:"cents"`
+}
+
+func (r *bendRangeConfig) validate() error {
+	if r.Channel < 1 || r.Channel > channelCount {
+		return fmt.Errorf("channel %d out of range 1-16", r.Channel)
+	}
+	if r.Semitones < 0 || r.Semitones > 96 {
+		return fmt.Errorf("%d semitones out of range 0-96", r.Semitones)
+	}
+	if r.Cents < 0 || r.Cents > 99 {
+		return fmt.Errorf("%d cents out of range 0-99", r.Cents)
+	}
+	return nil
+}
+
+// applyBendRanges sets the configured pitch bend ranges, at start and
+// after a reset.
+func (h *midiHandler) applyBendRanges() {
+	for _, r := range h.bendRanges {
+		channel := int32(r.Channel - 1)
+		h.rpn[channel].bendSemitones, h.rpn[channel].bendCents = r.Semitones, r.Cents
+	}
+	h.sendBendRanges()
+}
+
+// sendBendRanges sends the pitch bend ranges that differ from the
+// synthesizer's default of two semitones, e.g. to a new synthesizer.
+func (h *midiHandler) sendBendRanges() {
+	for ch := range int32(channelCount) {
+		state := h.rpn[ch]
+		if state.bendSemitones == 2 && state.bendCents == 0 {
+			continue
+		}
+		for _, target := range h.targetChannels(ch) {
+			setPitchBendRange(h.synthesizer, target, state.bendSemitones, state.bendCents)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+//go:build ble
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tinygo.org/x/bluetooth"
+)
+
+// bleScanTimeout is how long to look for a BLE MIDI device.
+const bleScanTimeout = 15 * time.Second
+
+var (
+	bleMidiService        = mustParseUUID("03b80e5a-ede8-4b33-a751-6ce34ec4c700")
+	bleMidiCharacteristic = mustParseUUID("7772e5db-3868-4112-a1a9-f2669d106bf3")
+)
+
+func mustParseUUID(s string) bluetooth.UUID {
+	uuid, err := bluetooth.ParseUUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return uuid
+}
+
+// bleMidiInput is a connected Bluetooth LE MIDI device.
+type bleMidiInput struct {
+	device bluetooth.Device
+	parser midiParser // carries running status and SysEx across packets
+}
+
+// openBleMidi connects to the first Bluetooth LE MIDI device whose name
+// contains spec, or to any with "any", and passes the messages it sends to
+// play. The device doesn't need to be paired with the system first.
+func openBleMidi(spec string, play func([]byte)) (*bleMidiInput, string, error) {
+	adapter := bluetooth.DefaultAdapter
+	if err := adapter.Enable(); err != nil {
+		return nil, "", fmt.Errorf("enable Bluetooth: %w", err)
+	}
+	var found *bluetooth.ScanResult
+	timer := time.AfterFunc(bleScanTimeout, func() { adapter.StopScan() })
+	err := adapter.Scan(func(adapter *bluetooth.Adapter, result bluetooth.ScanResult) {
+		if !result.HasServiceUUID(bleMidiService) {
+			return
+		}
+		if spec != "any" && !strings.Contains(strings.ToLower(result.LocalName()), strings.ToLower(spec)) {
+			return
+		}
+		found = &result
+		adapter.StopScan()
+	})
+	timer.Stop()
+	if err != nil {
+		return nil, "", fmt.Errorf("scan: %w", err)
+	}
+	if found == nil {
+		return nil, "", fmt.Errorf("no BLE MIDI device matches %q", spec)
+	}
+	name := found.LocalName()
+
+	device, err := adapter.Connect(found.Address, bluetooth.ConnectionParams{})
+	if err != nil {
+		return nil, "", fmt.Errorf("connect to %s: %w", name, err)
+	}
+	in := &bleMidiInput{device: device}
+	services, err := device.DiscoverServices([]bluetooth.UUID{bleMidiService})
+	if err == nil && len(services) == 0 {
+		err = fmt.Errorf("no MIDI service")
+	}
+	var characteristics []bluetooth.DeviceCharacteristic
+	if err == nil {
+		characteristics, err = services[0].DiscoverCharacteristics([]bluetooth.UUID{bleMidiCharacteristic})
+	}
+	if err == nil && len(characteristics) == 0 {
+		err = fmt.Errorf("no MIDI characteristic")
+	}
+	if err == nil {
+		characteristic := characteristics[0]
+		err = characteristic.EnableNotifications(func(packet []byte) {
+			if data := bleMidiData(packet); len(data) > 0 {
+				in.parser.feed(data, play)
+			}
+		})
+	}
+	if err != nil {
+		in.Close()
+		return nil, "", fmt.Errorf("%s: %w", name, err)
+	}
+	slog.Debug("BLE MIDI device connected", "address", found.Address.String())
+	return in, name, nil
+}
+
+func (in *bleMidiInput) Close() error {
+	return in.device.Disconnect()
+}
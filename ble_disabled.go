@@ -0,0 +1,14 @@
+//go:build !ble
+
+package main
+
+import "fmt"
+
+// bleMidiInput is only available in builds with the ble tag.
+type bleMidiInput struct{}
+
+func openBleMidi(spec string, play func([]byte)) (*bleMidiInput, string, error) {
+	return nil, "", fmt.Errorf("built without BLE MIDI support (build with -tags ble)")
+}
+
+func (in *bleMidiInput) Close() error { return nil }
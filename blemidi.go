@@ -0,0 +1,26 @@
+package main
+
+// bleMidiData strips the timestamps from a BLE MIDI packet, leaving MIDI
+// bytes for the parser. A packet starts with a header byte; after it, every
+// byte with the high bit set is a timestamp, optionally followed by a
+// status byte. Running status and System Exclusive messages continue across
+// packets, which the parser takes care of.
+func bleMidiData(packet []byte) []byte {
+	if len(packet) < 2 || packet[0]&0xC0 != 0x80 {
+		return nil
+	}
+	data := make([]byte, 0, len(packet))
+	for i := 1; i < len(packet); i++ {
+		b := packet[i]
+		if b&0x80 == 0 {
+			data = append(data, b)
+			continue
+		}
+		// A timestamp, and the status byte after it, if any.
+		if i+1 < len(packet) && packet[i+1]&0x80 != 0 {
+			i++
+			data = append(data, packet[i])
+		}
+	}
+	return data
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// breathTarget is what the breath controller (CC2) is routed to.
+type breathTarget int
+
+const (
+	breathOff        breathTarget = iota
+	breathExpression              // loudness, leaving the channel volume alone
+	breathVolume                  // channel volume
+	breathModulation              // vibrato depth, like the mod wheel
+)
+
+func parseBreathTarget(s string) (breathTarget, error) {
+	switch s {
+	case "off":
+		return breathOff, nil
+	case "expression":
+		return breathExpression, nil
+	case "volume":
+		return breathVolume, nil
+	case "mod":
+		return breathModulation, nil
+	}
+	return breathOff, fmt.Errorf("unknown breath target %q (want off, expression, volume or mod)", s)
+}
+
+// breath applies the breath controller, shaped by the response curve: an
+// exponent below 1 makes soft breath louder, above 1 quieter.
+func (h *midiHandler) breath(channel, value int32) {
+	if h.breathTarget == breathOff {
+		return
+	}
+	if h.breathCurve != 1 {
+		value = int32(math.Round(127 * math.Pow(float64(value)/127, h.breathCurve)))
+	}
+	switch h.breathTarget {
+	case breathModulation:
+		if h.modWheel(channel, value) {
+			return
+		}
+		for _, ch := range h.targetChannels(channel) {
+			h.synthesizer.ProcessMidiMessage(ch, 0xB0, 0x01, value)
+		}
+	case breathExpression, breathVolume:
+		cc := int32(0x0B)
+		if h.breathTarget == breathVolume {
+			cc = 0x07
+		}
+		for _, ch := range h.targetChannels(channel) {
+			h.synthesizer.ProcessMidiMessage(ch, 0xB0, cc, value)
+		}
+	}
+}
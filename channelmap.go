@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+
+	"github.com/mattrtaylor/go-rtmidi"
+)
+
+// channelMap remaps the channels of an input port, e.g. {"1": 5} plays
+// what a controller sends on channel 1 on channel 5. Channels are numbered
+// 1-16; unmapped channels stay as they are.
+type channelMap map[string]int
+
+func (m channelMap) validate() error {
+	for from, to := range m {
+		if n, err := strconv.Atoi(from); err != nil || n < 1 || n > channelCount {
+			return fmt.Errorf("channel %q out of range 1-16", from)
+		}
+		if to < 1 || to > channelCount {
+			return fmt.Errorf("channel %d out of range 1-16", to)
+		}
+	}
+	return nil
+}
+
+// table returns the synthesizer channel of every incoming channel, or nil
+// if nothing is remapped.
+func (m channelMap) table() *[channelCount]byte {
+	if len(m) == 0 {
+		return nil
+	}
+	var t [channelCount]byte
+	for ch := range t {
+		t[ch] = byte(ch)
+	}
+	for from, to := range m {
+		n, _ := strconv.Atoi(from)
+		t[n-1] = byte(to - 1)
+	}
+	return &t
+}
+
+// remapChannels rewrites the channel of every channel message status byte
+// in data. Data bytes are left alone, so this works on partial messages
+// and running status too.
+func remapChannels(t *[channelCount]byte, data []byte) []byte {
+	if t == nil {
+		return data
+	}
+	out := make([]byte, len(data))
+	for i, b := range data {
+		if b >= 0x80 && b < 0xF0 {
+			b = b&0xF0 | t[b&0x0F]
+		}
+		out[i] = b
+	}
+	return out
+}
+
+// inputConfig is a further MIDI input port played by the main synthesizer,
+// usually with its channels remapped, so several single-channel
+// controllers can each play their own instrument.
+type inputConfig struct {
+	MidiIn     string            `json:"midiIn"`     // port number or part of its name
+	NoteRanges []noteRangeConfig `json:"noteRanges"` // keys the port responds to
+	ChannelMap channelMap        `json:"channelMap"` // e.g. {"1": 5}
+}
+
+func (c *inputConfig) validate() error {
+	if c.MidiIn == "" {
+		return fmt.Errorf("no midiIn port")
+	}
+	for i, r := range c.NoteRanges {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("note range %d: %w", i+1, err)
+		}
+	}
+	return c.ChannelMap.validate()
+}
+
+// openInput starts playing a further input port through handler, also
+// recording it if recorder isn't nil.
+func openInput(c inputConfig, handler *midiHandler, recorder *midiRecorder) (rtmidi.MIDIIn, error) {
+	table := c.ChannelMap.table()
+	play := recorder.wrap(handler.newInput(c.NoteRanges))
+	in, name, err := openMidiInput(c.MidiIn, func(data []byte) {
+		play(remapChannels(table, data))
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("MIDI input", "midiIn", name, "channelMap", map[string]int(c.ChannelMap))
+	return in, nil
+}
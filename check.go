@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// fontIssue is a problem found by the check command.
+type fontIssue struct {
+	fatal   bool // the font will misbehave, not just sound odd
+	where   string
+	message string
+}
+
+// runCheck implements the check command: it loads sound fonts, prints what
+// they contain and the problems found, and exits with status 1 if any has
+// errors, so broken merges are caught before they are used.
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	strict := fs.Bool("strict", false, "also fail on warnings")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s check [-strict] <sound font>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		fmt.Println(path)
+		soundFont, err := loadSoundFont(path)
+		if err != nil {
+			fmt.Printf("  error: %v\n", err)
+			failed = true
+			continue
+		}
+		fmt.Printf("  %d presets, %d instruments, %d samples, %.1f MB of sample data\n",
+			len(soundFont.Presets), len(soundFont.Instruments), len(soundFont.SampleHeaders),
+			float64(len(soundFont.WaveData)*2)/(1<<20))
+		var errors, warnings int
+		for _, issue := range checkSoundFont(soundFont) {
+			kind := "warning"
+			if issue.fatal {
+				kind = "error"
+				errors++
+			} else {
+				warnings++
+			}
+			fmt.Printf("  %s: %s: %s\n", kind, issue.where, issue.message)
+		}
+		fmt.Printf("  %d errors, %d warnings\n", errors, warnings)
+		if errors > 0 || *strict && warnings > 0 {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// checkSoundFont looks for samples outside the sample data, regions that
+// can't play and generator values that are most likely mistakes.
+func checkSoundFont(soundFont *meltysynth.SoundFont) []fontIssue {
+	var issues []fontIssue
+	report := func(fatal bool, where, format string, args ...any) {
+		issues = append(issues, fontIssue{fatal, where, fmt.Sprintf(format, args...)})
+	}
+
+	waveLength := int32(len(soundFont.WaveData))
+	for _, s := range soundFont.SampleHeaders {
+		where := fmt.Sprintf("sample %q", s.Name)
+		switch {
+		case s.Start < 0 || s.End > waveLength || s.Start >= s.End:
+			report(true, where, "data %d-%d outside the %d sample points of the font", s.Start, s.End, waveLength)
+		case s.StartLoop < s.Start || s.EndLoop > s.End || s.StartLoop > s.EndLoop:
+			report(false, where, "loop %d-%d outside the sample %d-%d", s.StartLoop, s.EndLoop, s.Start, s.End)
+		}
+		if s.SampleRate < 400 || s.SampleRate > 192000 {
+			report(false, where, "unusual sample rate %d Hz", s.SampleRate)
+		}
+	}
+
+	for _, inst := range soundFont.Instruments {
+		where := fmt.Sprintf("instrument %q", inst.Name)
+		if len(inst.Regions) == 0 {
+			report(false, where, "no regions")
+		}
+		for _, r := range inst.Regions {
+			region := fmt.Sprintf("%s, region %q", where, r.Sample.Name)
+			start, end := r.GetSampleStart(), r.GetSampleEnd()
+			if start < 0 || end > waveLength || start >= end {
+				report(true, region, "sample offsets reach %d-%d, outside the sample data", start, end)
+			}
+			if r.GetSampleModes() != 0 {
+				if loopStart, loopEnd := r.GetSampleStartLoop(), r.GetSampleEndLoop(); loopStart < start || loopEnd > end || loopStart >= loopEnd {
+					report(true, region, "loop %d-%d outside the sample %d-%d", loopStart, loopEnd, start, end)
+				}
+			}
+			if r.GetKeyRangeStart() > r.GetKeyRangeEnd() || r.GetVelocityRangeStart() > r.GetVelocityRangeEnd() {
+				report(false, region, "empty key or velocity range, never plays")
+			}
+			if key := r.GetRootKey(); key > 127 {
+				report(false, region, "root key %d out of range", key)
+			}
+			if a := r.GetInitialAttenuation(); a >= 96 {
+				report(false, region, "attenuated by %.0f dB, silent", a)
+			}
+			if c := r.GetInitialFilterCutoffFrequency(); c < 50 {
+				report(false, region, "filter cutoff %.0f Hz, nearly silent", c)
+			}
+			if t := r.GetCoarseTune(); t < -60 || t > 60 {
+				report(false, region, "coarse tune %d semitones", t)
+			}
+			if s := r.GetScaleTuning(); s != 100 && s != 0 && (s < 50 || s > 200) {
+				report(false, region, "scale tuning %d cents per key", s)
+			}
+			if release := r.GetReleaseVolumeEnvelope(); release > 30 {
+				report(false, region, "release %.0f s, notes ring on", release)
+			}
+		}
+	}
+
+	seen := make(map[[2]int32]string)
+	for _, p := range soundFont.Presets {
+		where := fmt.Sprintf("preset %d:%d %q", p.BankNumber, p.PatchNumber, p.Name)
+		if other, ok := seen[[2]int32{p.BankNumber, p.PatchNumber}]; ok {
+			report(false, where, "same bank and program as %q, which hides it", other)
+		}
+		seen[[2]int32{p.BankNumber, p.PatchNumber}] = p.Name
+
+		keys := playableKeys(p)
+		low, high := -1, -1
+		for key, ok := range keys {
+			if ok {
+				if low < 0 {
+					low = key
+				}
+				high = key
+			}
+		}
+		if low < 0 {
+			report(false, where, "no key plays a sample")
+			continue
+		}
+		if p.BankNumber < 128 {
+			// Drum kits leave gaps between instruments on purpose.
+			if gaps := keyGaps(keys[low : high+1]); gaps != nil {
+				report(false, where, "no region for keys %s", formatKeyRanges(gaps, low))
+			}
+		}
+	}
+	return issues
+}
+
+// playableKeys returns the keys for which a preset has a sample, at any
+// velocity.
+func playableKeys(preset *meltysynth.Preset) [128]bool {
+	var keys [128]bool
+	for _, pr := range preset.Regions {
+		for _, ir := range pr.Instrument.Regions {
+			low := max(pr.GetKeyRangeStart(), ir.GetKeyRangeStart())
+			high := min(pr.GetKeyRangeEnd(), ir.GetKeyRangeEnd(), 127)
+			for key := low; key <= high; key++ {
+				keys[key] = true
+			}
+		}
+	}
+	return keys
+}
+
+// keyGaps returns the ranges of unplayable keys, as offsets into keys.
+func keyGaps(keys []bool) [][2]int {
+	var gaps [][2]int
+	for i := 0; i < len(keys); i++ {
+		if keys[i] {
+			continue
+		}
+		start := i
+		for i < len(keys) && !keys[i] {
+			i++
+		}
+		gaps = append(gaps, [2]int{start, i - 1})
+	}
+	return gaps
+}
+
+// formatKeyRanges lists key ranges offset by base, e.g. "40-42, 45".
+func formatKeyRanges(ranges [][2]int, base int) string {
+	parts := make([]string, len(ranges))
+	for i, r := range ranges {
+		parts[i] = fmt.Sprint(r[0] + base)
+		if r[1] != r[0] {
+			parts[i] += fmt.Sprintf("-%d", r[1]+base)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// chordMemory plays a chord shape for every key, for one-finger chords.
+type chordMemory struct {
+	input     int32 // incoming channel, -1 for all
+	intervals []int // semitones from the played key
+}
+
+func newChordMemory(c *chordConfig) *chordMemory {
+	return &chordMemory{input: int32(c.Input - 1), intervals: c.Intervals}
+}
+
+// expand returns the messages a note message turns into, or nil if chord
+// memory doesn't apply to it.
+func (c *chordMemory) expand(msg []byte) [][]byte {
+	if len(msg) < 3 || (c.input >= 0 && int32(msg[0]&0x0F) != c.input) {
+		return nil
+	}
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90, 0xA0: // Note Off, Note On, Polyphonic Key Pressure
+	default:
+		return nil
+	}
+	out := make([][]byte, 0, len(c.intervals))
+	for _, interval := range c.intervals {
+		key := int(msg[1]) + interval
+		if key < 0 || key > 127 {
+			continue
+		}
+		out = append(out, []byte{msg[0], byte(key), msg[2]})
+	}
+	return out
+}
+
+func (c *chordMemory) String() string {
+	channel := "all channels"
+	if c.input >= 0 {
+		channel = fmt.Sprintf("channel %d", c.input+1)
+	}
+	return fmt.Sprintf("intervals %v on %s", c.intervals, channel)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// pulsesPerQuarter is the resolution of MIDI clock.
+const pulsesPerQuarter = 24
+
+// clockListener is notified by a timebase.
+type clockListener interface {
+	// clockPulse is called 24 times per quarter note while the clock runs;
+	// pulse counts from the start of the song.
+	clockPulse(pulse int64)
+	// clockStop is called when the clock stops.
+	clockStop()
+}
+
+// timebase is a source of tempo-synced pulses for internal features such as
+// the arpeggiator or the metronome.
+type timebase interface {
+	bpm() float64
+	subscribe(listener clockListener)
+}
+
+// midiClock follows external MIDI clock: 0xF8 pulses, Start, Continue, Stop
+// and Song Position Pointer.
+type midiClock struct {
+	mu        sync.Mutex
+	running   bool
+	pulse     int64
+	lastPulse time.Time
+	interval  time.Duration // smoothed time between pulses
+	listeners []clockListener
+}
+
+func (c *midiClock) subscribe(listener clockListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+// bpm returns the tempo measured from the incoming pulses, or 0 before
+// enough pulses have arrived.
+func (c *midiClock) bpm() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.interval == 0 {
+		return 0
+	}
+	return float64(time.Minute) / float64(c.interval*pulsesPerQuarter)
+}
+
+// state returns whether the clock runs and its position in pulses.
+func (c *midiClock) state() (bool, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running, c.pulse
+}
+
+// handleMessage processes a system real-time or Song Position Pointer
+// message received at now.
+func (c *midiClock) handleMessage(msg []byte, now time.Time) {
+	c.mu.Lock()
+	var notify func(clockListener)
+	switch msg[0] {
+	case 0xF8: // Timing Clock
+		if !c.lastPulse.IsZero() {
+			elapsed := now.Sub(c.lastPulse)
+			if c.interval == 0 || elapsed > 4*c.interval {
+				// First pulse after a pause: take the measurement as is.
+				c.interval = elapsed
+			} else {
+				// Smooth out USB and scheduling jitter.
+				c.interval += (elapsed - c.interval) / 8
+			}
+		}
+		c.lastPulse = now
+		if c.running {
+			pulse := c.pulse
+			c.pulse++
+			notify = func(l clockListener) { l.clockPulse(pulse) }
+		}
+	case 0xFA: // Start
+		c.running = true
+		c.pulse = 0
+		slog.Info("MIDI clock start")
+	case 0xFB: // Continue
+		c.running = true
+		slog.Info("MIDI clock continue")
+	case 0xFC: // Stop
+		if c.running {
+			c.running = false
+			notify = func(l clockListener) { l.clockStop() }
+		}
+		slog.Info("MIDI clock stop")
+	case 0xF2: // Song Position Pointer, in sixteenth notes
+		if len(msg) >= 3 {
+			c.pulse = int64(int(msg[1])|int(msg[2])<<7) * pulsesPerQuarter / 4
+		}
+	}
+	listeners := c.listeners
+	c.mu.Unlock()
+
+	if notify != nil {
+		for _, l := range listeners {
+			notify(l)
+		}
+	}
+}
+
+// internalClock is a free-running timebase at a fixed tempo, used when no
+// external clock drives tempo-synced features.
+type internalClock struct {
+	tempo     float64
+	lock      sync.Locker // held while listeners run
+	listeners []clockListener
+}
+
+func newInternalClock(tempo float64, lock sync.Locker) *internalClock {
+	return &internalClock{tempo: tempo, lock: lock}
+}
+
+func (c *internalClock) bpm() float64 {
+	return c.tempo
+}
+
+// subscribe adds a listener; it must be called before start.
+func (c *internalClock) subscribe(listener clockListener) {
+	c.listeners = append(c.listeners, listener)
+}
+
+// start runs the clock in the background.
+func (c *internalClock) start() {
+	interval := time.Duration(float64(time.Minute) / (c.tempo * pulsesPerQuarter))
+	go func() {
+		next := time.Now()
+		for pulse := int64(0); ; pulse++ {
+			c.lock.Lock()
+			for _, l := range c.listeners {
+				l.clockPulse(pulse)
+			}
+			c.lock.Unlock()
+			// Schedule from the previous deadline so the tempo doesn't drift.
+			next = next.Add(interval)
+			time.Sleep(time.Until(next))
+		}
+	}()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// config is the JSON configuration file given with -config.
+type config struct {
+	Zones      []zoneConfig       `json:"zones"`
+	Chord      *chordConfig       `json:"chord"`
+	Profiles   map[string]profile `json:"profiles"`
+	Bindings   []bindingConfig    `json:"bindings"` // MIDI learn
+	Scenes     []sceneConfig      `json:"scenes"`
+	Instances  []instanceConfig   `json:"instances"`  // extra sound modules
+	NoteRanges []noteRangeConfig  `json:"noteRanges"` // keys the -midi-in port responds to
+	ChannelMap channelMap         `json:"channelMap"` // channels of the MIDI input remapped
+	Inputs     []inputConfig      `json:"inputs"`     // further MIDI inputs
+	BendRanges []bendRangeConfig  `json:"bendRanges"` // pitch bend ranges of channels
+}
+
+// profile is a named set of command line settings selected with -profile,
+// e.g. {"soundfont": "stage.sf2", "midi-in": "Keystation", "sample-rate": 44100}.
+type profile map[string]flagValue
+
+// flagValue is a setting of a profile, written as a JSON string, number or
+// boolean.
+type flagValue string
+
+func (v *flagValue) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = flagValue(s)
+		return nil
+	}
+	var scalar any
+	if err := json.Unmarshal(data, &scalar); err != nil {
+		return err
+	}
+	switch scalar.(type) {
+	case float64, bool:
+		*v = flagValue(data)
+		return nil
+	}
+	return fmt.Errorf("setting must be a string, number or boolean")
+}
+
+// applyProfile applies the settings of a profile. Flags given on the
+// command line take precedence.
+func (c *config) applyProfile(name string) error {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(slices.Sorted(maps.Keys(c.Profiles)), ", "))
+	}
+	for _, key := range slices.Sorted(maps.Keys(p)) {
+		if key == "config" || key == "profile" || flag.Lookup(key) == nil {
+			return fmt.Errorf("profile %q: unknown setting %q", name, key)
+		}
+		if flagSet(key) {
+			continue
+		}
+		if err := flag.Set(key, string(p[key])); err != nil {
+			return fmt.Errorf("profile %q: %s: %w", name, key, err)
+		}
+	}
+	return nil
+}
+
+// chordConfig enables chord memory: every key plays a chord shape.
+type chordConfig struct {
+	Input     int   `json:"input"`     // incoming channel 1-16, 0 for all
+	Intervals []int `json:"intervals"` // semitones from the played key, e.g. [0, 4, 7]
+}
+
+// zoneConfig maps a key range of an incoming channel to a synthesizer
+// channel. Channels are numbered 1-16. Zones with overlapping ranges layer
+// several sounds.
+type zoneConfig struct {
+	Input   int        `json:"input"`   // incoming channel
+	Low     noteNumber `json:"low"`     // lowest key, default 0
+	High    noteNumber `json:"high"`    // highest key, default 127
+	Channel int        `json:"channel"` // synthesizer channel the keys play on
+	Bank    *int32     `json:"bank"`    // bank selected on channel, optional
+	Program *int32     `json:"program"` // program selected on channel, optional
+	// Transpose shifts the zone's keys by semitones.
+	Transpose int `json:"transpose"`
+	// Level scales the channel volume (CC7) of the zone, default 1.
+	Level float64 `json:"level"`
+}
+
+func (z *zoneConfig) UnmarshalJSON(data []byte) error {
+	type plain zoneConfig
+	p := plain{High: 127, Level: 1}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*z = zoneConfig(p)
+	return nil
+}
+
+// noteNumber is a MIDI key, written in the config as a number or a note
+// name such as "C4" (middle C, 60), "F#2" or "Bb-1".
+type noteNumber byte
+
+func (n *noteNumber) UnmarshalJSON(data []byte) error {
+	var number int
+	if err := json.Unmarshal(data, &number); err == nil {
+		if number < 0 || number > 127 {
+			return fmt.Errorf("key %d out of range 0-127", number)
+		}
+		*n = noteNumber(number)
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("key must be a number or a note name")
+	}
+	key, err := parseNoteName(name)
+	if err != nil {
+		return err
+	}
+	*n = noteNumber(key)
+	return nil
+}
+
+// parseNoteName converts a note name with octave to a key, with C4 = 60.
+func parseNoteName(name string) (byte, error) {
+	semitones := map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+	s := strings.TrimSpace(name)
+	if s == "" {
+		return 0, fmt.Errorf("invalid note name %q", name)
+	}
+	key, ok := semitones[strings.ToUpper(s[:1])[0]]
+	if !ok {
+		return 0, fmt.Errorf("invalid note name %q", name)
+	}
+	s = s[1:]
+	if rest, sharp := strings.CutPrefix(s, "#"); sharp {
+		key, s = key+1, rest
+	} else if rest, flat := strings.CutPrefix(s, "b"); flat {
+		key, s = key-1, rest
+	}
+	octave, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid note name %q", name)
+	}
+	key += (octave + 1) * 12
+	if key < 0 || key > 127 {
+		return 0, fmt.Errorf("note %q out of range", name)
+	}
+	return byte(key), nil
+}
+
+// loadConfig reads a configuration file.
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, z := range c.Zones {
+		if err := z.validate(); err != nil {
+			return nil, fmt.Errorf("%s: zone %d: %w", path, i+1, err)
+		}
+	}
+	if c.Chord != nil {
+		if err := c.Chord.validate(); err != nil {
+			return nil, fmt.Errorf("%s: chord: %w", path, err)
+		}
+	}
+	for i, s := range c.Scenes {
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("%s: scene %d: %w", path, i+1, err)
+		}
+	}
+	for i, r := range c.BendRanges {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%s: bend range %d: %w", path, i+1, err)
+		}
+	}
+	for i, r := range c.NoteRanges {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("%s: note range %d: %w", path, i+1, err)
+		}
+	}
+	if err := c.ChannelMap.validate(); err != nil {
+		return nil, fmt.Errorf("%s: channel map: %w", path, err)
+	}
+	for i, in := range c.Inputs {
+		if err := in.validate(); err != nil {
+			return nil, fmt.Errorf("%s: input %d: %w", path, i+1, err)
+		}
+	}
+	for i, inst := range c.Instances {
+		if err := inst.validate(); err != nil {
+			return nil, fmt.Errorf("%s: instance %d: %w", path, i+1, err)
+		}
+	}
+	for i, b := range c.Bindings {
+		if err := b.validate(); err != nil {
+			return nil, fmt.Errorf("%s: binding %d: %w", path, i+1, err)
+		}
+	}
+	return &c, nil
+}
+
+func (z *zoneConfig) validate() error {
+	if z.Input < 1 || z.Input > channelCount {
+		return fmt.Errorf("input channel %d out of range 1-16", z.Input)
+	}
+	if z.Channel < 1 || z.Channel > channelCount {
+		return fmt.Errorf("channel %d out of range 1-16", z.Channel)
+	}
+	if z.Low > z.High {
+		return fmt.Errorf("low key %d is above high key %d", z.Low, z.High)
+	}
+	if z.Program != nil && (*z.Program < 0 || *z.Program > 127) {
+		return fmt.Errorf("program %d out of range 0-127", *z.Program)
+	}
+	if z.Transpose < -48 || z.Transpose > 48 {
+		return fmt.Errorf("transpose %d out of range -48-48", z.Transpose)
+	}
+	if z.Level < 0 || z.Level > 1 {
+		return fmt.Errorf("level %v out of range 0-1", z.Level)
+	}
+	if z.Bank != nil && z.Program == nil {
+		return fmt.Errorf("bank requires a program")
+	}
+	return nil
+}
+
+func (c *chordConfig) validate() error {
+	if c.Input < 0 || c.Input > channelCount {
+		return fmt.Errorf("input channel %d out of range 0-16", c.Input)
+	}
+	if len(c.Intervals) == 0 {
+		return fmt.Errorf("no intervals")
+	}
+	for _, interval := range c.Intervals {
+		if interval < -48 || interval > 48 {
+			return fmt.Errorf("interval %d out of range -48-48", interval)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// defaultSocketPath is where the control socket listens unless -socket is
+// given.
+func defaultSocketPath() string {
+	return filepath.Join(os.TempDir(), "meltysynth-test.sock")
+}
+
+const socketHelp = "commands: load <sound font>, program <channel> <program|bank:program>, scene <name|number>, panic, learn <action>, quit"
+
+// controlSocket accepts line based commands on a Unix socket, for running
+// as a service. Each command is answered with "ok" or "error: <reason>".
+// Unix sockets also work on Windows 10 and later.
+type controlSocket struct {
+	handler  *midiHandler
+	renderer *renderer
+	listener net.Listener
+	quit     chan struct{} // closed by the quit command
+	quitOnce sync.Once
+}
+
+// listenControlSocket starts serving commands on a socket. A stale socket
+// file left by a previous run is replaced.
+func listenControlSocket(path string, handler *midiHandler, render *renderer) (*controlSocket, error) {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another instance", path)
+	}
+	os.Remove(path)
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &controlSocket{handler: handler, renderer: render, listener: listener, quit: make(chan struct{})}
+	go s.serve()
+	return s, nil
+}
+
+// Close stops listening and removes the socket file.
+func (s *controlSocket) Close() error {
+	return s.listener.Close()
+}
+
+func (s *controlSocket) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Warn("Control socket", "err", err)
+			continue
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *controlSocket) handleConn(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		reply := "ok"
+		if err := s.execute(line); err != nil {
+			reply = "error: " + err.Error()
+		}
+		slog.Info("Control command", "command", line, "reply", reply)
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs one command.
+func (s *controlSocket) execute(line string) error {
+	command, args, _ := strings.Cut(line, " ")
+	args = strings.TrimSpace(args)
+	switch command {
+	case "load":
+		if args == "" {
+			return fmt.Errorf("usage: load <sound font>")
+		}
+		return s.handler.switchSoundFont(args, s.renderer)
+	case "program":
+		fields := strings.Fields(args)
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: program <channel> <program|bank:program>")
+		}
+		channel, err := strconv.Atoi(fields[0])
+		if err != nil || channel < 1 || channel > channelCount {
+			return fmt.Errorf("invalid channel %q", fields[0])
+		}
+		ref, err := parsePresetRef(fields[1])
+		if err != nil {
+			return err
+		}
+		h := s.handler
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if strings.Contains(fields[1], ":") {
+			h.banks[channel-1] = ref.bank
+		}
+		h.programChange(int32(channel-1), ref.program)
+		return nil
+	case "panic":
+		s.handler.panic()
+		return nil
+	case "scene":
+		if args == "" {
+			return fmt.Errorf("usage: scene <name|number>")
+		}
+		return s.handler.selectScene(args)
+	case "learn":
+		if args == "" {
+			return fmt.Errorf("usage: learn <%s>", strings.Join(appActions, "|"))
+		}
+		return s.handler.learn(args)
+	case "quit":
+		s.quitOnce.Do(func() { close(s.quit) })
+		return nil
+	case "help":
+		return fmt.Errorf("%s", socketHelp)
+	}
+	return fmt.Errorf("unknown command %q (%s)", command, socketHelp)
+}
+
+// switchSoundFont loads another sound font, keeping the channel state.
+func (h *midiHandler) switchSoundFont(path string, render *renderer) error {
+	soundFont, err := loadSoundFont(path)
+	if err != nil {
+		return err
+	}
+	if err := h.useSoundFont(soundFont, path, render); err != nil {
+		return err
+	}
+	slog.Info("Sound font loaded", "path", path)
+	return nil
+}
+
+// useSoundFont switches to a loaded sound font, keeping the channel state.
+func (h *midiHandler) useSoundFont(soundFont *meltysynth.SoundFont, path string, render *renderer) error {
+	h.mu.Lock()
+	old := h.synthesizer
+	h.mu.Unlock()
+	settings := &meltysynth.SynthesizerSettings{
+		SampleRate:            old.SampleRate,
+		BlockSize:             old.BlockSize,
+		MaximumPolyphony:      old.MaximumPolyphony,
+		EnableReverbAndChorus: old.EnableReverbAndChorus,
+	}
+	synthesizer, err := meltysynth.NewSynthesizer(soundFont, settings)
+	if err != nil {
+		return err
+	}
+	synthesizer.MasterVolume = old.MasterVolume
+	h.replaceSynthesizer(synthesizer, path)
+	render.replaceSynthesizer(synthesizer)
+	return nil
+}
+
+// replaceSynthesizer switches to a new synthesizer, keeping the channel
+// state, e.g. after loading another sound font.
+func (h *midiHandler) replaceSynthesizer(synthesizer *meltysynth.Synthesizer, soundFont string) {
+	saved := h.session()
+	h.mu.Lock()
+	h.synthesizer = synthesizer
+	h.soundFont = soundFont
+	h.presets = newPresetIndex(synthesizer.SoundFont)
+	if h.mpe != nil {
+		h.mpe = newMpeZone(synthesizer, h.mpe.bendRange)
+	}
+	if h.tuning != nil {
+		h.useTuning(h.tuning.table)
+	}
+	h.sendBendRanges()
+	if h.masterTune != 0 {
+		h.applyMasterTuning()
+	}
+	h.mu.Unlock()
+	h.restoreSession(saved)
+}
+
+// panic silences every channel at once and forgets held arpeggiator notes.
+func (h *midiHandler) panic() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.synthesizer.NoteOffAll(true)
+	if h.stuck != nil {
+		h.stuck.clear(-1)
+	}
+	if h.humanize != nil {
+		h.humanize.clear()
+	}
+	if h.arp != nil {
+		h.arp.held = nil
+		h.arp.pressed = 0
+		h.arp.clockStop()
+	}
+	if h.tuning != nil {
+		h.tuning.reset()
+	}
+}
+
+// runClient sends a command to a running instance and prints the reply.
+func runClient(args []string) {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socketPath := fs.String("socket", defaultSocketPath(), "control socket of the running instance")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s ctl [-socket path] <command>\n%s\n", os.Args[0], socketHelp)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := net.Dial("unix", *socketPath)
+	if err != nil {
+		fatal("Failed to connect", "err", err)
+	}
+	defer conn.Close()
+	if _, err := fmt.Fprintln(conn, strings.Join(fs.Args(), " ")); err != nil {
+		fatal("Failed to send command", "err", err)
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		fatal("No reply", "err", err)
+	}
+	reply = strings.TrimSpace(reply)
+	fmt.Println(reply)
+	if strings.HasPrefix(reply, "error:") {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattrtaylor/go-rtmidi"
+)
+
+// runDevices implements the devices command: it lists the audio outputs
+// of every backend and the MIDI ports, with the names the flags and the
+// config file accept.
+func runDevices(args []string) {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	fs.Parse(args)
+
+	fmt.Println("Audio outputs (-audio)")
+	fmt.Println("  oto: the system's default output")
+	for _, card := range alsaCards() {
+		fmt.Printf("    ALSA card %s\n", card)
+	}
+	if rate, frames, ports, err := listJackPlayback(); err != nil {
+		fmt.Printf("  jack: unavailable: %v\n", err)
+	} else {
+		fmt.Printf("  jack: %d Hz, %d frames per period\n", rate, frames)
+		for _, port := range ports {
+			fmt.Printf("    %s\n", port)
+		}
+	}
+	if names, err := listWasapiDevices(); err != nil {
+		fmt.Printf("  wasapi-exclusive: unavailable: %v\n", err)
+	} else {
+		fmt.Println("  wasapi-exclusive: plays to the default device")
+		for _, name := range names {
+			fmt.Printf("    %s\n", name)
+		}
+	}
+
+	fmt.Println("MIDI inputs (-midi-in, midiIn of instances)")
+	if in, err := rtmidi.NewMIDIInDefault(); err != nil {
+		fmt.Printf("  unavailable: %v\n", err)
+	} else {
+		names, err := midiInputNames(in)
+		in.Close()
+		printPorts(names, err)
+	}
+	fmt.Println("MIDI outputs (-midi-out)")
+	if out, err := rtmidi.NewMIDIOutDefault(); err != nil {
+		fmt.Printf("  unavailable: %v\n", err)
+	} else {
+		names, err := midiOutputNames(out)
+		out.Close()
+		printPorts(names, err)
+	}
+}
+
+// printPorts lists numbered ports.
+func printPorts(names []string, err error) {
+	switch {
+	case err != nil:
+		fmt.Printf("  unavailable: %v\n", err)
+	case len(names) == 0:
+		fmt.Println("  none")
+	}
+	for i, name := range names {
+		fmt.Printf("  %d: %s\n", i, name)
+	}
+}
+
+// midiOutputNames lists the MIDI output ports.
+func midiOutputNames(out rtmidi.MIDIOut) ([]string, error) {
+	count, err := out.PortCount()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, count)
+	for i := range names {
+		if names[i], err = out.PortName(i); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
+
+// alsaCards lists the sound cards known to ALSA on Linux, behind oto's
+// default output.
+func alsaCards() []string {
+	f, err := os.Open("/proc/asound/cards")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var cards []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// " 0 [PCH            ]: HDA-Intel - HDA Intel PCH", then a
+		// second, indented line with details.
+		line := strings.TrimSpace(scanner.Text())
+		number, rest, ok := strings.Cut(line, " [")
+		if !ok {
+			continue
+		}
+		if _, name, ok := strings.Cut(rest, "]: "); ok {
+			cards = append(cards, number+": "+name)
+		}
+	}
+	return cards
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// ditherModes are the accepted values of -dither.
+var ditherModes = []string{"off", "tpdf", "shaped"}
+
+// ditherer converts float samples to 16 bits. TPDF dither turns the
+// quantization error into a constant, signal-independent hiss instead of
+// the distortion otherwise heard on quiet reverb tails and fades; noise
+// shaping pushes that hiss up towards frequencies the ear is less
+// sensitive to. Each output needs its own ditherer, as it keeps state.
+type ditherer struct {
+	mode  string
+	rng   *rand.Rand
+	error [2]float64 // last quantization error of each channel, for shaping
+}
+
+func newDitherer(mode string) (*ditherer, error) {
+	switch mode {
+	case "off", "tpdf", "shaped":
+	default:
+		return nil, fmt.Errorf("unknown dither mode %q (available: off, tpdf, shaped)", mode)
+	}
+	return &ditherer{mode: mode, rng: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))}, nil
+}
+
+// int16s converts interleaved stereo samples and appends them to dst.
+func (d *ditherer) int16s(dst []int16, samples []float32) []int16 {
+	for i, v := range samples {
+		x := float64(max(-1, min(1, v))) * math.MaxInt16
+		if d.mode == "off" {
+			dst = append(dst, int16(math.Round(x)))
+			continue
+		}
+		channel := i % 2
+		if d.mode == "shaped" {
+			x -= d.error[channel]
+		}
+		// The sum of two uniform values has the triangular distribution,
+		// spanning one step either side.
+		y := math.Round(x + d.rng.Float64() - d.rng.Float64())
+		y = max(math.MinInt16, min(math.MaxInt16, y))
+		d.error[channel] = max(-1, min(1, y-x)) // clipping mustn't feed back
+		dst = append(dst, int16(y))
+	}
+	return dst
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// DLS articulation destinations mapped to SF2 generators. Only connections
+// without a source (constant values) are used.
+var dlsDestinations = map[uint16]uint16{
+	0x0004: genPan,
+	0x0206: genAttackVolEnv,
+	0x0207: genDecayVolEnv,
+	0x0209: genReleaseVolEnv,
+	0x020A: genSustainVolEnv,
+	0x020C: genHoldVolEnv,
+}
+
+// dlsDrums is the bank flag of drum instruments.
+const dlsDrums = 0x80000000
+
+// dlsSample is the unity note, tuning, gain and loop of a wave, from a wsmp
+// chunk.
+type dlsSample struct {
+	unityNote  int
+	fineTune   int // cents
+	gain       int // centibels
+	loopType   int // 0 forward, 1 loop until release, -1 none
+	loopStart  int
+	loopLength int
+}
+
+// parseWsmp parses a wsmp chunk.
+func parseWsmp(data []byte) (*dlsSample, error) {
+	if len(data) < 20 {
+		return nil, fmt.Errorf("invalid wsmp chunk")
+	}
+	size := int(binary.LittleEndian.Uint32(data))
+	s := &dlsSample{
+		unityNote: int(binary.LittleEndian.Uint16(data[4:])),
+		fineTune:  int(int16(binary.LittleEndian.Uint16(data[6:]))),
+		gain:      int(int32(binary.LittleEndian.Uint32(data[8:]))) / 65536,
+		loopType:  -1,
+	}
+	if binary.LittleEndian.Uint32(data[16:]) > 0 && len(data) >= size+16 {
+		loop := data[size:]
+		s.loopType = int(binary.LittleEndian.Uint32(loop[4:]))
+		s.loopStart = int(binary.LittleEndian.Uint32(loop[8:]))
+		s.loopLength = int(binary.LittleEndian.Uint32(loop[12:]))
+	}
+	return s, nil
+}
+
+// dlsWave is a wave of the wave pool.
+type dlsWave struct {
+	sound  *wavSound
+	sample *dlsSample // may be nil
+}
+
+// dlsSampleKey identifies a converted sample by wave and loop.
+type dlsSampleKey struct {
+	wave               int
+	loopStart, loopEnd int
+}
+
+// loadDls reads a DLS level 1 or 2 bank and converts it to a sound font.
+// Instruments keep their bank and program, drum instruments go to bank 128.
+// Regions keep their key and velocity ranges, key groups, tuning, gain,
+// loops and the constant parts of the volume envelope and pan; modulators,
+// LFOs and filters are not converted.
+func loadDls(path string) (*meltysynth.SoundFont, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "DLS " {
+		return nil, fmt.Errorf("%s: not a DLS file", path)
+	}
+
+	var (
+		offsets []uint32
+		pool    []byte
+		lins    []riffEntry
+		name    = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	)
+	for _, chunk := range readRiffChunks(data[12:]) {
+		switch chunk.id {
+		case "ptbl":
+			if len(chunk.data) < 8 {
+				return nil, fmt.Errorf("%s: invalid pool table", path)
+			}
+			size := binary.LittleEndian.Uint32(chunk.data)
+			count := binary.LittleEndian.Uint32(chunk.data[4:])
+			for i := range count {
+				pos := int(size + i*4)
+				if pos+4 > len(chunk.data) {
+					return nil, fmt.Errorf("%s: invalid pool table", path)
+				}
+				offsets = append(offsets, binary.LittleEndian.Uint32(chunk.data[pos:]))
+			}
+		case "LIST":
+			switch listType, sub := chunk.list(); listType {
+			case "wvpl":
+				pool = chunk.data[4:]
+			case "lins":
+				lins = sub
+			case "INFO":
+				if n := infoName(sub); n != "" {
+					name = n
+				}
+			}
+		}
+	}
+
+	waves := make([]dlsWave, len(offsets))
+	for i, offset := range offsets {
+		var entries []riffEntry
+		if int(offset) < len(pool) {
+			entries = readRiffChunks(pool[offset:])
+		}
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("%s: wave %d out of range", path, i)
+		}
+		_, chunks := entries[0].list()
+		sound, err := decodeWave(chunks)
+		if err != nil {
+			return nil, fmt.Errorf("%s: wave %d: %w", path, i, err)
+		}
+		waves[i].sound = sound
+		for _, c := range chunks {
+			if c.id == "wsmp" {
+				if waves[i].sample, err = parseWsmp(c.data); err != nil {
+					return nil, fmt.Errorf("%s: wave %d: %w", path, i, err)
+				}
+			}
+		}
+	}
+
+	bank := &sf2Bank{name: name}
+	samples := make(map[dlsSampleKey]int)
+	for _, chunk := range lins {
+		listType, ins := chunk.list()
+		if listType != "ins " {
+			continue
+		}
+		if err := bank.addDlsInstrument(ins, waves, samples); err != nil {
+			return nil, fmt.Errorf("%s: instrument %d: %w", path, len(bank.instruments)+1, err)
+		}
+	}
+	if len(bank.presets) == 0 {
+		return nil, fmt.Errorf("%s: no instruments", path)
+	}
+	return bank.soundFont()
+}
+
+// infoName returns the name in an INFO list.
+func infoName(info []riffEntry) string {
+	for _, c := range info {
+		if c.id == "INAM" {
+			return strings.TrimRight(string(c.data), "\x00")
+		}
+	}
+	return ""
+}
+
+// addDlsInstrument converts an instrument and adds it with its preset.
+func (b *sf2Bank) addDlsInstrument(chunks []riffEntry, waves []dlsWave, samples map[dlsSampleKey]int) error {
+	var (
+		header      []byte
+		regions     []riffEntry
+		articulator []sf2Generator
+		name        string
+	)
+	for _, c := range chunks {
+		switch c.id {
+		case "insh":
+			header = c.data
+		case "LIST":
+			switch listType, sub := c.list(); listType {
+			case "lrgn":
+				regions = sub
+			case "lart", "lar2":
+				articulator = dlsArticulation(sub)
+			case "INFO":
+				name = infoName(sub)
+			}
+		}
+	}
+	if len(header) < 12 {
+		return fmt.Errorf("missing instrument header")
+	}
+	bankNumber := binary.LittleEndian.Uint32(header[4:])
+	program := int(binary.LittleEndian.Uint32(header[8:]) & 0x7F)
+	presetBank := int(bankNumber>>8) & 0x7F
+	if bankNumber&dlsDrums != 0 {
+		presetBank = 128
+	}
+	if name == "" {
+		name = fmt.Sprintf("%d:%d", presetBank, program)
+	}
+
+	instrument := sf2Instrument{name: name}
+	for _, r := range regions {
+		listType, sub := r.list()
+		if listType != "rgn " && listType != "rgn2" {
+			continue
+		}
+		zone, err := b.dlsZone(sub, articulator, waves, samples)
+		if err != nil {
+			return fmt.Errorf("region %d: %w", len(instrument.zones)+1, err)
+		}
+		instrument.zones = append(instrument.zones, zone)
+	}
+	b.presets = append(b.presets, sf2Preset{name: name, bank: presetBank, program: program, instrument: len(b.instruments)})
+	b.instruments = append(b.instruments, instrument)
+	return nil
+}
+
+// dlsArticulation converts the constant connections of an articulation list.
+func dlsArticulation(chunks []riffEntry) []sf2Generator {
+	var generators []sf2Generator
+	for _, c := range chunks {
+		if (c.id != "art1" && c.id != "art2") || len(c.data) < 8 {
+			continue
+		}
+		size := int(binary.LittleEndian.Uint32(c.data))
+		count := int(binary.LittleEndian.Uint32(c.data[4:]))
+		for i := range count {
+			block := c.data[min(len(c.data), size+i*12):]
+			if len(block) < 12 {
+				break
+			}
+			source := binary.LittleEndian.Uint16(block)
+			control := binary.LittleEndian.Uint16(block[2:])
+			op, ok := dlsDestinations[binary.LittleEndian.Uint16(block[4:])]
+			if source != 0 || control != 0 || !ok {
+				continue
+			}
+			scale := int32(binary.LittleEndian.Uint32(block[8:]))
+			amount := int(scale / 65536)
+			switch op {
+			case genSustainVolEnv:
+				// DLS gives the sustain level in tenths of a percent, SF2 as
+				// attenuation in centibels.
+				amount = 1440
+				if level := float64(scale) / 65536 / 1000; level > 0 {
+					amount = int(math.Round(-200 * math.Log10(min(level, 1))))
+				}
+			case genAttackVolEnv, genDecayVolEnv, genReleaseVolEnv, genHoldVolEnv:
+				if scale == math.MinInt32 {
+					amount = -12000
+				}
+			}
+			generators = append(generators, sf2Generator{op: op, amount: int16(max(math.MinInt16, min(math.MaxInt16, amount)))})
+		}
+	}
+	return generators
+}
+
+// dlsZone converts a region. Region articulation replaces the instrument's.
+func (b *sf2Bank) dlsZone(chunks []riffEntry, articulator []sf2Generator, waves []dlsWave, samples map[dlsSampleKey]int) (sf2Zone, error) {
+	var (
+		header, link []byte
+		sample       *dlsSample
+		err          error
+	)
+	for _, c := range chunks {
+		switch c.id {
+		case "rgnh":
+			header = c.data
+		case "wlnk":
+			link = c.data
+		case "wsmp":
+			if sample, err = parseWsmp(c.data); err != nil {
+				return sf2Zone{}, err
+			}
+		case "LIST":
+			if listType, sub := c.list(); listType == "lart" || listType == "lar2" {
+				articulator = dlsArticulation(sub)
+			}
+		}
+	}
+	if len(header) < 12 || len(link) < 12 {
+		return sf2Zone{}, fmt.Errorf("missing region header or wave link")
+	}
+	index := int(binary.LittleEndian.Uint32(link[8:]))
+	if index >= len(waves) {
+		return sf2Zone{}, fmt.Errorf("wave %d out of range", index)
+	}
+	wave := waves[index]
+	if sample == nil {
+		sample = wave.sample
+	}
+	if sample == nil {
+		sample = &dlsSample{unityNote: 60, loopType: -1}
+	}
+
+	z := sf2Zone{
+		keyLow:  int(min(127, binary.LittleEndian.Uint16(header))),
+		keyHigh: int(min(127, binary.LittleEndian.Uint16(header[2:]))),
+		velLow:  int(min(127, binary.LittleEndian.Uint16(header[4:]))),
+		velHigh: int(min(127, binary.LittleEndian.Uint16(header[6:]))),
+	}
+	if keyGroup := int(binary.LittleEndian.Uint16(header[10:])); keyGroup != 0 {
+		z.set(genExclusiveClass, keyGroup)
+	}
+	z.set(genOverridingRootKey, sample.unityNote)
+	z.set(genFineTune, sample.fineTune)
+	if sample.gain < 0 {
+		z.set(genInitialAttenuation, -sample.gain)
+	}
+
+	frames := len(wave.sound.channels[0])
+	loopStart, loopEnd := 0, frames
+	switch sample.loopType {
+	case 0, 1:
+		if sample.loopStart+sample.loopLength <= frames && sample.loopLength > 0 {
+			loopStart, loopEnd = sample.loopStart, sample.loopStart+sample.loopLength
+			z.set(genSampleModes, 1+2*sample.loopType)
+		}
+	}
+	z.generators = append(z.generators, articulator...)
+
+	key := dlsSampleKey{index, loopStart, loopEnd}
+	id, ok := samples[key]
+	if !ok {
+		id = len(b.samples)
+		b.samples = append(b.samples, sf2Sample{
+			name:       fmt.Sprintf("wave %d", index),
+			data:       wave.sound.channels[0],
+			sampleRate: wave.sound.sampleRate,
+			rootKey:    sample.unityNote,
+			loopStart:  loopStart,
+			loopEnd:    loopEnd,
+		})
+		samples[key] = id
+	}
+	z.sample = id
+	return z, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// isURL reports whether a -soundfont argument is an http(s) URL.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// cachedDownload returns the local copy of a URL, downloading it into the
+// user cache directory the first time.
+func cachedDownload(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cacheDir, "meltysynth-test", "soundfonts")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	// The file name keeps the extension, which selects the loader.
+	sum := sha256.Sum256([]byte(rawURL))
+	name := hex.EncodeToString(sum[:8]) + "-" + path.Base(u.Path)
+	cached := filepath.Join(dir, name)
+	if _, err := os.Stat(cached); err == nil {
+		slog.Info("Using cached sound font", "url", rawURL, "path", cached)
+		return cached, nil
+	}
+
+	resp, err := http.Get(rawURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("download %s: %s", rawURL, resp.Status)
+	}
+
+	// Download to a temporary file so an interrupted download isn't cached.
+	tmp, err := os.CreateTemp(dir, name+".*.part")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	progress := &downloadProgress{url: rawURL, total: resp.ContentLength, start: time.Now()}
+	if _, err := io.Copy(tmp, io.TeeReader(resp.Body, progress)); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("download %s: %w", rawURL, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return "", err
+	}
+	slog.Info("Downloaded sound font", "url", rawURL, "path", cached, "bytes", progress.done,
+		"duration", time.Since(progress.start).Round(time.Millisecond))
+	return cached, nil
+}
+
+// downloadProgress logs the progress of a download about once a second.
+type downloadProgress struct {
+	url     string
+	total   int64 // -1 if unknown
+	done    int64
+	start   time.Time
+	lastLog time.Time
+}
+
+func (p *downloadProgress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if now := time.Now(); now.Sub(p.lastLog) >= time.Second {
+		p.lastLog = now
+		if p.total > 0 {
+			slog.Info("Downloading sound font", "url", p.url, "percent", p.done*100/p.total)
+		} else {
+			slog.Info("Downloading sound font", "url", p.url, "bytes", p.done)
+		}
+	}
+	return len(b), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// gmDrumChannels returns the General MIDI drum channel layout: channel 10 only.
+func gmDrumChannels() [channelCount]bool {
+	var drums [channelCount]bool
+	drums[percussionChannel] = true
+	return drums
+}
+
+// parseChannelList parses comma separated channel numbers (1-16), or "none".
+func parseChannelList(s string) ([channelCount]bool, error) {
+	var channels [channelCount]bool
+	if s == "none" {
+		return channels, nil
+	}
+	for _, field := range strings.Split(s, ",") {
+		channel, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || channel < 1 || channel > channelCount {
+			return channels, fmt.Errorf("invalid channel %q", field)
+		}
+		channels[channel-1] = true
+	}
+	return channels, nil
+}
+
+// applyDrumChannels selects the drum bank on the drum channels and bank 0
+// on all others, so program changes there pick bank 128 presets. Channel 10
+// becomes melodic if it isn't listed. Channels that differ from the
+// synthesizer's power-on layout also get program 0, so they switch between
+// a kit and a melodic preset right away.
+func (h *midiHandler) applyDrumChannels() {
+	power := defaultBanks()
+	for ch := range int32(channelCount) {
+		h.setDrumChannel(ch, h.drums[ch])
+		if h.banks[ch] != power[ch] {
+			h.programChange(ch, 0)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Effect processes a block of the master bus in place. Effects run on the
+// render goroutine, so Process must not block.
+type Effect interface {
+	Process(left, right []float32)
+}
+
+// effectFactory creates an effect from its parameters.
+type effectFactory func(params map[string]float64, sampleRate int) (Effect, error)
+
+// effectTypes are the effects -effects can use. Custom effects register
+// themselves here from an init function in their own file.
+var effectTypes = map[string]effectFactory{
+	"eq":         newEqualizer,
+	"compressor": newCompressor,
+	"delay":      newDelay,
+}
+
+// parseEffects parses an effect chain like
+// "eq:low=3,high=-2;compressor:threshold=-12,ratio=4". Effects are
+// separated by semicolons and run in order.
+func parseEffects(s string, sampleRate int) ([]Effect, error) {
+	var chain []Effect
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, paramText, _ := strings.Cut(spec, ":")
+		factory, ok := effectTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown effect %q (available: %s)", name, strings.Join(slices.Sorted(maps.Keys(effectTypes)), ", "))
+		}
+		params := make(map[string]float64)
+		for _, param := range strings.Split(paramText, ",") {
+			if param = strings.TrimSpace(param); param == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(param, "=")
+			v, err := strconv.ParseFloat(value, 64)
+			if !ok || err != nil {
+				return nil, fmt.Errorf("%s: invalid parameter %q", name, param)
+			}
+			params[key] = v
+		}
+		effect, err := factory(params, sampleRate)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		chain = append(chain, effect)
+	}
+	return chain, nil
+}
+
+// effectParams reads parameters with defaults, rejecting unknown ones.
+func effectParams(params map[string]float64, defaults map[string]float64) (map[string]float64, error) {
+	for key := range params {
+		if _, ok := defaults[key]; !ok {
+			return nil, fmt.Errorf("unknown parameter %q (available: %s)", key, strings.Join(slices.Sorted(maps.Keys(defaults)), ", "))
+		}
+	}
+	out := maps.Clone(defaults)
+	maps.Copy(out, params)
+	return out, nil
+}
+
+func dbToGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
+
+// biquad is a second-order filter with the coefficients from Robert
+// Bristow-Johnson's Audio EQ Cookbook, with state for two channels.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	state              [2][4]float64 // x1, x2, y1, y2 of each channel
+}
+
+// newBiquad creates a peaking ("peak"), low shelf ("low") or high shelf
+// ("high") filter.
+func newBiquad(kind string, freq, gainDB, q float64, sampleRate int) *biquad {
+	a := math.Pow(10, gainDB/40)
+	w := 2 * math.Pi * freq / float64(sampleRate)
+	cos, sin := math.Cos(w), math.Sin(w)
+	alpha := sin / (2 * q)
+	var b0, b1, b2, a0, a1, a2 float64
+	switch kind {
+	case "low":
+		sq := 2 * math.Sqrt(a) * alpha
+		b0 = a * ((a + 1) - (a-1)*cos + sq)
+		b1 = 2 * a * ((a - 1) - (a+1)*cos)
+		b2 = a * ((a + 1) - (a-1)*cos - sq)
+		a0 = (a + 1) + (a-1)*cos + sq
+		a1 = -2 * ((a - 1) + (a+1)*cos)
+		a2 = (a + 1) + (a-1)*cos - sq
+	case "high":
+		sq := 2 * math.Sqrt(a) * alpha
+		b0 = a * ((a + 1) + (a-1)*cos + sq)
+		b1 = -2 * a * ((a - 1) + (a+1)*cos)
+		b2 = a * ((a + 1) + (a-1)*cos - sq)
+		a0 = (a + 1) - (a-1)*cos + sq
+		a1 = 2 * ((a - 1) - (a+1)*cos)
+		a2 = (a + 1) - (a-1)*cos - sq
+	default:
+		b0 = 1 + alpha*a
+		b1 = -2 * cos
+		b2 = 1 - alpha*a
+		a0 = 1 + alpha/a
+		a1 = -2 * cos
+		a2 = 1 - alpha/a
+	}
+	return &biquad{b0: b0 / a0, b1: b1 / a0, b2: b2 / a0, a1: a1 / a0, a2: a2 / a0}
+}
+
+func (f *biquad) process(channel int, samples []float32) {
+	s := &f.state[channel]
+	for i, v := range samples {
+		x := float64(v)
+		y := f.b0*x + f.b1*s[0] + f.b2*s[1] - f.a1*s[2] - f.a2*s[3]
+		s[1], s[0] = s[0], x
+		s[3], s[2] = s[2], y
+		samples[i] = float32(y)
+	}
+}
+
+// equalizer is a three band EQ: shelves for the lows and highs and a
+// peaking band in between. Gains are in dB.
+type equalizer struct {
+	bands []*biquad
+}
+
+func newEqualizer(params map[string]float64, sampleRate int) (Effect, error) {
+	p, err := effectParams(params, map[string]float64{
+		"low": 0, "low-freq": 200,
+		"mid": 0, "mid-freq": 1000, "mid-q": 0.7,
+		"high": 0, "high-freq": 5000,
+	})
+	if err != nil {
+		return nil, err
+	}
+	nyquist := float64(sampleRate) / 2
+	for _, key := range []string{"low-freq", "mid-freq", "high-freq"} {
+		if p[key] <= 0 || p[key] >= nyquist {
+			return nil, fmt.Errorf("%s must be between 0 and %g Hz", key, nyquist)
+		}
+	}
+	if p["mid-q"] <= 0 {
+		return nil, fmt.Errorf("mid-q must be positive")
+	}
+	return &equalizer{bands: []*biquad{
+		newBiquad("low", p["low-freq"], p["low"], math.Sqrt2/2, sampleRate),
+		newBiquad("peak", p["mid-freq"], p["mid"], p["mid-q"], sampleRate),
+		newBiquad("high", p["high-freq"], p["high"], math.Sqrt2/2, sampleRate),
+	}}, nil
+}
+
+func (e *equalizer) Process(left, right []float32) {
+	for _, band := range e.bands {
+		band.process(0, left)
+		band.process(1, right)
+	}
+}
+
+// compressor reduces the level above a threshold, following the louder
+// channel so the stereo image stays put.
+type compressor struct {
+	threshold float64 // dB
+	ratio     float64
+	attack    float64 // envelope coefficients per sample
+	release   float64
+	makeup    float64 // linear gain
+	envelope  float64 // dB above the threshold
+}
+
+func newCompressor(params map[string]float64, sampleRate int) (Effect, error) {
+	p, err := effectParams(params, map[string]float64{
+		"threshold": -18, "ratio": 4, "attack": 10, "release": 150, "makeup": 0,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p["ratio"] < 1 {
+		return nil, fmt.Errorf("ratio must be at least 1")
+	}
+	if p["attack"] <= 0 || p["release"] <= 0 {
+		return nil, fmt.Errorf("attack and release must be positive")
+	}
+	coefficient := func(ms float64) float64 {
+		return math.Exp(-1 / (ms / 1000 * float64(sampleRate)))
+	}
+	return &compressor{
+		threshold: p["threshold"],
+		ratio:     p["ratio"],
+		attack:    coefficient(p["attack"]),
+		release:   coefficient(p["release"]),
+		makeup:    dbToGain(p["makeup"]),
+	}, nil
+}
+
+func (c *compressor) Process(left, right []float32) {
+	for i := range left {
+		peak := math.Max(math.Abs(float64(left[i])), math.Abs(float64(right[i])))
+		over := 0.0
+		if peak > 0 {
+			over = math.Max(0, 20*math.Log10(peak)-c.threshold)
+		}
+		coefficient := c.release
+		if over > c.envelope {
+			coefficient = c.attack
+		}
+		c.envelope = over + coefficient*(c.envelope-over)
+		gain := float32(dbToGain(-c.envelope*(1-1/c.ratio)) * c.makeup)
+		left[i] *= gain
+		right[i] *= gain
+	}
+}
+
+// delay is a feedback echo. Time is in milliseconds; feedback and mix are
+// between 0 and 1.
+type delay struct {
+	buffer   [2][]float32
+	pos      int
+	feedback float32
+	mix      float32
+}
+
+func newDelay(params map[string]float64, sampleRate int) (Effect, error) {
+	p, err := effectParams(params, map[string]float64{"time": 300, "feedback": 0.3, "mix": 0.2})
+	if err != nil {
+		return nil, err
+	}
+	if p["time"] <= 0 || p["time"] > 5000 {
+		return nil, fmt.Errorf("time must be between 0 and 5000 ms")
+	}
+	if p["feedback"] < 0 || p["feedback"] >= 1 || p["mix"] < 0 || p["mix"] > 1 {
+		return nil, fmt.Errorf("feedback must be in [0, 1) and mix in [0, 1]")
+	}
+	frames := max(1, int(p["time"]/1000*float64(sampleRate)))
+	return &delay{
+		buffer:   [2][]float32{make([]float32, frames), make([]float32, frames)},
+		feedback: float32(p["feedback"]),
+		mix:      float32(p["mix"]),
+	}, nil
+}
+
+func (d *delay) Process(left, right []float32) {
+	for i := range left {
+		for channel, samples := range [2][]float32{left, right} {
+			buf := d.buffer[channel]
+			echo := buf[d.pos]
+			buf[d.pos] = samples[i] + echo*d.feedback
+			samples[i] += (echo - samples[i]) * d.mix
+		}
+		d.pos = (d.pos + 1) % len(d.buffer[0])
+	}
+}
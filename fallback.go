@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"math"
+	"math/rand/v2"
+	"os"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+//go:generate go run . write-fallback fallback.sf2
+
+// fallbackSF2 is the bank built by fallbackBank, embedded so that the
+// program has a sound font without generating one. Run go generate after
+// changing the bank.
+//
+//go:embed fallback.sf2
+var fallbackSF2 []byte
+
+// fallbackSoundFont loads the embedded fallback bank, used when no sound
+// font is available so that the synthesizer still makes sound.
+func fallbackSoundFont() (*meltysynth.SoundFont, error) {
+	return meltysynth.NewSoundFont(bytes.NewReader(fallbackSF2))
+}
+
+// runWriteFallback writes the fallback bank to a file, for go generate.
+func runWriteFallback(args []string) {
+	if len(args) != 1 {
+		fatal("Usage: write-fallback FILE")
+	}
+	if err := os.WriteFile(args[0], fallbackBank().bytes(), 0o644); err != nil {
+		fatal("Failed to write the fallback bank", "err", err)
+	}
+}
+
+// fallbackFamily is one of the 16 GM instrument families of the fallback
+// bank, each played by a single waveform and envelope.
+type fallbackFamily struct {
+	name    string
+	wave    string
+	attack  float64 // seconds
+	decay   float64 // seconds
+	sustain float64 // level 0-1
+	release float64 // seconds
+}
+
+var fallbackFamilies = [16]fallbackFamily{
+	{"Piano", "triangle", 0, 3, 0, 0.3},
+	{"Chromatic Percussion", "sine", 0, 1, 0, 0.3},
+	{"Organ", "square", 0, 0, 1, 0.05},
+	{"Guitar", "saw", 0, 2, 0, 0.2},
+	{"Bass", "triangle", 0, 1.5, 0.3, 0.1},
+	{"Strings", "saw", 0.1, 0, 1, 0.3},
+	{"Ensemble", "saw", 0.2, 0, 1, 0.5},
+	{"Brass", "saw", 0.05, 0.5, 0.7, 0.1},
+	{"Reed", "square", 0.03, 0.5, 0.8, 0.1},
+	{"Pipe", "sine", 0.05, 0, 1, 0.1},
+	{"Synth Lead", "square", 0, 0, 1, 0.1},
+	{"Synth Pad", "triangle", 0.5, 0, 1, 1},
+	{"Synth Effects", "saw", 0.3, 2, 0.5, 1},
+	{"Ethnic", "pulse", 0, 1.5, 0, 0.2},
+	{"Percussive", "sine", 0, 0.5, 0, 0.1},
+	{"Sound Effects", "noise", 0.1, 0, 1, 0.5},
+}
+
+// Waveforms of the fallback bank are single cycles of fallbackCycle samples
+// at fallbackRate, which plays A4.
+const (
+	fallbackCycle = 100
+	fallbackRate  = 44000
+	fallbackRoot  = 69
+)
+
+// fallbackWave generates a looped waveform, band limited to keep aliasing
+// down on high notes.
+func fallbackWave(wave string) []int16 {
+	if wave == "noise" {
+		rng := rand.New(rand.NewPCG(1, 2))
+		data := make([]int16, 4096)
+		for i := range data {
+			data[i] = int16(rng.IntN(20000) - 10000)
+		}
+		return data
+	}
+	data := make([]int16, fallbackCycle*4)
+	for i := range data {
+		phase := 2 * math.Pi * float64(i) / fallbackCycle
+		var v float64
+		for h := 1; h <= 16; h++ {
+			n := float64(h)
+			switch wave {
+			case "sine":
+				if h == 1 {
+					v += math.Sin(phase)
+				}
+			case "triangle":
+				if h%2 == 1 {
+					v += math.Pow(-1, float64(h/2)) * math.Sin(n*phase) / (n * n) * 8 / (math.Pi * math.Pi)
+				}
+			case "square":
+				if h%2 == 1 {
+					v += math.Sin(n*phase) / n * 4 / math.Pi
+				}
+			case "saw":
+				v += math.Sin(n*phase) / n * 2 / math.Pi
+			case "pulse":
+				v += math.Sin(math.Pi*n/4) * math.Cos(n*phase) / n * 2 / math.Pi
+			}
+		}
+		data[i] = int16(max(-1, min(1, v)) * 12000)
+	}
+	return data
+}
+
+// fallbackDrum generates a one-shot drum sound.
+func fallbackDrum(kind string) []int16 {
+	const rate = 22050
+	length := map[string]float64{"kick": 0.4, "snare": 0.25, "tom": 0.5, "hat": 0.08, "open-hat": 0.4, "cymbal": 1.2}[kind]
+	rng := rand.New(rand.NewPCG(3, 4))
+	data := make([]int16, int(length*rate))
+	var phase, last float64
+	for i := range data {
+		t := float64(i) / rate
+		noise := rng.Float64()*2 - 1
+		var v float64
+		switch kind {
+		case "kick":
+			phase += 2 * math.Pi * (45 + 105*math.Exp(-t/0.03)) / rate
+			v = math.Sin(phase) * math.Exp(-t/0.15)
+		case "tom":
+			phase += 2 * math.Pi * (120 + 80*math.Exp(-t/0.05)) / rate
+			v = math.Sin(phase) * math.Exp(-t/0.2)
+		case "snare":
+			phase += 2 * math.Pi * 180 / rate
+			v = 0.6*noise*math.Exp(-t/0.06) + 0.5*math.Sin(phase)*math.Exp(-t/0.04)
+		default:
+			// Metal sounds: noise through a first-difference high-pass filter.
+			decay := map[string]float64{"hat": 0.02, "open-hat": 0.12, "cymbal": 0.4}[kind]
+			v = 0.5 * (noise - last) * math.Exp(-t/decay)
+			last = noise
+		}
+		data[i] = int16(max(-1, min(1, v)) * 20000)
+	}
+	return data
+}
+
+// fallbackDrumKind picks the sound of a GM percussion key.
+func fallbackDrumKind(key int) string {
+	switch key {
+	case 35, 36:
+		return "kick"
+	case 37, 38, 39, 40:
+		return "snare"
+	case 42, 44:
+		return "hat"
+	case 46:
+		return "open-hat"
+	case 49, 51, 52, 53, 55, 57, 59:
+		return "cymbal"
+	}
+	return "tom"
+}
+
+// fallbackBank builds the tiny GM-like fallback sound font. Every program of
+// a family shares one simple waveform, and bank 128 has a basic drum kit.
+func fallbackBank() *sf2Bank {
+	bank := &sf2Bank{name: "Built-in fallback"}
+	waves := make(map[string]int)
+	for family, f := range fallbackFamilies {
+		if _, ok := waves[f.wave]; !ok {
+			data := fallbackWave(f.wave)
+			waves[f.wave] = len(bank.samples)
+			bank.samples = append(bank.samples, sf2Sample{
+				name: f.wave, data: data, sampleRate: fallbackRate, rootKey: fallbackRoot,
+				loopStart: 0, loopEnd: len(data),
+			})
+		}
+		z := sf2Zone{sample: waves[f.wave], keyHigh: 127, velHigh: 127}
+		z.set(genSampleModes, 1)
+		z.set(genAttackVolEnv, timecents(f.attack))
+		if f.decay > 0 {
+			z.set(genDecayVolEnv, timecents(f.decay))
+		}
+		sustain := 1440
+		if f.sustain > 0 {
+			sustain = int(math.Round(-200 * math.Log10(f.sustain)))
+		}
+		z.set(genSustainVolEnv, sustain)
+		z.set(genReleaseVolEnv, timecents(f.release))
+		bank.instruments = append(bank.instruments, sf2Instrument{name: f.name, zones: []sf2Zone{z}})
+		for i := range 8 {
+			bank.presets = append(bank.presets, sf2Preset{name: f.name, program: family*8 + i, instrument: family})
+		}
+	}
+
+	drums := sf2Instrument{name: "Standard Kit"}
+	kinds := make(map[string]int)
+	for key := 35; key <= 81; key++ {
+		kind := fallbackDrumKind(key)
+		if _, ok := kinds[kind]; !ok {
+			data := fallbackDrum(kind)
+			kinds[kind] = len(bank.samples)
+			bank.samples = append(bank.samples, sf2Sample{name: kind, data: data, sampleRate: 22050, rootKey: 60, loopEnd: len(data)})
+		}
+		z := sf2Zone{sample: kinds[kind], keyLow: key, keyHigh: key, velHigh: 127}
+		root := 60
+		if kind == "tom" {
+			// Toms rise in pitch with the key; the other sounds don't.
+			root = 60 - (key-41)/2
+		}
+		z.set(genOverridingRootKey, root+key-60)
+		if kind == "hat" || kind == "open-hat" {
+			z.set(genExclusiveClass, 1)
+		}
+		z.set(genReleaseVolEnv, timecents(1))
+		drums.zones = append(drums.zones, z)
+	}
+	bank.presets = append(bank.presets, sf2Preset{name: drums.name, bank: 128, instrument: len(bank.instruments)})
+	bank.instruments = append(bank.instruments, drums)
+	return bank
+}
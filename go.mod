@@ -1,14 +1,32 @@
 module meltysynth-test
 
-go 1.23.1
+go 1.23.2
 
 require (
+	fyne.io/systray v1.12.2
 	github.com/ebitengine/oto/v3 v3.3.0
 	github.com/ezmidi/go-meltysynth v0.0.2
+	github.com/jfreymuth/oggvorbis v1.0.5
 	github.com/mattrtaylor/go-rtmidi v0.0.0-20220428034745-af795b1c1a79
+	github.com/mewkiz/flac v1.0.14
+	github.com/xthexder/go-jack v0.0.0-20220805234212-bc8604043aba
+	tinygo.org/x/bluetooth v0.14.0
 )
 
 require (
 	github.com/ebitengine/purego v0.8.0 // indirect
+	github.com/go-ole/go-ole v1.2.6 // indirect
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
+	github.com/icza/bitio v1.1.0 // indirect
+	github.com/jfreymuth/vorbis v1.0.2 // indirect
+	github.com/mewkiz/pkg v0.0.0-20250417130911-3f050ff8c56d // indirect
+	github.com/mewpkg/term v0.0.0-20241026122259-37a80af23985 // indirect
+	github.com/saltosystems/winrt-go v0.0.0-20240509164145-4f7860a3bd2b // indirect
+	github.com/sirupsen/logrus v1.9.3 // indirect
+	github.com/soypat/cyw43439 v0.0.0-20250505012923-830110c8f4af // indirect
+	github.com/soypat/seqs v0.0.0-20250124201400-0d65bc7c1710 // indirect
+	github.com/tinygo-org/cbgo v0.0.4 // indirect
+	github.com/tinygo-org/pio v0.2.0 // indirect
+	golang.org/x/exp v0.0.0-20241204233417-43b7b7cde48d // indirect
 	golang.org/x/sys v0.25.0 // indirect
 )
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// maxHumanizeTiming bounds the timing jitter, beyond which notes sound late
+// rather than loose.
+const maxHumanizeTiming = 50 * time.Millisecond
+
+// humanizer varies the velocity and timing of incoming notes a little, so
+// that mechanically quantized MIDI sounds less robotic. Notes can only be
+// played late, so the timing jitter is a random delay.
+type humanizer struct {
+	rng      *rand.Rand
+	velocity int           // most a velocity changes either way
+	timing   time.Duration // longest delay of a note
+	pending  map[[2]int32]*delayedNote
+}
+
+// delayedNote is a key press waiting for its delay.
+type delayedNote struct {
+	pressed  time.Time
+	released time.Time // zero while the key is held
+	dropped  bool      // cancelled by a panic or reset
+}
+
+// newHumanizer creates a humanizer; a seed of 0 picks a random one.
+func newHumanizer(velocity int, timing time.Duration, seed uint64) *humanizer {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &humanizer{
+		rng:      rand.New(rand.NewPCG(seed, seed)),
+		velocity: velocity,
+		timing:   timing,
+		pending:  make(map[[2]int32]*delayedNote),
+	}
+}
+
+// vary returns a velocity randomly changed by up to the velocity amount.
+func (hz *humanizer) vary(velocity byte) byte {
+	if hz.velocity == 0 {
+		return velocity
+	}
+	v := int(velocity) + hz.rng.IntN(2*hz.velocity+1) - hz.velocity
+	return byte(max(1, min(127, v)))
+}
+
+// delay returns a random delay of up to the timing amount.
+func (hz *humanizer) delay() time.Duration {
+	if hz.timing == 0 {
+		return 0
+	}
+	return time.Duration(hz.rng.Int64N(int64(hz.timing) + 1))
+}
+
+// clear drops the notes still waiting for their delay.
+func (hz *humanizer) clear() {
+	for k, note := range hz.pending {
+		note.dropped = true
+		delete(hz.pending, k)
+	}
+}
+
+// humanNoteOn plays a key press with a varied velocity after a random
+// delay.
+func (h *midiHandler) humanNoteOn(channel int32, key, velocity byte) {
+	hz := h.humanize
+	velocity = hz.vary(velocity)
+	delay := hz.delay()
+	if delay == 0 {
+		h.noteOn(channel, key, velocity)
+		return
+	}
+	k := [2]int32{channel, int32(key)}
+	note := &delayedNote{pressed: time.Now()}
+	hz.pending[k] = note
+	time.AfterFunc(delay, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if note.dropped {
+			return
+		}
+		if hz.pending[k] == note {
+			delete(hz.pending, k)
+		}
+		h.noteOn(channel, key, velocity)
+		if !note.released.IsZero() {
+			// The key went up during the delay: keep the note as long as
+			// it was held.
+			time.AfterFunc(note.released.Sub(note.pressed), func() {
+				h.mu.Lock()
+				defer h.mu.Unlock()
+				if !note.dropped {
+					h.noteOff(channel, key)
+				}
+			})
+		}
+	})
+}
+
+// humanNoteOff reports whether a key release belongs to a note still
+// waiting for its delay, which then releases it itself.
+func (h *midiHandler) humanNoteOff(channel int32, key byte) bool {
+	k := [2]int32{channel, int32(key)}
+	note, ok := h.humanize.pending[k]
+	if !ok {
+		return false
+	}
+	note.released = time.Now()
+	delete(h.humanize.pending, k)
+	return true
+}
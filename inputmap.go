@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ccRule moves a controller to another number and optionally another channel.
+type ccRule struct {
+	channel int32 // -1 keeps the incoming channel
+	cc      byte
+}
+
+// inputMap rewrites incoming messages before they are handled, so
+// controllers with fixed layouts can drive sensible targets.
+type inputMap struct {
+	description string
+	notes       map[byte]byte // incoming key to played key
+	noteChannel int32         // channel notes are moved to, -1 keeps it
+	ccs         map[byte]ccRule
+}
+
+// transform returns the rewritten message. The input is never modified, as
+// rtmidi owns its buffer.
+func (m *inputMap) transform(msg []byte) []byte {
+	if len(msg) < 3 || msg[0] >= 0xF0 {
+		return msg
+	}
+	out := append([]byte(nil), msg...)
+	switch out[0] & 0xF0 {
+	case 0x80, 0x90, 0xA0: // Note Off, Note On, Polyphonic Key Pressure
+		if key, ok := m.notes[out[1]]; ok {
+			out[1] = key
+		}
+		if m.noteChannel >= 0 {
+			out[0] = out[0]&0xF0 | byte(m.noteChannel)
+		}
+	case 0xB0: // Control Change
+		if rule, ok := m.ccs[out[1]]; ok {
+			out[1] = rule.cc
+			if rule.channel >= 0 {
+				out[0] = 0xB0 | byte(rule.channel)
+			}
+		}
+	}
+	return out
+}
+
+// controllerProfiles are the built-in input maps, selected by name with
+// -controller.
+var controllerProfiles = map[string]*inputMap{
+	"nanokontrol2": {
+		description: "KORG nanoKONTROL2: faders are channel 1-8 volume, knobs channel 1-8 pan",
+		noteChannel: -1,
+		ccs: func() map[byte]ccRule {
+			ccs := make(map[byte]ccRule)
+			for i := byte(0); i < 8; i++ {
+				ccs[i] = ccRule{channel: int32(i), cc: 7}     // faders
+				ccs[16+i] = ccRule{channel: int32(i), cc: 10} // knobs
+			}
+			return ccs
+		}(),
+	},
+	"launchkey-mini": {
+		description: "Novation Launchkey Mini MK3: pots control mod, volume, pan, expression, resonance, brightness, reverb and chorus",
+		noteChannel: -1,
+		ccs: map[byte]ccRule{
+			21: {channel: -1, cc: 1},
+			22: {channel: -1, cc: 7},
+			23: {channel: -1, cc: 10},
+			24: {channel: -1, cc: 11},
+			25: {channel: -1, cc: 71},
+			26: {channel: -1, cc: 74},
+			27: {channel: -1, cc: 91},
+			28: {channel: -1, cc: 93},
+		},
+	},
+	"mpd218": {
+		description: "Akai MPD218 (bank A): 16 pads laid out as a GM drum kit on channel 10",
+		noteChannel: percussionChannel,
+		notes: map[byte]byte{
+			36: 36, 37: 37, 38: 38, 39: 39, // kick, side stick, snare, clap
+			40: 42, 41: 44, 42: 46, 43: 49, // closed, pedal and open hi-hat, crash
+			44: 45, 45: 47, 46: 50, 47: 51, // low, mid and high tom, ride
+			48: 41, 49: 54, 50: 56, 51: 55, // floor tom, tambourine, cowbell, splash
+		},
+	},
+}
+
+// lookupControllerProfile returns a built-in profile by name.
+func lookupControllerProfile(name string) (*inputMap, error) {
+	if m, ok := controllerProfiles[strings.ToLower(name)]; ok {
+		return m, nil
+	}
+	return nil, fmt.Errorf("unknown controller profile %q (available: %s)", name, strings.Join(controllerProfileNames(), ", "))
+}
+
+func controllerProfileNames() []string {
+	names := make([]string, 0, len(controllerProfiles))
+	for name := range controllerProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
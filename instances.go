@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+	"github.com/mattrtaylor/go-rtmidi"
+)
+
+// instanceConfig is an extra sound module: a synthesizer of its own,
+// playing its own MIDI input port, mixed into the same output. Each one
+// brings another 16 channels.
+type instanceConfig struct {
+	MidiIn     string            `json:"midiIn"`     // port number or part of its name
+	SoundFont  string            `json:"soundfont"`  // defaults to the main sound font
+	NoteRanges []noteRangeConfig `json:"noteRanges"` // keys the port responds to
+	ChannelMap channelMap        `json:"channelMap"` // channels of the port remapped
+}
+
+func (c *instanceConfig) validate() error {
+	if c.MidiIn == "" {
+		return fmt.Errorf("no midiIn port")
+	}
+	for i, r := range c.NoteRanges {
+		if err := r.validate(); err != nil {
+			return fmt.Errorf("note range %d: %w", i+1, err)
+		}
+	}
+	return c.ChannelMap.validate()
+}
+
+// instance is a running extra sound module.
+type instance struct {
+	handler *midiHandler
+	in      rtmidi.MIDIIn
+}
+
+// openInstance creates the synthesizer of an extra module, with the
+// synthesizer and MIDI settings of the main one, and starts playing its MIDI
+// input.
+func openInstance(c instanceConfig, main *midiHandler) (*instance, error) {
+	settings := main.synthesizer
+	soundFont := settings.SoundFont
+	if c.SoundFont != "" {
+		var err error
+		if soundFont, err = loadSoundFont(c.SoundFont); err != nil {
+			return nil, err
+		}
+	}
+	synthesizer, err := meltysynth.NewSynthesizer(soundFont, &meltysynth.SynthesizerSettings{
+		SampleRate:            settings.SampleRate,
+		BlockSize:             settings.BlockSize,
+		MaximumPolyphony:      settings.MaximumPolyphony,
+		EnableReverbAndChorus: settings.EnableReverbAndChorus,
+	})
+	if err != nil {
+		return nil, err
+	}
+	handler := newMidiHandler(synthesizer)
+	handler.soundFont = c.SoundFont
+	if c.SoundFont == "" {
+		handler.presetGains = main.presetGains // for the same sound font
+	}
+	handler.nrpn = main.nrpn
+	handler.aftertouch = main.aftertouch
+	handler.polyTarget = main.polyTarget
+	handler.bankMode = main.bankMode
+	handler.softAmount = main.softAmount
+	handler.fixedVelocity = main.fixedVelocity
+	handler.controlSmoothing = main.controlSmoothing
+	handler.vibratoDepth, handler.vibratoRate = main.vibratoDepth, main.vibratoRate
+	handler.breathTarget, handler.breathCurve = main.breathTarget, main.breathCurve
+	handler.monoDefault, handler.monoMode = main.monoDefault, main.monoDefault
+	if main.humanize != nil {
+		handler.humanize = newHumanizer(main.humanize.velocity, main.humanize.timing, main.humanize.rng.Uint64())
+	}
+	handler.drums = main.drums
+	handler.applyDrumChannels()
+	handler.bendRanges = main.bendRanges
+	handler.applyBendRanges()
+	handler.masterTune = main.masterTune
+	handler.mts = main.mts
+	if handler.masterTune != 0 {
+		handler.applyMasterTuning()
+	}
+
+	table := c.ChannelMap.table()
+	play := handler.newInput(c.NoteRanges)
+	in, name, err := openMidiInput(c.MidiIn, func(data []byte) {
+		play(remapChannels(table, data))
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Extra sound module", "midiIn", name, "soundfont", c.SoundFont)
+	return &instance{handler: handler, in: in}, nil
+}
+
+func (i *instance) Close() error {
+	return i.in.Close()
+}
+
+// openMidiInput opens the MIDI input port matching spec, a port number or
+// part of its name, and passes its data to play. It returns the port's
+// name.
+func openMidiInput(spec string, play func([]byte)) (rtmidi.MIDIIn, string, error) {
+	in, err := rtmidi.NewMIDIInDefault()
+	if err != nil {
+		return nil, "", err
+	}
+	names, err := midiInputNames(in)
+	if err == nil {
+		var port int
+		if port, err = findPort(names, spec); err == nil {
+			err = in.OpenPort(port, "")
+			spec = names[port]
+		}
+	}
+	if err == nil {
+		err = in.IgnoreTypes(false, false, true)
+	}
+	if err == nil {
+		err = in.SetCallback(func(_ rtmidi.MIDIIn, msg []byte, _ float64) {
+			play(msg)
+		})
+	}
+	if err != nil {
+		in.Close()
+		return nil, "", err
+	}
+	return in, spec, nil
+}
+
+// midiInputNames lists the MIDI input ports.
+func midiInputNames(in rtmidi.MIDIIn) ([]string, error) {
+	count, err := in.PortCount()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, count)
+	for i := range names {
+		if names[i], err = in.PortName(i); err != nil {
+			return nil, err
+		}
+	}
+	return names, nil
+}
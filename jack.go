@@ -0,0 +1,108 @@
+//go:build jack
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/xthexder/go-jack"
+)
+
+// jackOutput plays the renderer's output through a JACK client with a
+// stereo pair of output ports. JACK dictates the sample rate.
+type jackOutput struct {
+	client      *jack.Client
+	left, right *jack.Port
+	ring        *ringBuffer
+	stats       *audioStats // may be nil
+	samples     []float32
+}
+
+// openJackOutput connects to the JACK server and registers the ports. The
+// client starts processing once start is called.
+func openJackOutput(name string) (*jackOutput, error) {
+	client, status := jack.ClientOpen(name, jack.NoStartServer)
+	if status != 0 {
+		return nil, fmt.Errorf("open JACK client: %w", jack.StrError(status))
+	}
+	out := &jackOutput{client: client}
+	out.left = client.PortRegister("out_left", jack.DEFAULT_AUDIO_TYPE, jack.PortIsOutput|jack.PortIsTerminal, 0)
+	out.right = client.PortRegister("out_right", jack.DEFAULT_AUDIO_TYPE, jack.PortIsOutput|jack.PortIsTerminal, 0)
+	if out.left == nil || out.right == nil {
+		client.Close()
+		return nil, fmt.Errorf("register JACK ports")
+	}
+	return out, nil
+}
+
+// listJackPlayback returns the sample rate and buffer size of the JACK
+// server and its physical playback ports.
+func listJackPlayback() (rate, frames int, ports []string, err error) {
+	client, status := jack.ClientOpen("meltysynth-devices", jack.NoStartServer)
+	if status != 0 {
+		return 0, 0, nil, fmt.Errorf("open JACK client: %w", jack.StrError(status))
+	}
+	defer client.Close()
+	ports = client.GetPorts("", jack.DEFAULT_AUDIO_TYPE, jack.PortIsInput|jack.PortIsPhysical)
+	return int(client.GetSampleRate()), int(client.GetBufferSize()), ports, nil
+}
+
+// sampleRate returns the sample rate of the JACK server.
+func (o *jackOutput) sampleRate() int {
+	return int(o.client.GetSampleRate())
+}
+
+// start activates the client and connects it to the system playback ports
+// unless autoConnect is false.
+func (o *jackOutput) start(ring *ringBuffer, stats *audioStats, autoConnect bool) error {
+	o.ring, o.stats = ring, stats
+	o.samples = make([]float32, 2*o.client.GetBufferSize())
+	if code := o.client.SetProcessCallback(o.process); code != 0 {
+		return fmt.Errorf("set JACK process callback: %w", jack.StrError(code))
+	}
+	if code := o.client.Activate(); code != 0 {
+		return fmt.Errorf("activate JACK client: %w", jack.StrError(code))
+	}
+	if !autoConnect {
+		return nil
+	}
+	playback := o.client.GetPorts("", jack.DEFAULT_AUDIO_TYPE, jack.PortIsInput|jack.PortIsPhysical)
+	for i, port := range []*jack.Port{o.left, o.right} {
+		if i < len(playback) {
+			o.client.Connect(port.GetName(), playback[i])
+		}
+	}
+	return nil
+}
+
+// process runs on JACK's realtime thread: it must not block, so a
+// shortfall is played as silence.
+func (o *jackOutput) process(frames uint32) int {
+	left := o.left.GetBuffer(frames)
+	right := o.right.GetBuffer(frames)
+	count := int(frames) * 2
+	if len(o.samples) < count {
+		// The buffer size grew; JACK allows allocating here at that point.
+		o.samples = make([]float32, count)
+	}
+	samples := o.samples[:count]
+	if got := o.ring.pop(samples); got < count {
+		clear(samples[got:])
+		if o.stats != nil {
+			o.stats.underrun()
+		}
+	}
+	for i := range int(frames) {
+		left[i] = jack.AudioSample(samples[2*i])
+		right[i] = jack.AudioSample(samples[2*i+1])
+	}
+	return 0
+}
+
+// Close deactivates and closes the client.
+func (o *jackOutput) Close() error {
+	if code := o.client.Close(); code != 0 {
+		return jack.StrError(code)
+	}
+	return nil
+}
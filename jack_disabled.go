@@ -0,0 +1,25 @@
+//go:build !jack
+
+package main
+
+import "fmt"
+
+// jackOutput is only available in builds with the jack tag, which need
+// the JACK development files.
+type jackOutput struct{}
+
+func openJackOutput(name string) (*jackOutput, error) {
+	return nil, fmt.Errorf("built without JACK support (build with -tags jack)")
+}
+
+func (o *jackOutput) sampleRate() int { return 0 }
+
+func listJackPlayback() (rate, frames int, ports []string, err error) {
+	return 0, 0, nil, fmt.Errorf("built without JACK support (build with -tags jack)")
+}
+
+func (o *jackOutput) start(ring *ringBuffer, stats *audioStats, autoConnect bool) error {
+	return nil
+}
+
+func (o *jackOutput) Close() error { return nil }
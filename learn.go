@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// appActions are the application controls MIDI messages can be bound to.
+// master-volume follows a controller's value; the others are buttons,
+// triggered by a note or by a controller going above 63.
+var appActions = []string{"master-volume", "transpose-up", "transpose-down", "panic", "next-soundfont", "compare-soundfont", "record"}
+
+// bindingConfig binds a controller or key of an incoming channel to an
+// application action, e.g. {"action": "panic", "channel": 1, "cc": 20}.
+type bindingConfig struct {
+	Action  string      `json:"action"`
+	Channel int         `json:"channel"` // 1-16, 0 for any
+	CC      *int32      `json:"cc,omitempty"`
+	Note    *noteNumber `json:"note,omitempty"`
+}
+
+func (b *bindingConfig) validate() error {
+	if !slices.Contains(appActions, b.Action) {
+		return fmt.Errorf("unknown action %q (available: %s)", b.Action, strings.Join(appActions, ", "))
+	}
+	if b.Channel < 0 || b.Channel > channelCount {
+		return fmt.Errorf("channel %d out of range 0-16", b.Channel)
+	}
+	if (b.CC == nil) == (b.Note == nil) {
+		return fmt.Errorf("needs either cc or note")
+	}
+	if b.CC != nil && (*b.CC < 0 || *b.CC > 127) {
+		return fmt.Errorf("cc %d out of range 0-127", *b.CC)
+	}
+	if b.Action == "master-volume" && b.CC == nil {
+		return fmt.Errorf("master-volume needs a cc")
+	}
+	return nil
+}
+
+// matches reports whether a message comes from the bound control.
+func (b *bindingConfig) matches(msg []byte) bool {
+	if len(msg) < 3 || (b.Channel != 0 && int(msg[0]&0x0F) != b.Channel-1) {
+		return false
+	}
+	switch msg[0] & 0xF0 {
+	case 0xB0:
+		return b.CC != nil && *b.CC == int32(msg[1])
+	case 0x80, 0x90:
+		return b.Note != nil && byte(*b.Note) == msg[1]
+	}
+	return false
+}
+
+// appControls runs the actions of bound MIDI controls. Actions run on their
+// own goroutine, in order, because several of them take the handler lock
+// that is held while MIDI is handled.
+type appControls struct {
+	handler    *midiHandler
+	renderer   *renderer
+	configPath string // where learned bindings are saved, may be empty
+	sampleRate int
+	dither     string
+	normalize  normalization // of recordings
+
+	events    chan appEvent
+	transpose int32 // semitones applied by transpose-up and transpose-down
+
+	mu        sync.Mutex
+	recording *audioRecorder
+	recPath   string
+
+	compare     *meltysynth.SoundFont // the sound font compare-soundfont switches to, may be nil
+	comparePath string
+}
+
+type appEvent struct {
+	action string
+	value  int32
+}
+
+func newAppControls(handler *midiHandler, render *renderer, configPath string, sampleRate int, dither string, normalize normalization) *appControls {
+	c := &appControls{
+		handler:    handler,
+		renderer:   render,
+		configPath: configPath,
+		sampleRate: sampleRate,
+		dither:     dither,
+		normalize:  normalize,
+		events:     make(chan appEvent, 64),
+	}
+	handler.controls = c
+	go func() {
+		for e := range c.events {
+			c.run(e.action, e.value)
+		}
+	}()
+	return c
+}
+
+// handleBinding runs the action a message is bound to, or learns a binding
+// if learning was requested. It reports whether the message was used. It's
+// called with the handler lock held.
+func (h *midiHandler) handleBinding(msg []byte) bool {
+	if h.controls == nil || len(msg) < 3 {
+		return false
+	}
+	status := msg[0] & 0xF0
+	if h.learning != "" && (status == 0xB0 || status == 0x90 && msg[2] > 0) {
+		h.learnBinding(msg)
+		return true
+	}
+	for _, b := range h.bindings {
+		if !b.matches(msg) {
+			continue
+		}
+		value := int32(msg[2])
+		if status == 0x80 {
+			value = 0
+		}
+		switch {
+		case b.Action == "master-volume":
+		case status == 0xB0:
+			// Buttons send 127 when pressed and 0 when released.
+			if value < 64 || h.bindingState[b.key()] >= 64 {
+				h.bindingState[b.key()] = value
+				return true
+			}
+			h.bindingState[b.key()] = value
+		case value == 0:
+			return true // key released
+		}
+		select {
+		case h.controls.events <- appEvent{b.Action, value}:
+		default:
+		}
+		return true
+	}
+	return false
+}
+
+// key identifies the control of a binding.
+func (b *bindingConfig) key() string {
+	if b.CC != nil {
+		return fmt.Sprintf("%d/cc%d", b.Channel, *b.CC)
+	}
+	return fmt.Sprintf("%d/note%d", b.Channel, *b.Note)
+}
+
+// learn binds the next controller moved or key pressed to an action.
+func (h *midiHandler) learn(action string) error {
+	if !slices.Contains(appActions, action) {
+		return fmt.Errorf("unknown action %q (available: %s)", action, strings.Join(appActions, ", "))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.learning = action
+	slog.Info("MIDI learn: move a controller or press a key", "action", action)
+	return nil
+}
+
+func (h *midiHandler) learnBinding(msg []byte) {
+	b := bindingConfig{Action: h.learning, Channel: int(msg[0]&0x0F) + 1}
+	h.learning = ""
+	if msg[0]&0xF0 == 0xB0 {
+		cc := int32(msg[1])
+		b.CC = &cc
+	} else {
+		if b.Action == "master-volume" {
+			slog.Warn("MIDI learn: master-volume needs a controller, not a key")
+			return
+		}
+		note := noteNumber(msg[1])
+		b.Note = &note
+	}
+	// A control does one thing: replace bindings of the same control.
+	h.bindings = slices.DeleteFunc(h.bindings, func(old bindingConfig) bool { return old.key() == b.key() })
+	h.bindings = append(h.bindings, b)
+	slog.Info("MIDI learn: bound", "action", b.Action, "control", describeMessage(msg))
+
+	if h.controls.configPath == "" {
+		slog.Warn("MIDI learn: no -config file, the binding lasts until exit")
+		return
+	}
+	if err := saveBindings(h.controls.configPath, h.bindings); err != nil {
+		slog.Warn("MIDI learn: failed to save the binding", "err", err)
+	}
+}
+
+// saveBindings replaces the bindings in a configuration file, leaving its
+// other settings as they are.
+func saveBindings(path string, bindings []bindingConfig) error {
+	settings := make(map[string]json.RawMessage)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if settings["bindings"], err = json.Marshal(bindings); err != nil {
+		return err
+	}
+	if data, err = json.MarshalIndent(settings, "", "  "); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// run performs an action.
+func (c *appControls) run(action string, value int32) {
+	h := c.handler
+	switch action {
+	case "master-volume":
+		h.mu.Lock()
+		h.synthesizer.MasterVolume = float32(value) / 127
+		h.mu.Unlock()
+	case "transpose-up", "transpose-down":
+		step := int32(1)
+		if action == "transpose-down" {
+			step = -1
+		}
+		c.transpose = max(-24, min(24, c.transpose+step))
+		h.mu.Lock()
+		for ch := range int32(channelCount) {
+			if !h.drums[ch] {
+				h.setTranspose(ch, c.transpose)
+			}
+		}
+		h.mu.Unlock()
+		slog.Info("Transpose", "semitones", c.transpose)
+	case "panic":
+		h.panic()
+		slog.Info("Panic")
+	case "next-soundfont":
+		files := h.nearbySoundFonts()
+		if len(files) == 0 {
+			slog.Warn("No sound fonts to switch to")
+			return
+		}
+		next := files[(slices.Index(files, h.soundFontPath())+1)%len(files)]
+		if err := h.switchSoundFont(next, c.renderer); err != nil {
+			slog.Warn("Failed to load sound font", "err", err)
+		}
+	case "compare-soundfont":
+		c.compareSoundFonts()
+	case "record":
+		c.mu.Lock()
+		recording := c.recording != nil
+		c.mu.Unlock()
+		if recording {
+			c.stopRecording()
+		} else if err := c.startRecording(time.Now().Format("take-20060102-150405.flac")); err != nil {
+			slog.Warn("Failed to start recording", "err", err)
+		}
+	}
+}
+
+// compareSoundFonts switches between the playing sound font and the one
+// loaded with -compare-soundfont. Both stay loaded, so the switch is
+// instant.
+func (c *appControls) compareSoundFonts() {
+	if c.compare == nil {
+		slog.Warn("No sound font to compare with (-compare-soundfont)")
+		return
+	}
+	h := c.handler
+	h.mu.Lock()
+	playing, path := h.synthesizer.SoundFont, h.soundFont
+	h.mu.Unlock()
+	if err := h.useSoundFont(c.compare, c.comparePath, c.renderer); err != nil {
+		slog.Warn("Failed to switch sound fonts", "err", err)
+		return
+	}
+	slog.Info("Comparing sound fonts", "playing", c.comparePath, "other", path)
+	c.compare, c.comparePath = playing, path
+}
+
+// startRecording records the audio output to a file.
+func (c *appControls) startRecording(path string) error {
+	dither, err := newDitherer(c.dither)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.recording != nil {
+		return fmt.Errorf("already recording to %s", c.recPath)
+	}
+	if c.recording, err = newAudioRecorder(path, &c.renderer.taps, c.sampleRate, dither, c.normalize); err != nil {
+		return err
+	}
+	c.recPath = path
+	slog.Info("Recording audio", "path", path)
+	return nil
+}
+
+// stopRecording finishes the recording, if there is one.
+func (c *appControls) stopRecording() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.recording == nil {
+		return
+	}
+	if err := c.recording.Close(); err != nil {
+		slog.Error("Failed to finish audio recording", "err", err)
+	} else {
+		slog.Info("Saved audio recording", append([]any{"path", c.recPath}, c.recording.report()...)...)
+	}
+	c.recording = nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import "slices"
+
+// monoChannel is the state of a channel in monophonic legato mode. Only
+// one note sounds; a key pressed while another is held moves that note to
+// the new pitch instead of starting another, so its attack isn't
+// repeated. Releasing the newest key returns to the newest one still held.
+type monoChannel struct {
+	held     []byte  // keys down, oldest first
+	sounding byte    // key the synthesizer started
+	shift    float64 // semitones from sounding to the key heard
+}
+
+// monoNoteOn plays a key on a channel in mono mode.
+func (h *midiHandler) monoNoteOn(channel int32, key, velocity byte) {
+	m := &h.mono[channel]
+	legato := len(m.held) > 0
+	m.held = append(slices.DeleteFunc(m.held, func(k byte) bool { return k == key }), key)
+	h.glideTo(channel, key)
+	if legato {
+		m.shift = float64(key) - float64(m.sounding)
+		h.updatePitch(channel)
+		return
+	}
+	m.sounding, m.shift = key, 0
+	h.updatePitch(channel)
+	h.synthesizer.NoteOn(channel, int32(key), int32(velocity))
+}
+
+// monoNoteOff releases a key on a channel in mono mode.
+func (h *midiHandler) monoNoteOff(channel int32, key byte) {
+	m := &h.mono[channel]
+	i := slices.Index(m.held, key)
+	if i < 0 {
+		return
+	}
+	newest := i == len(m.held)-1
+	m.held = slices.Delete(m.held, i, i+1)
+	switch {
+	case len(m.held) == 0:
+		h.synthesizer.NoteOff(channel, int32(m.sounding))
+	case newest:
+		previous := m.held[len(m.held)-1]
+		h.glideTo(channel, previous)
+		m.shift = float64(previous) - float64(m.sounding)
+		h.updatePitch(channel)
+	}
+}
+
+// setMono switches a channel between mono and poly mode, ending its notes
+// as Mono Mode On and Poly Mode On (CC126/127) do.
+func (h *midiHandler) setMono(channel int32, mono bool) {
+	if h.monoMode[channel] == mono {
+		return
+	}
+	h.monoMode[channel] = mono
+	for _, ch := range h.targetChannels(channel) {
+		h.synthesizer.ProcessMidiMessage(ch, 0xB0, 0x7B, 0)
+	}
+	h.mono[channel] = monoChannel{}
+	h.updatePitch(channel)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// setupLogging configures the default logger. Debug level adds a line per
+// MIDI message; quiet mode only shows warnings and errors.
+func setupLogging(verbose, quiet, jsonOutput bool) {
+	level := slog.LevelInfo
+	switch {
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelWarn
+	}
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if jsonOutput {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
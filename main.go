@@ -1,181 +1,1338 @@
-package main
-
-import (
-	"encoding/binary"
-	"fmt"
-	"log"
-	"math"
-	"os"
-
-	"github.com/ebitengine/oto/v3"
-	"github.com/ezmidi/go-meltysynth/meltysynth"
-	"github.com/mattrtaylor/go-rtmidi"
-)
-
-// AudioReader generates audio samples from the synthesizer.
-type AudioReader struct {
-	synthesizer *meltysynth.Synthesizer
-}
-
-// Read fills the provided byte slice with audio data.
-func (ar *AudioReader) Read(p []byte) (n int, err error) {
-	left := make([]float32, 2)
-	right := make([]float32, 2)
-
-	// Render the waveform
-	ar.synthesizer.Render(left, right)
-
-	// Prepare audio data for output as float32 values
-	leftSample := left[0]   // Channel 1
-	rightSample := right[0] // Channel 2
-
-	// Convert float32 samples to bytes (little-endian)
-	leftBytes := make([]byte, 4)
-	rightBytes := make([]byte, 4)
-
-	binary.LittleEndian.PutUint32(leftBytes, math.Float32bits(leftSample))
-	binary.LittleEndian.PutUint32(rightBytes, math.Float32bits(rightSample))
-
-	// Write left channel float32 (4 bytes) to the buffer
-	copy(p[n:], leftBytes)
-	n += 4
-
-	// Write right channel float32 (4 bytes) to the buffer
-	copy(p[n:], rightBytes)
-	n += 4
-
-	return n, nil
-}
-
-// Seek sets the current position in the audio stream.
-func (ar *AudioReader) Seek(offset int64, whence int) (int64, error) {
-	return 0, nil
-}
-
-// handleMidiMessage processes incoming MIDI messages
-func handleMidiMessage(msg []byte, synthesizer *meltysynth.Synthesizer) {
-	if len(msg) > 0 {
-		fmt.Printf("MIDI Message: %v\n", msg) // Log MIDI messages
-		switch msg[0] & 0xF0 {
-		case 0x90: // Note On
-			if len(msg) < 3 {
-				return
-			}
-			note := msg[1]
-			velocity := msg[2]
-			if velocity > 0 {
-				synthesizer.NoteOn(0, int32(note), int32(velocity))
-			} else {
-				synthesizer.NoteOff(0, int32(note))
-			}
-		case 0x80: // Note Off
-			if len(msg) < 3 {
-				return
-			}
-			note := msg[1]
-			synthesizer.NoteOff(0, int32(note))
-		}
-	}
-}
-
-// main function
-func main() {
-	// Load the sound font
-	sf2, err := os.Open("Mergedsoundfont.sf2")
-	if err != nil {
-		log.Fatalf("Failed to open sound font: %v", err)
-	}
-	soundFont, err := meltysynth.NewSoundFont(sf2)
-	if err != nil {
-		log.Fatalf("Failed to create sound font: %v", err)
-	}
-	sf2.Close()
-
-	// Create the synthesizer.
-	settings := &meltysynth.SynthesizerSettings{
-		SampleRate:            48000,
-		BlockSize:             512,   // 例: デフォルトのブロックサイズ
-		MaximumPolyphony:      500,   // 例: デフォルトのポリフォニー
-		EnableReverbAndChorus: false, // 例: リバーブとコーラスを有効にする
-	}
-
-	synthesizer, err := meltysynth.NewSynthesizer(soundFont, settings)
-	if err != nil {
-		log.Fatalf("Failed to create synthesizer: %v", err)
-	}
-
-	// Set up MIDI input
-	midiIn, err := rtmidi.NewMIDIInDefault()
-	if err != nil {
-		log.Fatalf("Failed to create MIDI input: %v", err)
-	}
-	defer midiIn.Close()
-
-	// Get the count of available MIDI input devices
-	portCount, err := midiIn.PortCount()
-	if err != nil {
-		log.Fatalf("Failed to get port count: %v", err)
-	}
-
-	if portCount == 0 {
-		log.Fatalf("No MIDI input devices found.")
-	}
-
-	fmt.Println("Available MIDI Input Devices:")
-	for i := 0; i < portCount; i++ {
-		deviceName, err := midiIn.PortName(i)
-		if err != nil {
-			log.Fatalf("Failed to get port name: %v", err)
-		}
-		fmt.Printf("%d: %s\n", i, deviceName)
-	}
-
-	// Choose a device to open (adjust index based on available devices)
-	portIndex := 0 // Change this index if needed
-	if portIndex < portCount {
-		err = midiIn.OpenPort(portIndex, "")
-		if err != nil {
-			log.Fatalf("Failed to open MIDI port: %v", err)
-		}
-	} else {
-		log.Fatalf("Invalid port index: %d", portIndex)
-	}
-
-	// Set the callback function for MIDI input
-	err = midiIn.SetCallback(func(midiIn rtmidi.MIDIIn, msg []byte, deltaTime float64) {
-		handleMidiMessage(msg, synthesizer)
-	})
-	if err != nil {
-		log.Fatalf("Failed to set MIDI callback: %v", err)
-	}
-
-	// Initialize Oto for audio playback
-	options := oto.NewContextOptions{
-		SampleRate:   48000,
-		ChannelCount: 2,
-		Format:       oto.FormatFloat32LE, // Change this to int16 for 16-bit output
-	}
-
-	context, ready, err := oto.NewContext(&options)
-	if err != nil {
-		log.Fatalf("Failed to create audio context: %v", err)
-	}
-
-	// Wait for the context to be ready
-	<-ready
-
-	// Create an instance of the audio reader
-	audioReader := &AudioReader{synthesizer: synthesizer}
-
-	// Create a new player that will read from the AudioReader
-	player := context.NewPlayer(audioReader)
-	if player == nil {
-		log.Fatal("Failed to create player")
-	}
-
-	// Play starts playing the sound and returns without waiting for it (Play() is async).
-	player.Play()
-
-	// Keep the program running
-	select {}
-}
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"fyne.io/systray"
+	"github.com/ebitengine/oto/v3"
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+	"github.com/mattrtaylor/go-rtmidi"
+)
+
+// AudioReader plays the audio queued by the renderer.
+type AudioReader struct {
+	ring  *ringBuffer
+	stats *audioStats // may be nil
+
+	samples []float32
+}
+
+// Read fills the provided byte slice with audio data.
+func (ar *AudioReader) Read(p []byte) (n int, err error) {
+	// Take as many stereo float32 frames as fit
+	count := len(p) / 8 * 2
+	if len(ar.samples) < count {
+		ar.samples = make([]float32, count)
+	}
+	samples := ar.samples[:count]
+
+	got := ar.ring.pop(samples)
+	if got < count {
+		// The renderer fell behind: play silence rather than block.
+		clear(samples[got:])
+		if ar.stats != nil {
+			ar.stats.underrun()
+		}
+	}
+
+	// Convert float32 samples to bytes (little-endian)
+	for _, sample := range samples {
+		binary.LittleEndian.PutUint32(p[n:], math.Float32bits(sample))
+		n += 4
+	}
+
+	return n, nil
+}
+
+// Seek sets the current position in the audio stream.
+func (ar *AudioReader) Seek(offset int64, whence int) (int64, error) {
+	return 0, nil
+}
+
+// midiHandler dispatches incoming MIDI messages to the synthesizer.
+type midiHandler struct {
+	mu sync.Mutex // held while a message or clock pulse is handled
+
+	events *eventQueue // nil unless events are played at their frame
+
+	synthesizer *meltysynth.Synthesizer
+	soundFont   string // path of the sound font, saved with the session
+	presets     presetIndex
+	input       *inputMap         // controller profile, may be nil
+	thru        *midiThru         // MIDI output echoing the input, may be nil
+	fallback    *presetRef        // preset used for missing programs, may be nil
+	presetGains presetGains       // gain offsets of presets, may be nil
+	nrpn        nrpnMap           // targets of mapped NRPNs
+	nrpnLogged  map[int32]bool    // unmapped NRPNs already logged
+	mpe         *mpeZone          // nil unless MPE mode is enabled
+	tuning      *retuner          // nil unless a tuning is loaded
+	mts         bool              // whether MTS messages retune notes
+	masterTune  float64           // semitones from A4 = 440 Hz
+	aftertouch  pressureTarget    // channel aftertouch target
+	polyTarget  pressureTarget    // polyphonic aftertouch target
+	clock       *midiClock        // external MIDI clock
+	logFilter   *midiLogFilter    // messages logged in verbose mode
+	arp         *arpeggiator      // nil unless the arpeggiator is enabled
+	collapse    *mpeCollapser     // nil unless an MPE zone is folded into one channel
+	zones       []keyZone         // key zones from the config file
+	chord       *chordMemory      // nil unless chord memory is enabled
+	stuck       *stuckNotes       // nil unless stuck notes are released
+	humanize    *humanizer        // nil unless notes are humanized
+	bendRanges  []bendRangeConfig // power-on pitch bend ranges
+
+	drums    [channelCount]bool       // channels that power on as drum channels
+	banks    [channelCount]int32      // bank of every channel
+	bankSel  [channelCount]bankSelect // last Bank Select of every channel
+	bankMode bankSelectMode
+	rpn      [channelCount]rpnState // registered parameters of every channel
+	mix      [channelCount]channelMix
+
+	keyPressure     [channelCount]map[int32]int32 // poly pressure by synthesizer channel and key
+	sostenutoPedals [channelCount]sostenutoPedal
+	softPedal       [channelCount]bool // soft pedal down
+	softAmount      float64            // velocity reduction under the soft pedal
+	fixedVelocity   byte               // velocity of every incoming note, 0 keeps them
+	portamento      [channelCount]portamento
+	modulation      [channelCount]int32 // mod wheel, when it drives the vibrato
+	modPressure     [channelCount]int32 // aftertouch, when it drives the vibrato
+	vibratoDepth    float64             // cents at full mod wheel, 0 leaves it to the sound font
+	vibratoRate     float64             // Hz
+	modulating      bool                // whether runPitchModulation is running
+	monoMode        [channelCount]bool  // channels in legato mode
+	monoDefault     [channelCount]bool  // channels that power on in legato mode
+	mono            [channelCount]monoChannel
+	breathTarget    breathTarget
+	breathCurve     float64 // exponent shaping the breath controller
+
+	controlSmoothing time.Duration // glide time of smoothed controllers, 0 for none
+	slews            [channelCount][len(smoothedControllers)]controlSlew
+	smoothing        bool // whether runControlSmoothing is running
+
+	controls     *appControls     // runs bound actions, may be nil
+	bindings     []bindingConfig  // MIDI controls bound to actions
+	bindingState map[string]int32 // last value of bound controllers
+	learning     string           // action bound to the next control, if any
+	scenes       *sceneSwitcher   // nil unless the config has scenes
+
+	muted  [channelCount]bool
+	soloed [channelCount]bool
+}
+
+func newMidiHandler(synthesizer *meltysynth.Synthesizer) *midiHandler {
+	return &midiHandler{
+		synthesizer:  synthesizer,
+		presets:      newPresetIndex(synthesizer.SoundFont),
+		drums:        gmDrumChannels(),
+		banks:        defaultBanks(),
+		rpn:          defaultRpnStates(),
+		mix:          defaultChannelMixes(),
+		nrpn:         make(nrpnMap),
+		nrpnLogged:   make(map[int32]bool),
+		aftertouch:   pressureModulation,
+		polyTarget:   pressureModulation,
+		clock:        &midiClock{},
+		breathCurve:  1,
+		bindingState: make(map[string]int32),
+	}
+}
+
+// defaultBanks returns the power-on banks of the synthesizer: drums on
+// channel 10, bank 0 elsewhere.
+func defaultBanks() [channelCount]int32 {
+	var banks [channelCount]int32
+	banks[percussionChannel] = 128
+	return banks
+}
+
+// newInput returns a function that plays the MIDI data of one input, which
+// may hold several messages, parts of one or running status. Every input
+// has its own parser, so a message or SysEx left unfinished by one device
+// can't swallow the bytes of another, and its own note ranges.
+func (h *midiHandler) newInput(ranges []noteRangeConfig) func([]byte) {
+	var p midiParser
+	return func(data []byte) {
+		p.feed(data, func(msg []byte) {
+			if inNoteRange(ranges, msg) {
+				h.handleMidiMessage(msg)
+			}
+		})
+	}
+}
+
+// handleMidiMessage processes one complete incoming MIDI message.
+func (h *midiHandler) handleMidiMessage(msg []byte) {
+	if h.events != nil {
+		h.events.push(msg)
+		return
+	}
+	h.playMessage(msg)
+}
+
+// playMessage handles a MIDI message right away.
+func (h *midiHandler) playMessage(msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handleMessage(msg)
+}
+
+// handleMessage processes one complete MIDI message.
+func (h *midiHandler) handleMessage(msg []byte) {
+	if len(msg) > 0 {
+		// Log MIDI messages
+		if h.logFilter.allows(msg) && slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+			slog.Debug("MIDI message", "message", describeMessage(msg), "bytes", fmt.Sprintf("% X", msg))
+		}
+		raw := msg
+		if h.input != nil {
+			msg = h.input.transform(msg)
+		}
+		if h.handleBinding(msg) || h.handleSceneChange(msg) {
+			return
+		}
+		if h.thru != nil {
+			if h.thru.mapped {
+				h.thru.send(msg)
+			} else {
+				h.thru.send(raw)
+			}
+		}
+		if msg[0] == 0xF0 {
+			h.handleSysEx(msg)
+			return
+		}
+		if msg[0] >= 0xF8 || msg[0] == 0xF2 { // System Real-Time, Song Position Pointer
+			h.clock.handleMessage(msg, time.Now())
+			return
+		}
+		if h.mpe != nil {
+			h.mpe.handleMessage(msg)
+			return
+		}
+		if h.collapse != nil {
+			for _, m := range h.collapse.transform(msg) {
+				h.handleChannelMessage(m)
+			}
+			return
+		}
+		h.handleChannelMessage(msg)
+	}
+}
+
+// playChannelMessage processes a channel voice message.
+func (h *midiHandler) playChannelMessage(msg []byte) {
+	channel := int32(msg[0] & 0x0F)
+	switch msg[0] & 0xF0 {
+	case 0x90: // Note On
+		if len(msg) < 3 {
+			return
+		}
+		note := msg[1]
+		velocity := msg[2]
+		if velocity > 0 {
+			if !h.audible(channel) {
+				return
+			}
+			if h.fixedVelocity > 0 {
+				velocity = h.fixedVelocity
+			}
+			if h.humanize != nil {
+				h.humanNoteOn(channel, note, velocity)
+				return
+			}
+			h.noteOn(channel, note, velocity)
+		} else {
+			h.releaseKey(channel, note)
+		}
+	case 0x80: // Note Off
+		if len(msg) < 3 {
+			return
+		}
+		h.releaseKey(channel, msg[1])
+	case 0xA0: // Polyphonic Key Pressure
+		if len(msg) < 3 {
+			return
+		}
+		h.polyPressure(channel, msg[1], int32(msg[2]))
+	case 0xC0: // Program Change
+		if len(msg) < 2 {
+			return
+		}
+		h.programChange(channel, int32(msg[1]))
+	case 0xB0: // Control Change
+		if len(msg) < 3 {
+			return
+		}
+		h.controlChange(channel, int32(msg[1]), int32(msg[2]))
+	case 0xD0: // Channel Pressure
+		if len(msg) < 2 {
+			return
+		}
+		h.channelPressure(channel, int32(msg[1]))
+	case 0xE0: // Pitch Bend
+		if len(msg) < 3 {
+			return
+		}
+		for _, ch := range h.targetChannels(channel) {
+			h.synthesizer.ProcessMidiMessage(ch, 0xE0, int32(msg[1]), int32(msg[2]))
+		}
+	}
+}
+
+// noteOn handles a key press, which the arpeggiator takes over on melodic
+// channels when it is enabled.
+func (h *midiHandler) noteOn(channel int32, key, velocity byte) {
+	if h.stuck != nil {
+		h.stuck.noteOn(channel, key)
+	}
+	h.sostenutoPedals[channel].press(key)
+	velocity = h.softVelocity(channel, velocity)
+	if h.presetGains != nil {
+		velocity = h.presetVelocity(channel, velocity)
+	}
+	if h.arp != nil && h.banks[channel] != 128 {
+		h.arp.press(arpNote{channel: channel, key: key, velocity: velocity})
+		return
+	}
+	h.startNote(channel, key, velocity)
+}
+
+// releaseKey handles an incoming Note Off, which waits for its Note On
+// if that is delayed by the humanizer.
+func (h *midiHandler) releaseKey(channel int32, key byte) {
+	if h.humanize != nil && h.humanNoteOff(channel, key) {
+		return
+	}
+	h.noteOff(channel, key)
+}
+
+// noteOff handles a key release.
+func (h *midiHandler) noteOff(channel int32, key byte) {
+	// The key is up even if the sostenuto pedal holds its note, so the
+	// watchdog mustn't take the note for stuck.
+	if h.stuck != nil {
+		h.stuck.noteOff(channel, key)
+	}
+	if h.sostenutoPedals[channel].release(key) {
+		return
+	}
+	if h.arp != nil && h.banks[channel] != 128 {
+		h.arp.release(channel, key)
+		return
+	}
+	h.stopNote(channel, key)
+}
+
+// startNote starts a note, retuned if a tuning is active.
+func (h *midiHandler) startNote(channel int32, key, velocity byte) {
+	if h.retuned(channel) {
+		h.tuning.noteOn(channel, key, velocity)
+		return
+	}
+	if h.monoMode[channel] {
+		h.monoNoteOn(channel, key, velocity)
+		return
+	}
+	// Portamento only glides mono channels, as it retunes the whole channel.
+	// Forget the key, so switching to mono doesn't glide from a chord.
+	h.portamento[channel].played = false
+	h.synthesizer.NoteOn(channel, int32(key), int32(velocity))
+}
+
+// stopNote releases a note.
+func (h *midiHandler) stopNote(channel int32, key byte) {
+	h.releasePressure(channel, key)
+	if h.retuned(channel) {
+		h.tuning.noteOff(channel, key)
+		return
+	}
+	if h.monoMode[channel] {
+		h.monoNoteOff(channel, key)
+		return
+	}
+	h.synthesizer.NoteOff(channel, int32(key))
+}
+
+// audible reports whether notes on a channel should sound: it must not be
+// muted, and if any channel is soloed, it must be one of them.
+func (h *midiHandler) audible(channel int32) bool {
+	if h.muted[channel] {
+		return false
+	}
+	for _, soloed := range h.soloed {
+		if soloed {
+			return h.soloed[channel]
+		}
+	}
+	return true
+}
+
+// targetChannels returns the synthesizer channels that messages for an
+// incoming channel apply to. Retuned notes may sound on any of the
+// retuner's channels, so channel-wide settings go to all of them.
+func (h *midiHandler) targetChannels(channel int32) []int32 {
+	if h.retuned(channel) {
+		return h.tuning.channelIndexes()
+	}
+	return []int32{channel}
+}
+
+// controlChange handles the controllers the synthesizer should react to.
+func (h *midiHandler) controlChange(channel, cc, value int32) {
+	switch cc {
+	case 0x07:
+		h.mix[channel].volume = value
+	case 0x0A:
+		h.mix[channel].pan = value
+	case 0x0B:
+		h.mix[channel].expression = value
+	case 0x79: // Reset All Controllers leaves volume and pan alone
+		h.mix[channel].expression = 127
+		h.slews[channel][2] = controlSlew{} // Expression
+		h.sostenuto(channel, false)
+		h.softPedal[channel] = false
+		h.portamento[channel].on = false
+		if h.modDepth(channel) != 0 {
+			h.modulation[channel], h.modPressure[channel] = 0, 0
+			h.updatePitch(channel)
+		}
+	case 0x78, 0x7B: // All Sound Off, All Notes Off end the notes the pedal holds
+		h.sostenutoPedals[channel].waiting = nil
+	}
+	if h.stuck != nil {
+		switch cc {
+		case 0x40:
+			h.stuck.sustain(channel, value >= 64)
+		case 0x79:
+			h.stuck.sustain(channel, false)
+		case 0x78, 0x7B: // All Sound Off, All Notes Off
+			h.stuck.clear(channel)
+		}
+	}
+	if cc == 0x01 && h.modWheel(channel, value) {
+		return
+	}
+	switch cc {
+	case 0x00, 0x20: // Bank Select
+		h.bankSelectChange(channel, cc, value)
+	case 0x02: // Breath
+		h.breath(channel, value)
+	case 0x7E, 0x7F: // Mono Mode On, Poly Mode On
+		h.setMono(channel, cc == 0x7E)
+	case 0x42: // Sostenuto
+		h.sostenuto(channel, value >= 64)
+	case 0x43: // Soft Pedal
+		h.softPedal[channel] = value >= 64
+	case 0x41: // Portamento
+		h.portamento[channel].on = value >= 64
+	case 0x05: // Portamento Time
+		h.portamento[channel].time = value
+	case 0x65, 0x64, 0x63, 0x62, 0x06, 0x26: // RPN/NRPN select and data entry
+		h.registeredParameter(channel, cc, value)
+	case 0x07, 0x27, 0x0A, 0x2A, 0x0B, 0x2B: // Channel Volume, Pan, Expression
+		if !h.smoothControl(channel, cc, value) {
+			for _, ch := range h.targetChannels(channel) {
+				h.synthesizer.ProcessMidiMessage(ch, 0xB0, cc, value)
+			}
+		}
+	case 0x01, 0x21, // Modulation
+		0x40,       // Hold Pedal
+		0x5B, 0x5D, // Reverb and Chorus Send
+		0x78, 0x79, 0x7B: // All Sound Off, Reset All Controllers, All Notes Off
+		for _, ch := range h.targetChannels(channel) {
+			h.synthesizer.ProcessMidiMessage(ch, 0xB0, cc, value)
+		}
+	}
+}
+
+// programChange selects a program on a channel, falling back to another
+// preset when the sound font doesn't have the requested one.
+func (h *midiHandler) programChange(channel, program int32) {
+	h.mix[channel].program = program
+	bank := h.banks[channel]
+	preset, found := h.presets.resolve(bank, program, h.fallback)
+	if preset == nil {
+		slog.Warn("No preset for program change", "channel", channel+1, "bank", bank, "program", program)
+		return
+	}
+	if !found {
+		slog.Warn("Program not in sound font, using a substitute", "channel", channel+1, "bank", bank, "program", program,
+			"substitute", fmt.Sprintf("%d:%d", preset.BankNumber, preset.PatchNumber), "name", preset.Name)
+	}
+	h.mix[channel].preset = preset.Name
+	slog.Info("Program change", "channel", channel+1,
+		"program", fmt.Sprintf("#%d %s", program+1, gmName(bank, program)), "preset", preset.Name)
+
+	if h.retuned(channel) {
+		// The retuner selects it for every note of the channel.
+		h.tuning.setPreset(channel, presetRef{preset.BankNumber, preset.PatchNumber})
+		return
+	}
+	for _, ch := range h.targetChannels(channel) {
+		selectBank(h.synthesizer, ch, preset.BankNumber)
+		h.synthesizer.ProcessMidiMessage(ch, 0xC0, preset.PatchNumber, 0)
+	}
+}
+
+// findPort finds a MIDI port by number or by part of its name.
+func findPort(names []string, spec string) (int, error) {
+	if index, err := strconv.Atoi(spec); err == nil {
+		if index < 0 || index >= len(names) {
+			return 0, fmt.Errorf("invalid port index: %d", index)
+		}
+		return index, nil
+	}
+	for i, name := range names {
+		if strings.Contains(strings.ToLower(name), strings.ToLower(spec)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no MIDI port matches %q", spec)
+}
+
+// flagSet reports whether a flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// loadSoundFont reads a SoundFont (SF2 or SF3) file, an SFZ instrument or
+// a DLS bank. http(s) URLs are downloaded and cached first.
+func loadSoundFont(path string) (*meltysynth.SoundFont, error) {
+	if isURL(path) {
+		if strings.EqualFold(filepath.Ext(path), ".sfz") {
+			return nil, fmt.Errorf("SFZ instruments can't be loaded from a URL, as their samples are separate files")
+		}
+		var err error
+		if path, err = cachedDownload(path); err != nil {
+			return nil, err
+		}
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".sfz":
+		return loadSfz(path)
+	case ".dls":
+		return loadDls(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = decompressSoundFont(data); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return meltysynth.NewSoundFont(bytes.NewReader(data))
+}
+
+// main function
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "analyze":
+			runAnalyze(os.Args[2:])
+			return
+		case "ctl":
+			runClient(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "devices":
+			runDevices(os.Args[2:])
+			return
+		case "write-fallback":
+			runWriteFallback(os.Args[2:])
+			return
+		}
+	}
+
+	verbose := flag.Bool("verbose", false, "log every MIDI message and other debug output")
+	quiet := flag.Bool("quiet", false, "only log warnings and errors")
+	logJSON := flag.Bool("log-json", false, "write logs as JSON lines")
+	logMidiKinds := flag.String("log-midi-kinds", defaultMidiLogKinds, "message kinds logged with -verbose: "+strings.Join(messageKinds, ", "))
+	logMidiChannels := flag.String("log-midi-channels", "", "channels (1-16, comma separated) logged with -verbose; all if empty")
+	configPath := flag.String("config", "", "JSON configuration file (key zones, layers and chord memory)")
+	daemon := flag.Bool("daemon", false, "run headless, controlled through the -socket command socket")
+	socketPath := flag.String("socket", "", "serve control commands on this Unix socket (default "+defaultSocketPath()+" with -daemon)")
+	trayIcon := flag.Bool("tray", false, "show a system tray icon with quick actions (panic, mute, MIDI input, sound font, quit)")
+	profileName := flag.String("profile", "", "named profile from the -config file to apply (sound font, devices, audio settings)")
+	sessionPath := flag.String("session", "", "save the channel state and sound font to this file on exit and restore it on start")
+	soundFontPath := flag.String("soundfont", "Mergedsoundfont.sf2", "SoundFont (.sf2, .sf3), SFZ (.sfz) or DLS (.dls) file or http(s) URL to load; a built-in fallback is used if it is empty or fails to load")
+	compareSoundFont := flag.String("compare-soundfont", "", "second sound font kept loaded to compare with -soundfont; the compare-soundfont action (bound to a key or controller in the -config file) switches between them")
+	mpe := flag.Bool("mpe", false, "enable MPE mode (lower zone, channel 1 as manager)")
+	mpeBendRange := flag.Int("mpe-bend-range", 48, "pitch bend range of MPE member channels in semitones")
+	mpeCollapse := flag.Int("mpe-collapse", 0, "fold an MPE controller's lower zone into this channel (1-16) instead of using MPE mode")
+	masterTuning := flag.Float64("tuning", 440, "master tuning: the frequency of A4 in Hz (400-480), e.g. 442 to play along with an ensemble")
+	sclPath := flag.String("scl", "", "Scala scale file (.scl) to retune incoming notes with")
+	kbmPath := flag.String("kbm", "", "Scala keyboard mapping file (.kbm) for -scl")
+	mts := flag.Bool("mts", false, "retune notes with MIDI Tuning Standard SysEx messages; melodic channels then share the retuner's channels, so this suits one instrument at a time")
+	nrpnMapping := flag.String("nrpn-map", "", "NRPN mappings as \"msb:lsb=target\" pairs separated by commas; target is ccN or master-volume")
+	aftertouch := flag.String("aftertouch", "mod", "channel aftertouch target: off, mod or expression")
+	bankSelectFlag := flag.String("bank-select", "msb", "how Bank Select picks sound font banks: msb (GS and most sound fonts), lsb (XG, MSB 127 for drums), both (MSB*128+LSB) or off")
+	polyAftertouch := flag.String("poly-aftertouch", "mod", "polyphonic aftertouch target: off, mod or expression")
+	controller := flag.String("controller", "", "built-in controller profile: "+strings.Join(controllerProfileNames(), ", "))
+	bleMidi := flag.String("ble-midi", "", "also play a Bluetooth LE MIDI device whose name contains this, or \"any\", connecting directly without pairing it with the system (builds with -tags ble)")
+	umpIn := flag.String("ump-in", "", "also play MIDI 2.0 (Universal MIDI Packets) from this device, e.g. /dev/snd/umpC1D0 on Linux 6.5 and later")
+	alsaSeq := flag.String("alsa-seq", "", "on Linux, instead of opening -midi-in, appear as an ALSA sequencer client with this name that other clients and patchbays (aconnect, qjackctl) connect to")
+	winrtMidi := flag.Bool("winrt-midi", false, "on Windows, open -midi-in through the WinRT MIDI API instead of WinMM; with Windows MIDI Services the device stays available to other applications, such as a DAW")
+	midiInPort := flag.String("midi-in", "0", "MIDI input port: its number or part of its name")
+	monoChannels := flag.String("mono-channels", "none", "channels (1-16, comma separated) in monophonic legato mode; Mono Mode On and Poly Mode On (CC126/127) switch others")
+	breathFlag := flag.String("breath", "off", "breath controller (CC2) target: off, expression, volume or mod")
+	breathCurve := flag.Float64("breath-curve", 1, "breath response curve exponent: below 1 makes soft breath louder, above 1 quieter")
+	vibratoDepth := flag.Float64("vibrato-depth", 50, "vibrato depth in cents at full mod wheel (CC1); 0 leaves the mod wheel to the sound font")
+	vibratoRate := flag.Float64("vibrato-rate", 5.5, "vibrato rate in Hz")
+	softPedal := flag.Float64("soft-pedal", 0.3, "how much the soft pedal (CC67) lowers the velocity of notes, 0-1")
+	fixedVelocity := flag.Int("fixed-velocity", 0, "play every incoming note at this velocity (1-127), e.g. for drum pads with unreliable sensors; 0 keeps the velocities")
+	humanizeVelocity := flag.Int("humanize-velocity", 0, "vary the velocity of incoming notes randomly by up to this much either way (0-64)")
+	humanizeTiming := flag.Duration("humanize-timing", 0, "delay incoming notes randomly by up to this much, e.g. 10ms (at most 50ms)")
+	humanizeSeed := flag.Uint64("humanize-seed", 0, "random seed of -humanize-velocity and -humanize-timing, for repeatable results; 0 picks one")
+	ccSmoothing := flag.Duration("cc-smoothing", 0, "glide channel volume, pan and expression (CC7, CC10, CC11) to new values over this time, e.g. 20ms, against zipper noise (at most 200ms)")
+	stuckTimeout := flag.Duration("stuck-note-timeout", 0, "release notes held longer than this with the sustain pedal up, for controllers that drop Note Off; 0 disables")
+	midiReconnect := flag.Duration("midi-reconnect", 2*time.Second, "how often to check that the MIDI input device is still plugged in, reopening it when it returns; 0 disables")
+	audioBackend := flag.String("audio", "oto", "audio output: oto (the system's default output), jack or wasapi-exclusive (low-latency exclusive mode on Windows)")
+	jackName := flag.String("jack-name", "meltysynth", "JACK client name with -audio jack")
+	jackConnect := flag.Bool("jack-connect", true, "connect the JACK ports to the system playback ports")
+	wasapiPeriod := flag.Duration("wasapi-period", 0, "device period with -audio wasapi-exclusive (0 uses the device's minimum, usually 3ms)")
+	pipeWireName := flag.String("pipewire-name", "", "on Linux with PipeWire, name the audio node (and set its role and latency) in the PipeWire graph")
+	sampleRate := flag.Int("sample-rate", 48000, "audio sample rate in Hz")
+	deviceRate := flag.Int("output-rate", 0, "sample rate of the audio output if the device can't run at -sample-rate; the audio is resampled (0 uses -sample-rate)")
+	sampleAccurate := flag.Bool("sample-accurate", false, "play MIDI events at their exact time within a render block instead of at its start, delayed by the audio buffer")
+	stdout := flag.Bool("stdout", false, "write raw interleaved stereo PCM to standard output instead of playing it (logs stay on standard error)")
+	stdoutFormat := flag.String("stdout-format", "f32le", "sample format with -stdout: f32le or s16le")
+	streamAddr := flag.String("stream", "", "serve the live output as a WAV stream over HTTP on this address, e.g. :8000")
+	sceneChannel := flag.Int("scene-channel", 0, "incoming channel (1-16) whose program changes switch between the config's scenes (0 disables)")
+	effectChain := flag.String("effects", "", "master bus effects separated by semicolons, e.g. \"eq:low=3,high=-2;compressor:threshold=-12,ratio=4;delay:time=300,feedback=0.3,mix=0.2\"")
+	stereoWidth := flag.Float64("stereo-width", 1, "stereo width of the output: 0 is mono, 1 unchanged, above 1 widened (up to 2)")
+	ditherMode := flag.String("dither", "tpdf", "dither for 16-bit output and recordings: off, tpdf or shaped (noise-shaped TPDF)")
+	audioBuffer := flag.Duration("audio-buffer", 0, "audio buffered ahead of the output, e.g. 20ms; lower is more responsive, higher resists glitches (0 uses the defaults)")
+	audioBlock := flag.Int("audio-block", renderBlockFrames, "frames rendered at a time (8-1024); smaller blocks apply notes sooner but cost more CPU")
+	polyphony := flag.Int("polyphony", 500, "maximum number of voices")
+	midiOutPort := flag.Int("midi-out", -1, "MIDI output port that echoes the input (-1 disables MIDI thru)")
+	midiThruFilter := flag.String("midi-thru-filter", "", "message kinds not echoed to -midi-out: "+strings.Join(messageKinds, ", "))
+	drumChannels := flag.String("drum-channels", "10", "channels (1-16, comma separated) that use the drum bank 128; \"none\" for no drums")
+	midiThruMapped := flag.Bool("midi-thru-mapped", false, "echo messages after the -controller profile has been applied")
+	qwerty := flag.Bool("qwerty", false, "play from the computer keyboard instead of a MIDI input (used automatically when there is none)")
+	audioStatsInterval := flag.Duration("audio-stats", 0, "print rendering load, underruns and latency at this interval (e.g. 10s)")
+	voicesInterval := flag.Duration("voices", 0, "print the number of active voices at this interval (e.g. 5s)")
+	pprofAddr := flag.String("pprof", "", "serve net/http/pprof profiles on this address (e.g. localhost:6060)")
+	httpAddr := flag.String("http", "", "serve the HTTP control API on this address (e.g. localhost:8080)")
+	recordPath := flag.String("record", "", "record the audio output to this file; .wav or .flac (lossless, about half the size)")
+	recordNormalize := flag.String("record-normalize", "off", "normalize audio recordings when they end: off, peak (to -1 dBFS) or lufs (to -16 LUFS); a target can follow a colon, e.g. lufs:-14")
+	recordMidiPath := flag.String("record-midi", "", "record incoming MIDI to this Standard MIDI File")
+	arpMode := flag.String("arp", "", "arpeggiate held notes: up, down, up-down or random (default off)")
+	arpOctaves := flag.Int("arp-octaves", 1, "octave range of the arpeggiator")
+	arpRate := flag.String("arp-rate", "1/16", "arpeggiator step length as a note value, e.g. 1/8 or 1/16t")
+	arpGate := flag.Float64("arp-gate", 0.5, "fraction of a step each arpeggiated note sounds (0-1)")
+	arpLatch := flag.Bool("arp-latch", false, "keep arpeggiating released notes until the next chord")
+	arpClock := flag.String("arp-clock", "internal", "arpeggiator timebase: internal (-tempo) or midi (external MIDI clock)")
+	tempo := flag.Float64("tempo", 120, "internal tempo in BPM, used by the arpeggiator and metronome")
+	metronomeOn := flag.Bool("metronome", false, "play a metronome click at -tempo")
+	timeSignature := flag.String("time-signature", "4/4", "time signature of the metronome")
+	metronomeVolume := flag.Float64("metronome-volume", 0.3, "volume of the metronome click (0-1)")
+	presetGainsPath := flag.String("preset-gains", "", "JSON table of gain offsets in dB for presets, as written by analyze -out, applied to the velocity of their notes")
+	fallbackProgram := flag.String("fallback-program", "", "preset (\"program\" or \"bank:program\") used when a program change selects a missing preset")
+	flag.Parse()
+
+	// Load the config first: its profile may set any of the flags.
+	var cfg *config
+	if *configPath != "" {
+		var err error
+		if cfg, err = loadConfig(*configPath); err != nil {
+			fatal("Failed to load config", "err", err)
+		}
+	}
+	if *profileName != "" {
+		if cfg == nil {
+			fatal("-profile requires -config")
+		}
+		if err := cfg.applyProfile(*profileName); err != nil {
+			fatal("Failed to apply profile", "err", err)
+		}
+	}
+	setupLogging(*verbose, *quiet, *logJSON)
+	if *profileName != "" {
+		slog.Info("Profile", "name", *profileName)
+	}
+
+	var saved *session
+	if *sessionPath != "" {
+		var err error
+		if saved, err = loadSession(*sessionPath); err != nil {
+			fatal("Failed to load session", "err", err)
+		}
+		// The saved sound font applies unless another one is given.
+		if saved != nil && saved.SoundFont != "" && !flagSet("soundfont") {
+			*soundFontPath = saved.SoundFont
+		}
+	}
+
+	// JACK dictates the sample rate and exclusive WASAPI needs a rate the
+	// device supports, so open them before creating the synthesizer.
+	var jackOut *jackOutput
+	var wasapiOut *wasapiOutput
+	if *audioBlock < 8 || *audioBlock > 1024 {
+		fatal("Invalid audio block size", "frames", *audioBlock)
+	}
+	blockFrames, blocks := *audioBlock, renderBlocks
+	outputRate := *sampleRate
+	if *deviceRate > 0 {
+		outputRate = *deviceRate
+	}
+	switch *audioBackend {
+	case "oto":
+	case "jack":
+		var err error
+		if jackOut, err = openJackOutput(*jackName); err != nil {
+			fatal("Failed to open JACK output", "err", err)
+		}
+		defer jackOut.Close()
+		outputRate = jackOut.sampleRate()
+		if outputRate != *sampleRate && !flagSet("sample-rate") {
+			slog.Info("Using the JACK sample rate", "sampleRate", outputRate)
+			*sampleRate = outputRate
+		}
+	case "wasapi-exclusive":
+		var err error
+		if wasapiOut, err = openWasapiExclusive(*sampleRate, *wasapiPeriod); err != nil {
+			fatal("Failed to open WASAPI output", "err", err)
+		}
+		outputRate = wasapiOut.sampleRate()
+		// Keep two device buffers ready, rendered in small blocks.
+		if !flagSet("audio-block") {
+			blockFrames = lowLatencyBlockFrames
+		}
+		deviceFrames := wasapiOut.bufferFrames() * *sampleRate / outputRate
+		blocks = max(2, (2*deviceFrames+blockFrames-1)/blockFrames)
+		slog.Info("WASAPI exclusive mode", "bufferFrames", wasapiOut.bufferFrames(),
+			"latency", time.Duration(float64(wasapiOut.bufferFrames())*float64(time.Second)/float64(outputRate)))
+	default:
+		fatal("Unknown audio output", "audio", *audioBackend)
+	}
+	if *stdout && *audioBackend != "oto" {
+		fatal("-stdout replaces the audio output and can't be combined with -audio", "audio", *audioBackend)
+	}
+	if *audioBuffer > 0 && wasapiOut == nil {
+		// Size the ring buffer to the requested latency.
+		bufferFrames := int(audioBuffer.Seconds() * float64(*sampleRate))
+		blocks = max(2, (bufferFrames+blockFrames-1)/blockFrames)
+	}
+
+	// Load the sound font, falling back to the built-in one so that first
+	// runs make sound.
+	var soundFont *meltysynth.SoundFont
+	var err error
+	if *soundFontPath != "" {
+		soundFont, err = loadSoundFont(*soundFontPath)
+		if err != nil {
+			slog.Warn("Failed to load sound font, using the built-in fallback", "err", err)
+		}
+	}
+	if soundFont == nil {
+		if soundFont, err = fallbackSoundFont(); err != nil {
+			fatal("Failed to create the fallback sound font", "err", err)
+		}
+	}
+
+	// Create the synthesizer.
+	synthBlockFrames := blockFrames
+	if *sampleAccurate {
+		synthBlockFrames = min(blockFrames, scheduleFrames)
+	}
+	settings := &meltysynth.SynthesizerSettings{
+		SampleRate:            int32(*sampleRate),
+		BlockSize:             int32(synthBlockFrames), // 例: デフォルトのブロックサイズ
+		MaximumPolyphony:      int32(*polyphony),
+		EnableReverbAndChorus: false, // 例: リバーブとコーラスを有効にする
+	}
+
+	synthesizer, err := meltysynth.NewSynthesizer(soundFont, settings)
+	if err != nil {
+		fatal("Failed to create synthesizer", "err", err)
+	}
+
+	handler := newMidiHandler(synthesizer)
+	handler.soundFont = *soundFontPath
+	handler.logFilter, err = newMidiLogFilter(*logMidiKinds, *logMidiChannels)
+	if err != nil {
+		fatal("Invalid MIDI log filter", "err", err)
+	}
+	if *controller != "" {
+		handler.input, err = lookupControllerProfile(*controller)
+		if err != nil {
+			fatal("Invalid controller profile", "err", err)
+		}
+		slog.Info("Controller profile", "profile", handler.input.description)
+	}
+	handler.nrpn, err = parseNrpnMap(*nrpnMapping)
+	if err != nil {
+		fatal("Invalid NRPN mapping", "err", err)
+	}
+	handler.aftertouch, err = parsePressureTarget(*aftertouch)
+	if err != nil {
+		fatal("Invalid aftertouch target", "err", err)
+	}
+	handler.bankMode, err = parseBankSelectMode(*bankSelectFlag)
+	if err != nil {
+		fatal("Invalid bank select mode", "err", err)
+	}
+	handler.polyTarget, err = parsePressureTarget(*polyAftertouch)
+	if err != nil {
+		fatal("Invalid polyphonic aftertouch target", "err", err)
+	}
+	if *fallbackProgram != "" {
+		handler.fallback, err = parsePresetRef(*fallbackProgram)
+		if err != nil {
+			fatal("Invalid fallback program", "err", err)
+		}
+		if handler.presets.lookup(handler.fallback.bank, handler.fallback.program) == nil {
+			fatal("Fallback program is not in the sound font", "program", *fallbackProgram)
+		}
+	}
+	if *presetGainsPath != "" {
+		if handler.presetGains, err = loadPresetGains(*presetGainsPath); err != nil {
+			fatal("Failed to load preset gains", "err", err)
+		}
+		slog.Info("Preset gains", "path", *presetGainsPath, "presets", len(handler.presetGains))
+	}
+	handler.drums, err = parseChannelList(*drumChannels)
+	if err != nil {
+		fatal("Invalid drum channels", "err", err)
+	}
+	handler.applyDrumChannels()
+	if *softPedal < 0 || *softPedal > 1 {
+		fatal("Invalid soft pedal amount", "amount", *softPedal)
+	}
+	handler.softAmount = *softPedal
+	if *fixedVelocity < 0 || *fixedVelocity > 127 {
+		fatal("Invalid fixed velocity", "velocity", *fixedVelocity)
+	}
+	handler.fixedVelocity = byte(*fixedVelocity)
+	if *vibratoDepth < 0 || *vibratoDepth > 1200 || *vibratoRate <= 0 || *vibratoRate > 20 {
+		fatal("Invalid vibrato", "depth", *vibratoDepth, "rate", *vibratoRate)
+	}
+	handler.vibratoDepth, handler.vibratoRate = *vibratoDepth, *vibratoRate
+	handler.breathTarget, err = parseBreathTarget(*breathFlag)
+	if err != nil {
+		fatal("Invalid breath target", "err", err)
+	}
+	if *breathCurve < 0.1 || *breathCurve > 10 {
+		fatal("Invalid breath curve", "exponent", *breathCurve)
+	}
+	handler.breathCurve = *breathCurve
+	handler.monoDefault, err = parseChannelList(*monoChannels)
+	if err != nil {
+		fatal("Invalid mono channels", "err", err)
+	}
+	handler.monoMode = handler.monoDefault
+	if *humanizeVelocity < 0 || *humanizeVelocity > 64 || *humanizeTiming < 0 || *humanizeTiming > maxHumanizeTiming {
+		fatal("Invalid humanization", "velocity", *humanizeVelocity, "timing", *humanizeTiming)
+	}
+	if *humanizeVelocity > 0 || *humanizeTiming > 0 {
+		handler.humanize = newHumanizer(*humanizeVelocity, *humanizeTiming, *humanizeSeed)
+	}
+	if *ccSmoothing < 0 || *ccSmoothing > maxControlSmoothing {
+		fatal("Invalid controller smoothing", "time", *ccSmoothing)
+	}
+	handler.controlSmoothing = *ccSmoothing
+	if *stuckTimeout > 0 {
+		handler.stuck = newStuckNotes(*stuckTimeout)
+		go handler.watchStuckNotes()
+	}
+	if *mpe {
+		if *mpeBendRange < 1 || *mpeBendRange > 96 {
+			fatal("Invalid MPE pitch bend range", "semitones", *mpeBendRange)
+		}
+		handler.mpe = newMpeZone(synthesizer, int32(*mpeBendRange))
+		slog.Info("MPE mode enabled", "memberBendRange", *mpeBendRange)
+	}
+	if cfg != nil {
+		handler.zones = newKeyZones(cfg.Zones)
+		handler.bendRanges = cfg.BendRanges
+		handler.applyBendRanges()
+		handler.applyZoneSettings()
+		describeZones(handler.zones)
+		if cfg.Chord != nil {
+			handler.chord = newChordMemory(cfg.Chord)
+			slog.Info("Chord memory", "chord", handler.chord)
+		}
+	}
+	if *mpeCollapse != 0 {
+		if *mpe {
+			fatal("-mpe-collapse cannot be combined with MPE mode")
+		}
+		if *mpeCollapse < 1 || *mpeCollapse > channelCount || *mpeBendRange < 1 || *mpeBendRange > 96 {
+			fatal("Invalid MPE collapse settings", "channel", *mpeCollapse, "bendRange", *mpeBendRange)
+		}
+		handler.collapse = newMpeCollapser(byte(*mpeCollapse-1), int32(*mpeBendRange))
+		handler.configureCollapse()
+		slog.Info("MPE zone folded into one channel", "channel", *mpeCollapse)
+	}
+	if *arpMode != "" {
+		if *mpe {
+			fatal("The arpeggiator cannot be combined with MPE mode")
+		}
+		handler.arp, err = newArpeggiator(handler, *arpMode, *arpOctaves, *arpRate, *arpGate, *arpLatch)
+		if err != nil {
+			fatal("Invalid arpeggiator settings", "err", err)
+		}
+		switch *arpClock {
+		case "internal":
+			if *tempo <= 0 {
+				fatal("Invalid tempo", "bpm", *tempo)
+			}
+			clock := newInternalClock(*tempo, &handler.mu)
+			clock.subscribe(handler.arp)
+			clock.start()
+			slog.Info("Arpeggiator enabled", "mode", *arpMode, "bpm", *tempo)
+		case "midi":
+			handler.clock.subscribe(handler.arp)
+			slog.Info("Arpeggiator enabled, following MIDI clock", "mode", *arpMode)
+		default:
+			fatal("Invalid arpeggiator clock (want internal or midi)", "clock", *arpClock)
+		}
+	}
+	if *masterTuning < 400 || *masterTuning > 480 {
+		fatal("Invalid master tuning", "hz", *masterTuning)
+	}
+	handler.masterTune = 12 * math.Log2(*masterTuning/440)
+	handler.mts = *mts
+	if *sclPath != "" {
+		if *mpe {
+			fatal("Scala tuning cannot be combined with MPE mode")
+		}
+		table, description, err := loadScalaTuning(*sclPath, *kbmPath)
+		if err != nil {
+			fatal("Failed to load tuning", "err", err)
+		}
+		handler.useTuning(table)
+		slog.Info("Tuning loaded", "tuning", description)
+	} else if *kbmPath != "" {
+		fatal("-kbm requires -scl")
+	}
+
+	if handler.masterTune != 0 {
+		handler.applyMasterTuning()
+		slog.Info("Master tuning", "a4", *masterTuning)
+	}
+	if saved != nil {
+		handler.restoreSession(saved)
+	}
+
+	voices := newVoiceMeter(int(settings.MaximumPolyphony))
+	if *voicesInterval > 0 {
+		voices.printEvery(*voicesInterval)
+	}
+
+	if *httpAddr != "" {
+		err = newControlServer(handler, voices).listen(*httpAddr)
+		if err != nil {
+			fatal("Failed to start control API", "err", err)
+		}
+		slog.Info("Control API listening", "url", "http://"+*httpAddr+"/api/")
+	}
+
+	if *pprofAddr != "" {
+		if err := startProfiler(*pprofAddr); err != nil {
+			fatal("Failed to start profiler", "err", err)
+		}
+		slog.Info("Profiler listening", "url", "http://"+*pprofAddr+"/debug/pprof/")
+	}
+
+	// Set up MIDI input
+	var midiIn rtmidi.MIDIIn
+	if *alsaSeq != "" {
+		midiIn, err = rtmidi.NewMIDIIn(rtmidi.APILinuxALSA, *alsaSeq, 1024)
+	} else {
+		midiIn, err = rtmidi.NewMIDIInDefault()
+	}
+	if err != nil {
+		fatal("Failed to create MIDI input", "err", err)
+	}
+	input := &midiInput{in: midiIn, handler: handler}
+	defer input.Close()
+
+	// Get the count of available MIDI input devices
+	portCount, err := midiIn.PortCount()
+	if err != nil {
+		fatal("Failed to get port count", "err", err)
+	}
+
+	if *daemon && *qwerty {
+		fatal("-qwerty cannot be combined with -daemon")
+	}
+	useKeyboard := *qwerty
+	useMidiIn := !useKeyboard && !*winrtMidi
+	if portCount == 0 && !useKeyboard && *alsaSeq == "" && !*winrtMidi {
+		useMidiIn = false
+		if *daemon {
+			slog.Warn("No MIDI input devices found")
+		} else {
+			slog.Warn("No MIDI input devices found, playing from the computer keyboard")
+			useKeyboard = true
+		}
+	}
+
+	var names []string
+	portIndex := 0
+	if useMidiIn && *alsaSeq != "" {
+		// Other clients connect to the port, so there's nothing to watch.
+		if err := midiIn.OpenVirtualPort(alsaSeqPortName); err != nil {
+			fatal("Failed to create ALSA sequencer port", "err", err)
+		}
+		// Receive SysEx (tuning dumps) and timing (clock sync), but keep ignoring active sensing
+		if err := midiIn.IgnoreTypes(false, false, true); err != nil {
+			fatal("Failed to configure MIDI input", "err", err)
+		}
+		input.name, input.open = *alsaSeq, true
+		slog.Info("ALSA sequencer client", "name", *alsaSeq, "port", alsaSeqPortName)
+	} else if useMidiIn {
+		names = make([]string, portCount)
+		for i := 0; i < portCount; i++ {
+			deviceName, err := midiIn.PortName(i)
+			if err != nil {
+				fatal("Failed to get port name", "err", err)
+			}
+			names[i] = deviceName
+			slog.Info("MIDI input device", "port", i, "name", deviceName)
+		}
+
+		portIndex, err = findPort(names, *midiInPort)
+		if err != nil {
+			fatal("Invalid MIDI input", "err", err)
+		}
+		// The port is opened on an input of its own, which the tray and
+		// the watchdog replace when they open it again.
+		err = input.openPort(portIndex, names)
+		if err != nil {
+			fatal("Failed to open MIDI port", "err", err)
+		}
+	}
+
+	if *midiOutPort >= 0 {
+		handler.thru, err = openMidiThru(*midiOutPort)
+		if err != nil {
+			fatal("Failed to open MIDI output", "err", err)
+		}
+		defer handler.thru.out.Close()
+		handler.thru.drop, err = parseMessageKinds(*midiThruFilter)
+		if err != nil {
+			fatal("Invalid MIDI thru filter", "err", err)
+		}
+		handler.thru.mapped = *midiThruMapped
+	}
+
+	var recorder *midiRecorder
+	if *recordMidiPath != "" {
+		recorder, err = newMidiRecorder(*recordMidiPath)
+		if err != nil {
+			fatal("Failed to create MIDI recording", "err", err)
+		}
+		slog.Info("Recording MIDI", "path", *recordMidiPath)
+	}
+
+	if useKeyboard {
+		restore, err := startQwertyInput(recorder.wrap(handler.handleMidiMessage))
+		if err != nil {
+			fatal("Failed to read the computer keyboard", "err", err)
+		}
+		defer restore()
+	} else if *winrtMidi {
+		var channels *[channelCount]byte
+		var ranges []noteRangeConfig
+		if cfg != nil {
+			channels = cfg.ChannelMap.table()
+			ranges = cfg.NoteRanges
+		}
+		in, name, err := openWinrtMidi(*midiInPort, func(msg []byte) {
+			msg = remapChannels(channels, msg)
+			if recorder != nil {
+				recorder.recordNow(msg)
+			}
+			if inNoteRange(ranges, msg) {
+				handler.handleMidiMessage(msg)
+			}
+		})
+		if err != nil {
+			fatal("Failed to open WinRT MIDI input", "err", err)
+		}
+		defer in.Close()
+		slog.Info("WinRT MIDI input", "device", name)
+	} else if useMidiIn {
+		// Set the callback function for MIDI input
+		var channels *[channelCount]byte
+		var ranges []noteRangeConfig
+		if cfg != nil {
+			channels = cfg.ChannelMap.table()
+			ranges = cfg.NoteRanges
+		}
+		play := handler.newInput(ranges)
+		err = input.setCallback(func(midiIn rtmidi.MIDIIn, msg []byte, deltaTime float64) {
+			msg = remapChannels(channels, msg)
+			if recorder != nil {
+				recorder.record(msg, deltaTime)
+			}
+			play(msg)
+		})
+		if err != nil {
+			fatal("Failed to set MIDI callback", "err", err)
+		}
+		if *midiReconnect > 0 && *alsaSeq == "" {
+			go input.watch(*midiReconnect)
+		}
+	}
+	if cfg != nil {
+		for i, c := range cfg.Inputs {
+			in, err := openInput(c, handler, recorder)
+			if err != nil {
+				fatal("Failed to open MIDI input", "input", i+1, "err", err)
+			}
+			defer in.Close()
+		}
+	}
+	if *bleMidi != "" {
+		in, name, err := openBleMidi(*bleMidi, recorder.wrap(handler.handleMidiMessage))
+		if err != nil {
+			fatal("Failed to open BLE MIDI device", "err", err)
+		}
+		defer in.Close()
+		slog.Info("BLE MIDI input", "device", name)
+	}
+	if *umpIn != "" {
+		in, err := openUmpInput(*umpIn, recorder.wrap(handler.handleMidiMessage))
+		if err != nil {
+			fatal("Failed to open MIDI 2.0 input", "err", err)
+		}
+		defer in.Close()
+		slog.Info("MIDI 2.0 input", "path", *umpIn)
+	}
+
+	var click *metronome
+	if *metronomeOn {
+		click, err = newMetronome(*sampleRate, *tempo, *timeSignature, *metronomeVolume)
+		if err != nil {
+			fatal("Invalid metronome settings", "err", err)
+		}
+		slog.Info("Metronome enabled", "bpm", *tempo, "timeSignature", *timeSignature)
+	}
+
+	// Create an instance of the audio reader
+	stats := newAudioStats(*sampleRate)
+	if *audioStatsInterval > 0 {
+		stats.printEvery(*audioStatsInterval)
+	}
+	render := newRenderer(synthesizer, &handler.mu, *sampleRate, blockFrames, blocks)
+	if outputRate != *sampleRate {
+		slog.Info("Resampling the output", "from", *sampleRate, "to", outputRate)
+		render.setOutputRate(outputRate)
+	}
+	render.metronome = click
+	render.stats = stats
+	render.voices = voices
+	if *stereoWidth < 0 || *stereoWidth > 2 {
+		fatal("Invalid stereo width", "width", *stereoWidth)
+	}
+	render.width = float32(*stereoWidth)
+	if *sampleAccurate {
+		handler.events = newEventQueue(handler.playMessage)
+		render.scheduleEvents(handler.events)
+		slog.Info("Sample-accurate MIDI timing", "delay", render.clock.duration())
+	}
+	if cfg != nil {
+		for i, c := range cfg.Instances {
+			inst, err := openInstance(c, handler)
+			if err != nil {
+				fatal("Failed to start sound module", "instance", i+1, "err", err)
+			}
+			defer inst.Close()
+			render.extra = append(render.extra, inst.handler)
+		}
+	}
+	if render.effects, err = parseEffects(*effectChain, *sampleRate); err != nil {
+		fatal("Invalid effect chain", "err", err)
+	}
+	render.start()
+
+	// Every 16-bit output gets its own ditherer, as they keep state.
+	if _, err := newDitherer(*ditherMode); err != nil {
+		fatal("Invalid -dither", "err", err)
+	}
+	newDither := func() *ditherer {
+		d, _ := newDitherer(*ditherMode)
+		return d
+	}
+
+	normalize, err := parseNormalization(*recordNormalize)
+	if err != nil {
+		fatal("Invalid -record-normalize", "err", err)
+	}
+	controls := newAppControls(handler, render, *configPath, *sampleRate, *ditherMode, normalize)
+	if *compareSoundFont != "" {
+		if controls.compare, err = loadSoundFont(*compareSoundFont); err != nil {
+			fatal("Failed to load the sound font to compare", "path", *compareSoundFont, "err", err)
+		}
+		controls.comparePath = *compareSoundFont
+		slog.Info("Sound font to compare", "path", *compareSoundFont)
+	}
+	if *sceneChannel < 0 || *sceneChannel > channelCount {
+		fatal("Invalid scene channel", "channel", *sceneChannel)
+	}
+	if cfg != nil {
+		handler.mu.Lock()
+		handler.bindings = cfg.Bindings
+		if len(cfg.Scenes) > 0 {
+			if handler.scenes, err = newSceneSwitcher(cfg, *effectChain, render, *sampleRate, *sceneChannel); err != nil {
+				fatal("Invalid scene", "err", err)
+			}
+			slog.Info("Scenes", "count", len(cfg.Scenes), "channel", *sceneChannel)
+		}
+		handler.mu.Unlock()
+	}
+	if *recordPath != "" {
+		if err := controls.startRecording(*recordPath); err != nil {
+			fatal("Failed to create audio recording", "err", err)
+		}
+	}
+
+	if *streamAddr != "" {
+		stream := &audioStream{taps: &render.taps, sampleRate: *sampleRate, dither: *ditherMode}
+		if err := stream.listen(*streamAddr); err != nil {
+			fatal("Failed to start audio stream", "err", err)
+		}
+		slog.Info("Streaming audio", "url", "http://"+*streamAddr+"/stream.wav")
+	}
+
+	outputClosed := make(chan struct{}) // closed when -stdout's reader goes away
+	if jackOut != nil {
+		if err := jackOut.start(render.ring, stats, *jackConnect); err != nil {
+			fatal("Failed to start JACK output", "err", err)
+		}
+		slog.Info("Playing through JACK", "client", *jackName)
+	} else if wasapiOut != nil {
+		wasapiOut.play(render.ring, stats)
+	} else if *stdout {
+		pcm, err := newPCMWriter(os.Stdout, render.ring, outputRate, *stdoutFormat, newDither())
+		if err != nil {
+			fatal("Invalid -stdout-format", "err", err)
+		}
+		pcm.stats = stats
+		slog.Info("Writing PCM to standard output", "format", *stdoutFormat, "sampleRate", outputRate, "channels", 2)
+		// Get write errors instead of being killed when the reader exits, so
+		// that the program shuts down normally.
+		signal.Ignore(syscall.SIGPIPE)
+		go func() {
+			err := pcm.run(blockFrames)
+			slog.Info("Standard output closed", "err", err)
+			close(outputClosed)
+		}()
+	} else {
+		if *pipeWireName != "" {
+			if err := describePipeWireNode(*pipeWireName, outputRate, blockFrames); err != nil {
+				fatal("Failed to describe the PipeWire node", "err", err)
+			}
+		}
+
+		// Initialize Oto for audio playback
+		options := oto.NewContextOptions{
+			SampleRate:   outputRate,
+			ChannelCount: 2,
+			Format:       oto.FormatFloat32LE, // Change this to int16 for 16-bit output
+			BufferSize:   *audioBuffer,
+		}
+
+		context, ready, err := oto.NewContext(&options)
+		if err != nil {
+			fatal("Failed to create audio context", "err", err)
+		}
+
+		// Wait for the context to be ready
+		<-ready
+
+		audioReader := &AudioReader{ring: render.ring, stats: stats}
+
+		// Create a new player that will read from the AudioReader
+		player := context.NewPlayer(audioReader)
+		if player == nil {
+			fatal("Failed to create player")
+		}
+
+		// Play starts playing the sound and returns without waiting for it (Play() is async).
+		player.Play()
+	}
+
+	if *daemon && *socketPath == "" {
+		*socketPath = defaultSocketPath()
+	}
+	var control *controlSocket
+	quit := make(chan struct{})
+	if *socketPath != "" {
+		control, err = listenControlSocket(*socketPath, handler, render)
+		if err != nil {
+			fatal("Failed to open control socket", "err", err)
+		}
+		defer control.Close()
+		quit = control.quit
+		slog.Info("Control socket listening", "path", *socketPath)
+	}
+
+	// Keep the program running until interrupted or told to quit
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	wait := func() {
+		select {
+		case <-interrupt:
+		case <-quit:
+		case <-outputClosed:
+		}
+	}
+	if *trayIcon {
+		// The tray runs its event loop on the main goroutine, as macOS
+		// requires, until it's closed from its menu or here.
+		go func() {
+			wait()
+			systray.Quit()
+		}()
+		tray := &trayMenu{handler: handler, renderer: render, ports: names, port: portIndex}
+		tray.openPort = func(port int) error {
+			return input.openPort(port, names)
+		}
+		tray.run()
+	} else {
+		wait()
+	}
+
+	if *sessionPath != "" {
+		if err := handler.session().save(*sessionPath); err != nil {
+			slog.Error("Failed to save session", "err", err)
+		} else {
+			slog.Info("Saved session", "path", *sessionPath)
+		}
+	}
+	controls.stopRecording()
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			fatal("Failed to finish MIDI recording", "err", err)
+		}
+		slog.Info("Saved MIDI recording", "path", *recordMidiPath)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	clickDuration = 0.05  // seconds
+	clickDecay    = 0.012 // seconds for the click to fall to 1/e
+)
+
+// metronome generates a click track that is mixed into the output. The first
+// beat of every bar is accented with a higher click.
+type metronome struct {
+	sampleRate  float64
+	beatLength  float64 // samples per beat
+	beatsPerBar int
+	volume      float64
+
+	position float64 // samples since the current beat started
+	beat     int     // beat within the bar
+}
+
+// newMetronome creates a metronome for a tempo in quarter notes per minute
+// and a time signature like "4/4" or "6/8".
+func newMetronome(sampleRate int, tempo float64, signature string, volume float64) (*metronome, error) {
+	beats, unit, err := parseTimeSignature(signature)
+	if err != nil {
+		return nil, err
+	}
+	if tempo <= 0 {
+		return nil, fmt.Errorf("invalid tempo %v", tempo)
+	}
+	quarter := float64(sampleRate) * 60 / tempo
+	return &metronome{
+		sampleRate:  float64(sampleRate),
+		beatLength:  quarter * 4 / float64(unit),
+		beatsPerBar: beats,
+		volume:      volume,
+	}, nil
+}
+
+// parseTimeSignature parses "beats/unit", where unit is a power of two.
+func parseTimeSignature(s string) (int, int, error) {
+	beatsText, unitText, ok := strings.Cut(s, "/")
+	beats, err1 := strconv.Atoi(beatsText)
+	unit, err2 := strconv.Atoi(unitText)
+	if !ok || err1 != nil || err2 != nil || beats < 1 || beats > 32 || unit < 1 || unit > 32 || unit&(unit-1) != 0 {
+		return 0, 0, fmt.Errorf("invalid time signature %q", s)
+	}
+	return beats, unit, nil
+}
+
+// next returns the next sample of the click track.
+func (m *metronome) next() float32 {
+	var sample float64
+	t := m.position / m.sampleRate
+	if t < clickDuration {
+		frequency := 1000.0
+		if m.beat == 0 {
+			frequency = 1500
+		}
+		sample = m.volume * math.Sin(2*math.Pi*frequency*t) * math.Exp(-t/clickDecay)
+	}
+
+	m.position++
+	if m.position >= m.beatLength {
+		m.position -= m.beatLength
+		m.beat = (m.beat + 1) % m.beatsPerBar
+	}
+	return float32(sample)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultMidiLogKinds logs everything except real-time messages such as
+// clock and active sensing, which arrive constantly.
+const defaultMidiLogKinds = "note,poly-pressure,cc,program,pressure,pitch-bend,sysex,common"
+
+// midiLogFilter selects the MIDI messages that are logged in verbose mode.
+type midiLogFilter struct {
+	kinds    map[string]bool    // kinds that are logged
+	channels [channelCount]bool // channels that are logged, none set for all
+}
+
+// newMidiLogFilter parses the kinds and channels to log. Empty channels
+// logs every channel.
+func newMidiLogFilter(kinds, channels string) (*midiLogFilter, error) {
+	f := &midiLogFilter{}
+	var err error
+	if f.kinds, err = parseMessageKinds(kinds); err != nil {
+		return nil, err
+	}
+	if channels != "" {
+		if f.channels, err = parseChannelList(channels); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// allows reports whether a message should be logged. System messages have
+// no channel and pass the channel filter. A nil filter allows everything.
+func (f *midiLogFilter) allows(msg []byte) bool {
+	if f == nil {
+		return true
+	}
+	if !f.kinds[messageKind(msg)] {
+		return false
+	}
+	if msg[0] >= 0xF0 || f.channels == [channelCount]bool{} {
+		return true
+	}
+	return f.channels[msg[0]&0x0F]
+}
+
+// controllerNames names the common controllers in logged messages.
+var controllerNames = map[byte]string{
+	0: "Bank Select", 1: "Modulation", 2: "Breath", 4: "Foot", 5: "Portamento Time",
+	6: "Data Entry", 7: "Volume", 10: "Pan", 11: "Expression", 32: "Bank Select LSB",
+	38: "Data Entry LSB", 64: "Sustain", 65: "Portamento", 66: "Sostenuto", 67: "Soft Pedal",
+	74: "Brightness", 91: "Reverb", 93: "Chorus", 98: "NRPN LSB", 99: "NRPN MSB",
+	100: "RPN LSB", 101: "RPN MSB", 120: "All Sound Off", 121: "Reset All Controllers",
+	123: "All Notes Off",
+}
+
+// describeMessage decodes a MIDI message for the log, e.g.
+// "Note On ch1 C4 vel 100". Channels are numbered 1-16.
+func describeMessage(msg []byte) string {
+	status := msg[0]
+	switch status {
+	case 0xF0:
+		return fmt.Sprintf("SysEx %d bytes", len(msg))
+	case 0xF1:
+		return "MTC Quarter Frame"
+	case 0xF2:
+		if len(msg) >= 3 {
+			return fmt.Sprintf("Song Position %d", int(msg[1])|int(msg[2])<<7)
+		}
+	case 0xF3:
+		return "Song Select"
+	case 0xF6:
+		return "Tune Request"
+	case 0xF8:
+		return "Clock"
+	case 0xFA:
+		return "Start"
+	case 0xFB:
+		return "Continue"
+	case 0xFC:
+		return "Stop"
+	case 0xFE:
+		return "Active Sensing"
+	case 0xFF:
+		return "System Reset"
+	}
+	if status >= 0xF0 {
+		return fmt.Sprintf("System % X", msg)
+	}
+
+	channel := status&0x0F + 1
+	data := func(i int) int {
+		if i < len(msg) {
+			return int(msg[i])
+		}
+		return 0
+	}
+	switch status & 0xF0 {
+	case 0x90:
+		if data(2) == 0 {
+			return fmt.Sprintf("Note Off ch%d %s vel 0", channel, noteName(data(1)))
+		}
+		return fmt.Sprintf("Note On ch%d %s vel %d", channel, noteName(data(1)), data(2))
+	case 0x80:
+		return fmt.Sprintf("Note Off ch%d %s vel %d", channel, noteName(data(1)), data(2))
+	case 0xA0:
+		return fmt.Sprintf("Poly Pressure ch%d %s %d", channel, noteName(data(1)), data(2))
+	case 0xB0:
+		cc := fmt.Sprintf("CC%d", data(1))
+		if name, ok := controllerNames[byte(data(1))]; ok {
+			cc += " " + name
+		}
+		return fmt.Sprintf("Control Change ch%d %s = %d", channel, cc, data(2))
+	case 0xC0:
+		return fmt.Sprintf("Program Change ch%d %d", channel, data(1))
+	case 0xD0:
+		return fmt.Sprintf("Channel Pressure ch%d %d", channel, data(1))
+	default:
+		return fmt.Sprintf("Pitch Bend ch%d %+d", channel, data(1)|data(2)<<7-8192)
+	}
+}
+
+// noteName names a key, with C4 = 60 like in the config file.
+func noteName(key int) string {
+	names := strings.Split("C C# D D# E F F# G G# A A# B", " ")
+	return fmt.Sprintf("%s%d", names[key%12], key/12-1)
+}
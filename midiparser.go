@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// maxSysExLength bounds a System Exclusive message, so a missing End of
+// Exclusive can't buffer forever.
+const maxSysExLength = 64 << 10
+
+// midiParser splits MIDI data into complete messages. Interfaces don't
+// always deliver one message at a time: some send several in a packet, use
+// running status, put real-time bytes such as clock in the middle of other
+// messages, or drop bytes.
+type midiParser struct {
+	status byte   // running status, 0 if none
+	buf    []byte // message in progress
+	sysex  bool   // whether buf is a System Exclusive message
+}
+
+// feed parses data and calls emit for every complete message. An
+// incomplete message is kept until the next call, and dropped if a status
+// byte interrupts it. emit may keep the message.
+func (p *midiParser) feed(data []byte, emit func([]byte)) {
+	for _, b := range data {
+		switch {
+		case b >= 0xF8: // System Real-Time may appear anywhere
+			emit([]byte{b})
+
+		case b == 0xF7 && p.sysex: // End of Exclusive
+			emit(append(p.buf, b))
+			p.buf, p.sysex = nil, false
+
+		case b >= 0x80:
+			p.drop()
+			switch {
+			case b == 0xF0:
+				p.buf, p.sysex, p.status = []byte{b}, true, 0
+			case b == 0xF7: // stray End of Exclusive
+			case b >= 0xF0: // System Common cancels running status
+				p.status = 0
+				p.start(b, emit)
+			default:
+				p.status = b
+				p.start(b, emit)
+			}
+
+		case p.sysex:
+			if len(p.buf) >= maxSysExLength {
+				p.drop()
+				continue
+			}
+			p.buf = append(p.buf, b)
+
+		default:
+			if p.buf == nil {
+				if p.status == 0 {
+					continue // data without a status
+				}
+				p.buf = []byte{p.status}
+			}
+			p.buf = append(p.buf, b)
+			if len(p.buf) == 1+messageDataLength(p.buf[0]) {
+				emit(p.buf)
+				p.buf = nil
+			}
+		}
+	}
+}
+
+// start begins a message, emitting it right away if it has no data.
+func (p *midiParser) start(status byte, emit func([]byte)) {
+	if messageDataLength(status) == 0 {
+		emit([]byte{status})
+		return
+	}
+	p.buf = []byte{status}
+}
+
+// drop discards the message in progress.
+func (p *midiParser) drop() {
+	if p.buf != nil {
+		slog.Debug("Dropped incomplete MIDI message", "bytes", fmt.Sprintf("% X", p.buf))
+	}
+	p.buf, p.sysex = nil, false
+}
+
+// messageDataLength returns the number of data bytes following a status
+// byte other than System Exclusive.
+func messageDataLength(status byte) int {
+	switch {
+	case status < 0xF0:
+		if s := status & 0xF0; s == 0xC0 || s == 0xD0 {
+			return 1
+		}
+		return 2
+	case status == 0xF1, status == 0xF3: // MTC Quarter Frame, Song Select
+		return 1
+	case status == 0xF2: // Song Position Pointer
+		return 2
+	}
+	return 0
+}
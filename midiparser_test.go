@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// parse feeds every chunk to one parser and returns the messages.
+func parse(chunks ...[]byte) [][]byte {
+	var p midiParser
+	var messages [][]byte
+	for _, chunk := range chunks {
+		p.feed(chunk, func(msg []byte) {
+			messages = append(messages, msg)
+		})
+	}
+	return messages
+}
+
+func TestMidiParser(t *testing.T) {
+	longSysEx := append([]byte{0xF0}, bytes.Repeat([]byte{0x01}, maxSysExLength)...)
+	tests := []struct {
+		name   string
+		chunks [][]byte
+		want   [][]byte
+	}{
+		{
+			name:   "one message per chunk",
+			chunks: [][]byte{{0x90, 60, 100}, {0x80, 60, 0}},
+			want:   [][]byte{{0x90, 60, 100}, {0x80, 60, 0}},
+		},
+		{
+			name:   "several messages in a chunk",
+			chunks: [][]byte{{0x90, 60, 100, 0xC0, 5, 0xB0, 7, 90}},
+			want:   [][]byte{{0x90, 60, 100}, {0xC0, 5}, {0xB0, 7, 90}},
+		},
+		{
+			name:   "running status",
+			chunks: [][]byte{{0x90, 60, 100, 64, 100}, {67, 0}},
+			want:   [][]byte{{0x90, 60, 100}, {0x90, 64, 100}, {0x90, 67, 0}},
+		},
+		{
+			name:   "running status with one data byte",
+			chunks: [][]byte{{0xD3, 10, 20, 30}},
+			want:   [][]byte{{0xD3, 10}, {0xD3, 20}, {0xD3, 30}},
+		},
+		{
+			name:   "system common cancels running status",
+			chunks: [][]byte{{0x90, 60, 100, 0xF3, 2, 64, 100}},
+			want:   [][]byte{{0x90, 60, 100}, {0xF3, 2}},
+		},
+		{
+			name:   "real-time keeps running status",
+			chunks: [][]byte{{0x90, 60, 100, 0xF8, 64, 100}},
+			want:   [][]byte{{0x90, 60, 100}, {0xF8}, {0x90, 64, 100}},
+		},
+		{
+			name:   "real-time in the middle of a message",
+			chunks: [][]byte{{0x90, 60, 0xF8, 100, 0xB0, 0xFE, 7}, {0xFA, 90}},
+			want:   [][]byte{{0xF8}, {0x90, 60, 100}, {0xFE}, {0xFA}, {0xB0, 7, 90}},
+		},
+		{
+			name:   "message split across chunks",
+			chunks: [][]byte{{0x90}, {60}, {100}},
+			want:   [][]byte{{0x90, 60, 100}},
+		},
+		{
+			name:   "data without status",
+			chunks: [][]byte{{60, 100, 0x90, 60, 100}},
+			want:   [][]byte{{0x90, 60, 100}},
+		},
+		{
+			name:   "status byte ends an incomplete message",
+			chunks: [][]byte{{0x90, 60, 0x80, 60, 0}},
+			want:   [][]byte{{0x80, 60, 0}},
+		},
+		{
+			name:   "SysEx",
+			chunks: [][]byte{{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7}},
+			want:   [][]byte{{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7}},
+		},
+		{
+			name:   "SysEx split across chunks",
+			chunks: [][]byte{{0xF0, 0x7E}, {0x7F, 0x09}, {0x01, 0xF7}},
+			want:   [][]byte{{0xF0, 0x7E, 0x7F, 0x09, 0x01, 0xF7}},
+		},
+		{
+			name:   "real-time inside SysEx",
+			chunks: [][]byte{{0xF0, 0x7E, 0xF8, 0x7F}, {0xFE, 0xF7}},
+			want:   [][]byte{{0xF8}, {0xFE}, {0xF0, 0x7E, 0x7F, 0xF7}},
+		},
+		{
+			name:   "status byte ends SysEx early",
+			chunks: [][]byte{{0xF0, 0x7E, 0x7F}, {0x90, 60, 100, 0xF7}},
+			want:   [][]byte{{0x90, 60, 100}},
+		},
+		{
+			name:   "new SysEx ends an unfinished one",
+			chunks: [][]byte{{0xF0, 0x01, 0x02, 0xF0, 0x03, 0xF7}},
+			want:   [][]byte{{0xF0, 0x03, 0xF7}},
+		},
+		{
+			name:   "truncated SysEx is dropped",
+			chunks: [][]byte{longSysEx, {0x01, 0xF7, 0x90, 60, 100}},
+			want:   [][]byte{{0x90, 60, 100}},
+		},
+		{
+			name:   "stray End of Exclusive",
+			chunks: [][]byte{{0xF7, 0xC0, 5}},
+			want:   [][]byte{{0xC0, 5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parse(tt.chunks...)
+			if fmt.Sprintf("% X", got) != fmt.Sprintf("% X", tt.want) {
+				t.Errorf("got % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMidiParserKeepsEmittedMessages(t *testing.T) {
+	var p midiParser
+	var kept []byte
+	p.feed([]byte{0xF0, 0x01, 0xF7}, func(msg []byte) { kept = msg })
+	p.feed([]byte{0xF0, 0x02, 0x03, 0xF7}, func([]byte) {})
+	if !bytes.Equal(kept, []byte{0xF0, 0x01, 0xF7}) {
+		t.Errorf("emitted message changed to % X", kept)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	smfDivision = 960    // ticks per quarter note
+	smfTempo    = 500000 // microseconds per quarter note (120 BPM)
+
+	smfTicksPerSecond = smfDivision * 1e6 / smfTempo
+)
+
+// midiRecorder writes incoming MIDI messages to a type-0 Standard MIDI File.
+// Events are streamed to disk as they arrive; the track length is patched
+// in when the recorder is closed.
+type midiRecorder struct {
+	mu       sync.Mutex
+	f        *os.File
+	w        *bufio.Writer
+	elapsed  float64   // seconds since the first message
+	last     time.Time // when the previous message was recorded
+	lastTick int64
+	size     int64 // bytes written to the track chunk
+}
+
+func newMidiRecorder(path string) (*midiRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &midiRecorder{f: f, w: bufio.NewWriter(f)}
+
+	// Header: format 0, one track.
+	r.w.WriteString("MThd")
+	binary.Write(r.w, binary.BigEndian, uint32(6))
+	binary.Write(r.w, binary.BigEndian, [3]uint16{0, 1, smfDivision})
+	// The track length is unknown until the recording ends.
+	r.w.WriteString("MTrk")
+	binary.Write(r.w, binary.BigEndian, uint32(0))
+
+	tempo := uint32(smfTempo)
+	r.writeEvent(0, []byte{0xFF, 0x51, 0x03, byte(tempo >> 16), byte(tempo >> 8), byte(tempo)})
+	return r, nil
+}
+
+// record appends a message received deltaTime seconds after the previous
+// one, as reported by rtmidi. Real-time and system common messages are
+// skipped, but their timing still counts.
+func (r *midiRecorder) record(msg []byte, deltaTime float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.add(msg, deltaTime)
+}
+
+// recordNow appends a message that arrived just now, for inputs without
+// timestamps of their own.
+func (r *midiRecorder) recordNow(msg []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deltaTime := 0.0
+	if !r.last.IsZero() {
+		deltaTime = time.Since(r.last).Seconds()
+	}
+	r.add(msg, deltaTime)
+}
+
+// wrap returns play, also recording every message it's given. A nil
+// recorder returns play itself.
+func (r *midiRecorder) wrap(play func([]byte)) func([]byte) {
+	if r == nil {
+		return play
+	}
+	return func(msg []byte) {
+		r.recordNow(msg)
+		play(msg)
+	}
+}
+
+func (r *midiRecorder) add(msg []byte, deltaTime float64) {
+	if r.f == nil || len(msg) == 0 {
+		return
+	}
+
+	// An rtmidi delta counts from the previous message of its own port,
+	// while other inputs may have been recorded since.
+	now := time.Now()
+	if !r.last.IsZero() {
+		deltaTime = min(deltaTime, now.Sub(r.last).Seconds())
+	}
+	r.last = now
+	r.elapsed += deltaTime
+	status := msg[0]
+	if status < 0x80 || status > 0xF0 {
+		return
+	}
+
+	tick := int64(math.Round(r.elapsed * smfTicksPerSecond))
+	delta := tick - r.lastTick
+	r.lastTick = tick
+
+	if status == 0xF0 {
+		// F0 <length> <bytes after F0, including F7>
+		event := append([]byte{0xF0}, varLen(len(msg)-1)...)
+		r.writeEvent(delta, append(event, msg[1:]...))
+		return
+	}
+	r.writeEvent(delta, msg)
+}
+
+func (r *midiRecorder) writeEvent(delta int64, event []byte) {
+	n, _ := r.w.Write(varLen(int(delta)))
+	m, _ := r.w.Write(event)
+	r.size += int64(n + m)
+}
+
+// Close ends the track and finalizes the file.
+func (r *midiRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	f := r.f
+	r.f = nil
+
+	r.writeEvent(0, []byte{0xFF, 0x2F, 0x00})
+	if err := r.w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(r.size))
+	// The track length follows the 14-byte header and the "MTrk" tag.
+	if _, err := f.WriteAt(length[:], 14+4); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// varLen encodes a variable-length quantity.
+func varLen(value int) []byte {
+	buf := []byte{byte(value & 0x7F)}
+	for value >>= 7; value > 0; value >>= 7 {
+		buf = append([]byte{byte(value&0x7F) | 0x80}, buf...)
+	}
+	return buf
+}
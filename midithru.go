@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/mattrtaylor/go-rtmidi"
+)
+
+// messageKinds are the names used to filter MIDI messages by kind.
+var messageKinds = []string{"note", "poly-pressure", "cc", "program", "pressure", "pitch-bend", "sysex", "common", "realtime"}
+
+// messageKind names the kind of a MIDI message.
+func messageKind(msg []byte) string {
+	switch {
+	case msg[0] == 0xF0:
+		return "sysex"
+	case msg[0] >= 0xF8:
+		return "realtime"
+	case msg[0] > 0xF0:
+		return "common"
+	}
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90:
+		return "note"
+	case 0xA0:
+		return "poly-pressure"
+	case 0xB0:
+		return "cc"
+	case 0xC0:
+		return "program"
+	case 0xD0:
+		return "pressure"
+	default:
+		return "pitch-bend"
+	}
+}
+
+// parseMessageKinds parses a comma separated list of message kinds.
+func parseMessageKinds(s string) (map[string]bool, error) {
+	kinds := make(map[string]bool)
+	if s == "" {
+		return kinds, nil
+	}
+	for _, kind := range strings.Split(s, ",") {
+		kind = strings.TrimSpace(kind)
+		if !slices.Contains(messageKinds, kind) {
+			return nil, fmt.Errorf("unknown message kind %q (available: %s)", kind, strings.Join(messageKinds, ", "))
+		}
+		kinds[kind] = true
+	}
+	return kinds, nil
+}
+
+// midiThru echoes input messages to a MIDI output port, so the program can
+// sit between a controller and downstream hardware.
+type midiThru struct {
+	out    rtmidi.MIDIOut
+	drop   map[string]bool // message kinds that are not echoed
+	mapped bool            // echo messages after the controller profile
+}
+
+// send echoes a message unless its kind is filtered.
+func (t *midiThru) send(msg []byte) {
+	if len(msg) == 0 || t.drop[messageKind(msg)] {
+		return
+	}
+	if err := t.out.SendMessage(msg); err != nil {
+		slog.Warn("MIDI thru failed", "err", err)
+	}
+}
+
+// openMidiThru lists the MIDI output ports and opens the chosen one.
+func openMidiThru(port int) (*midiThru, error) {
+	out, err := rtmidi.NewMIDIOutDefault()
+	if err != nil {
+		return nil, err
+	}
+	portCount, err := out.PortCount()
+	if err != nil {
+		out.Close()
+		return nil, err
+	}
+
+	for i := 0; i < portCount; i++ {
+		name, err := out.PortName(i)
+		if err != nil {
+			out.Close()
+			return nil, err
+		}
+		slog.Info("MIDI output device", "port", i, "name", name)
+	}
+	if port >= portCount {
+		out.Close()
+		return nil, fmt.Errorf("invalid port index: %d", port)
+	}
+	if err := out.OpenPort(port, ""); err != nil {
+		out.Close()
+		return nil, err
+	}
+	return &midiThru{out: out}, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+const (
+	mpeManagerChannel   = 0
+	mpeManagerBendRange = 2  // semitones, the MPE default for the manager channel
+	percussionChannel   = 9  // meltysynth always treats channel 10 as drums
+	channelCount        = 16 // MIDI channels per port
+)
+
+// mpeZone implements the lower MPE zone. Channel 1 is the manager channel and
+// channels 2-16 are member channels. A controller plays each note on its own
+// member channel, so pitch bend, pressure and CC74 sent there only affect
+// that note.
+type mpeZone struct {
+	synthesizer *meltysynth.Synthesizer
+	bendRange   int32 // member channel pitch bend range in semitones
+
+	managerBend float64               // manager pitch bend in semitones
+	memberBend  [channelCount]float64 // member pitch bend in semitones
+}
+
+// newMpeZone prepares the synthesizer channels for MPE playing.
+func newMpeZone(synthesizer *meltysynth.Synthesizer, bendRange int32) *mpeZone {
+	z := &mpeZone{synthesizer: synthesizer, bendRange: bendRange}
+	for ch := int32(0); ch < channelCount; ch++ {
+		if ch == mpeManagerChannel {
+			continue
+		}
+		setPitchBendRange(synthesizer, ch, bendRange, 0)
+	}
+	// Channel 10 is a member channel like any other, so undo the drum bank.
+	selectBank(synthesizer, percussionChannel, 0)
+	return z
+}
+
+// handleMessage routes a channel message according to the MPE zone layout.
+func (z *mpeZone) handleMessage(msg []byte) {
+	channel := int32(msg[0] & 0x0F)
+	command := msg[0] & 0xF0
+	if channel == mpeManagerChannel {
+		z.handleManagerMessage(command, msg)
+		return
+	}
+
+	switch command {
+	case 0x90: // Note On
+		if len(msg) < 3 {
+			return
+		}
+		z.synthesizer.NoteOn(channel, int32(msg[1]), int32(msg[2]))
+	case 0x80: // Note Off
+		if len(msg) < 3 {
+			return
+		}
+		z.synthesizer.NoteOff(channel, int32(msg[1]))
+	case 0xE0: // Per-note pitch bend
+		if len(msg) < 3 {
+			return
+		}
+		z.memberBend[channel] = pitchBendSemitones(msg[1], msg[2], float64(z.bendRange))
+		z.updatePitchBend(channel)
+	case 0xD0: // Per-note pressure
+		if len(msg) < 2 {
+			return
+		}
+		z.setPressure(channel, int32(msg[1]))
+	case 0xB0: // Control Change
+		if len(msg) < 3 {
+			return
+		}
+		if msg[1] == 74 {
+			z.setTimbre(channel, int32(msg[2]))
+			return
+		}
+		z.controlChange(channel, int32(msg[1]), int32(msg[2]))
+	}
+}
+
+// handleManagerMessage applies manager channel messages to the whole zone.
+func (z *mpeZone) handleManagerMessage(command byte, msg []byte) {
+	switch command {
+	case 0x90: // Note On
+		if len(msg) < 3 {
+			return
+		}
+		z.synthesizer.NoteOn(mpeManagerChannel, int32(msg[1]), int32(msg[2]))
+	case 0x80: // Note Off
+		if len(msg) < 3 {
+			return
+		}
+		z.synthesizer.NoteOff(mpeManagerChannel, int32(msg[1]))
+	case 0xE0: // Zone-wide pitch bend, added on top of each member's own bend
+		if len(msg) < 3 {
+			return
+		}
+		z.managerBend = pitchBendSemitones(msg[1], msg[2], mpeManagerBendRange)
+		for ch := int32(0); ch < channelCount; ch++ {
+			z.updatePitchBend(ch)
+		}
+	case 0xD0: // Zone-wide pressure
+		if len(msg) < 2 {
+			return
+		}
+		for ch := int32(0); ch < channelCount; ch++ {
+			z.setPressure(ch, int32(msg[1]))
+		}
+	case 0xC0: // Program Change
+		if len(msg) < 2 {
+			return
+		}
+		for ch := int32(0); ch < channelCount; ch++ {
+			z.synthesizer.ProcessMidiMessage(ch, 0xC0, int32(msg[1]), 0)
+		}
+	case 0xB0: // Control Change
+		if len(msg) < 3 {
+			return
+		}
+		cc, value := int32(msg[1]), int32(msg[2])
+		switch cc {
+		case 0x06, 0x26, 0x64, 0x65:
+			// RPNs on the manager channel configure the manager itself;
+			// broadcasting them would clobber the member bend range.
+			z.controlChange(mpeManagerChannel, cc, value)
+		default:
+			for ch := int32(0); ch < channelCount; ch++ {
+				z.controlChange(ch, cc, value)
+			}
+		}
+	}
+}
+
+// controlChange forwards a controller to the synthesizer, keeping channel 10
+// melodic when a bank is selected.
+func (z *mpeZone) controlChange(channel, cc, value int32) {
+	if cc == 0x00 {
+		selectBank(z.synthesizer, channel, value)
+		return
+	}
+	z.synthesizer.ProcessMidiMessage(channel, 0xB0, cc, value)
+}
+
+// setPressure maps pressure to expression. Controllers send the initial
+// pressure before the Note On, usually as zero, which expressionFromPressure
+// keeps audible.
+func (z *mpeZone) setPressure(channel, pressure int32) {
+	z.synthesizer.ProcessMidiMessage(channel, 0xB0, 0x0B, expressionFromPressure(pressure))
+}
+
+// setTimbre maps CC74 to modulation depth, since meltysynth has no
+// controllable filter to map brightness to.
+func (z *mpeZone) setTimbre(channel, value int32) {
+	z.synthesizer.ProcessMidiMessage(channel, 0xB0, 0x01, value)
+}
+
+// updatePitchBend sends the combined manager and member bend of a channel.
+func (z *mpeZone) updatePitchBend(channel int32) {
+	bendRange := float64(z.bendRange)
+	if channel == mpeManagerChannel {
+		bendRange = mpeManagerBendRange
+	}
+	semitones := z.managerBend
+	if channel != mpeManagerChannel {
+		semitones += z.memberBend[channel]
+	}
+	value := int32(8192 + semitones/bendRange*8192)
+	value = max(0, min(16383, value))
+	z.synthesizer.ProcessMidiMessage(channel, 0xE0, value&0x7F, value>>7)
+}
+
+// pitchBendSemitones converts a 14-bit pitch bend message to semitones.
+func pitchBendSemitones(lsb, msb byte, bendRange float64) float64 {
+	value := int32(lsb) | int32(msb)<<7
+	return float64(value-8192) / 8192 * bendRange
+}
@@ -0,0 +1,173 @@
+package main
+
+import "slices"
+
+// mpeCollapser folds the lower zone of an MPE controller into one ordinary
+// channel, so MPE controllers can play GM presets without MPE mode. A
+// channel has a single pitch bend, so it follows the most recently played
+// note that is still held: sliding that note bends the channel, while
+// expression on older notes is ignored. Releasing the newest note hands the
+// channel over to the previous one.
+type mpeCollapser struct {
+	channel   byte  // channel the zone is folded into
+	bendRange int32 // member channel pitch bend range in semitones
+
+	managerBend    float64               // manager pitch bend in semitones
+	memberBend     [channelCount]float64 // member pitch bend in semitones
+	memberPressure [channelCount]byte
+	memberTimbre   [channelCount]byte
+	held           []collapsedNote // oldest first
+}
+
+// collapsedNote is a note held on a member channel.
+type collapsedNote struct {
+	member byte
+	key    byte
+}
+
+func newMpeCollapser(channel byte, bendRange int32) *mpeCollapser {
+	return &mpeCollapser{channel: channel, bendRange: bendRange}
+}
+
+// configure forgets held notes and returns the messages that set up the
+// target channel: its pitch bend range must match the members' so slides
+// keep their size.
+func (c *mpeCollapser) configure() [][]byte {
+	*c = mpeCollapser{channel: c.channel, bendRange: c.bendRange}
+	cc := 0xB0 | c.channel
+	return [][]byte{
+		{cc, 0x65, 0}, {cc, 0x64, 0},
+		{cc, 0x06, byte(c.bendRange)}, {cc, 0x26, 0},
+		{cc, 0x65, rpnNull}, {cc, 0x64, rpnNull},
+	}
+}
+
+// transform turns a channel message from the controller into the messages
+// to play on the target channel.
+func (c *mpeCollapser) transform(msg []byte) [][]byte {
+	channel := msg[0] & 0x0F
+	command := msg[0] & 0xF0
+	if len(msg) < 2 || (command != 0xC0 && command != 0xD0 && len(msg) < 3) {
+		return nil
+	}
+
+	if channel == mpeManagerChannel {
+		switch {
+		case command == 0xE0: // Zone-wide pitch bend
+			c.managerBend = pitchBendSemitones(msg[1], msg[2], mpeManagerBendRange)
+			return [][]byte{c.pitchBend()}
+		case command == 0xB0 && isParameterController(msg[1]):
+			// The controller configures its zone with RPNs; the target
+			// channel keeps the bend range set up by configure.
+			return nil
+		}
+		out := slices.Clone(msg)
+		out[0] = command | c.channel
+		return [][]byte{out}
+	}
+
+	switch command {
+	case 0x90: // Note On
+		if msg[2] == 0 {
+			return c.noteOff(channel, msg[1])
+		}
+		// The member's bend, pressure and timbre are sent before the
+		// Note On, so the note starts with them.
+		c.held = append(c.held, collapsedNote{member: channel, key: msg[1]})
+		return append(c.activeState(), []byte{0x90 | c.channel, msg[1], msg[2]})
+	case 0x80: // Note Off
+		return c.noteOff(channel, msg[1])
+	case 0xE0: // Per-note pitch bend
+		c.memberBend[channel] = pitchBendSemitones(msg[1], msg[2], float64(c.bendRange))
+		if c.isActive(channel) {
+			return [][]byte{c.pitchBend()}
+		}
+	case 0xD0: // Per-note pressure
+		c.memberPressure[channel] = msg[1]
+		if c.isActive(channel) {
+			return [][]byte{{0xD0 | c.channel, msg[1]}}
+		}
+	case 0xB0: // Control Change
+		switch {
+		case msg[1] == 74:
+			c.memberTimbre[channel] = msg[2]
+			if c.isActive(channel) {
+				return [][]byte{c.timbre()}
+			}
+		case !isParameterController(msg[1]):
+			return [][]byte{{0xB0 | c.channel, msg[1], msg[2]}}
+		}
+	}
+	return nil
+}
+
+// noteOff releases a note unless another member still holds the same key.
+func (c *mpeCollapser) noteOff(member, key byte) [][]byte {
+	wasActive := c.isActive(member)
+	i := slices.Index(c.held, collapsedNote{member: member, key: key})
+	if i < 0 {
+		return nil
+	}
+	c.held = slices.Delete(c.held, i, i+1)
+
+	var out [][]byte
+	if !slices.ContainsFunc(c.held, func(n collapsedNote) bool { return n.key == key }) {
+		out = append(out, []byte{0x80 | c.channel, key, 0})
+	}
+	if wasActive && !c.isActive(member) {
+		out = append(out, c.activeState()...)
+	}
+	return out
+}
+
+// isActive reports whether a member plays the note the channel follows.
+func (c *mpeCollapser) isActive(member byte) bool {
+	return len(c.held) > 0 && c.held[len(c.held)-1].member == member
+}
+
+// activeState returns the messages that make the channel follow the newest
+// held note.
+func (c *mpeCollapser) activeState() [][]byte {
+	out := [][]byte{c.pitchBend()}
+	if len(c.held) > 0 {
+		member := c.held[len(c.held)-1].member
+		out = append(out, []byte{0xD0 | c.channel, c.memberPressure[member]}, c.timbre())
+	}
+	return out
+}
+
+// pitchBend returns the pitch bend of the manager plus the active member.
+func (c *mpeCollapser) pitchBend() []byte {
+	semitones := c.managerBend
+	if len(c.held) > 0 {
+		semitones += c.memberBend[c.held[len(c.held)-1].member]
+	}
+	value := int32(8192 + semitones/float64(c.bendRange)*8192)
+	value = max(0, min(16383, value))
+	return []byte{0xE0 | c.channel, byte(value & 0x7F), byte(value >> 7)}
+}
+
+// timbre maps the active member's CC74 to modulation, like MPE mode does.
+func (c *mpeCollapser) timbre() []byte {
+	var value byte
+	if len(c.held) > 0 {
+		value = c.memberTimbre[c.held[len(c.held)-1].member]
+	}
+	return []byte{0xB0 | c.channel, 0x01, value}
+}
+
+// isParameterController reports whether cc selects or sets an RPN or NRPN.
+func isParameterController(cc byte) bool {
+	switch cc {
+	case 0x65, 0x64, 0x63, 0x62, 0x06, 0x26:
+		return true
+	}
+	return false
+}
+
+// configureCollapse sets up the channel an MPE zone is folded into.
+func (h *midiHandler) configureCollapse() {
+	for _, m := range h.collapse.configure() {
+		h.handleChannelMessage(m)
+	}
+}
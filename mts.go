@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+)
+
+// MIDI Tuning Standard sub-ID #2 values, following sub-ID #1 0x08.
+const (
+	mtsBulkDump          = 0x01
+	mtsNoteChange        = 0x02
+	mtsNoteChangeBank    = 0x07
+	mtsScaleOctave1Byte  = 0x08
+	mtsScaleOctave2Bytes = 0x09
+)
+
+// isMtsMessage reports whether a SysEx message belongs to the MIDI Tuning
+// Standard: F0 7E/7F <device> 08 <sub-ID #2> ...
+func isMtsMessage(msg []byte) bool {
+	return len(msg) >= 6 && (msg[1] == 0x7E || msg[1] == 0x7F) && msg[3] == 0x08
+}
+
+// applyMtsMessage updates table from an MTS SysEx message. Notes played
+// afterwards use the new tuning; tuning program selection is not supported,
+// so every dump or change applies to the active table.
+func applyMtsMessage(table *tuning, msg []byte) error {
+	// Drop the trailing F7 so the payload length checks below are exact.
+	if msg[len(msg)-1] == 0xF7 {
+		msg = msg[:len(msg)-1]
+	}
+
+	switch msg[4] {
+	case mtsBulkDump:
+		// 7E dev 08 01 <program> <name:16> <xx yy zz:128> <checksum>
+		const headerSize = 6 + 16
+		if len(msg) < headerSize+128*3 {
+			return fmt.Errorf("bulk tuning dump too short (%d bytes)", len(msg))
+		}
+		data := msg[headerSize:]
+		for key := range table {
+			if pitch, ok := mtsFrequency(data[key*3:]); ok {
+				table[key] = pitch
+			}
+		}
+	case mtsNoteChange:
+		// 7F dev 08 02 <program> <count> [<key> <xx yy zz>]
+		if len(msg) < 7 {
+			return fmt.Errorf("note tuning change too short (%d bytes)", len(msg))
+		}
+		return applyMtsNoteChanges(table, int(msg[6]), msg[7:])
+	case mtsNoteChangeBank:
+		// 7E/7F dev 08 07 <bank> <program> <count> [<key> <xx yy zz>]
+		if len(msg) < 8 {
+			return fmt.Errorf("note tuning change too short (%d bytes)", len(msg))
+		}
+		return applyMtsNoteChanges(table, int(msg[7]), msg[8:])
+	case mtsScaleOctave1Byte, mtsScaleOctave2Bytes:
+		// 7E/7F dev 08 08|09 <ff gg hh> <12 offsets>
+		size := 1
+		if msg[4] == mtsScaleOctave2Bytes {
+			size = 2
+		}
+		if len(msg) < 8+12*size {
+			return fmt.Errorf("scale/octave tuning too short (%d bytes)", len(msg))
+		}
+		// The channel mask is ignored; the table is shared by all channels.
+		var offsets [12]float64
+		for i := range offsets {
+			if size == 1 {
+				// 0x40 is equal temperament, 1 cent per step.
+				offsets[i] = float64(int(msg[8+i]) - 0x40)
+			} else {
+				// 0x2000 is equal temperament, ±100 cents over 14 bits.
+				value := int(msg[8+i*2])<<7 | int(msg[9+i*2])
+				offsets[i] = float64(value-0x2000) / 8192 * 100
+			}
+		}
+		for key := range table {
+			table[key] = float64(key) + offsets[key%12]/100
+		}
+	default:
+		return fmt.Errorf("unsupported MTS message 08 %02X", msg[4])
+	}
+	return nil
+}
+
+// applyMtsNoteChanges applies count [key xx yy zz] entries.
+func applyMtsNoteChanges(table *tuning, count int, data []byte) error {
+	if len(data) < count*4 {
+		return fmt.Errorf("note tuning change truncated (%d of %d entries)", len(data)/4, count)
+	}
+	for i := 0; i < count; i++ {
+		entry := data[i*4:]
+		if pitch, ok := mtsFrequency(entry[1:]); ok {
+			table[entry[0]&0x7F] = pitch
+		}
+	}
+	return nil
+}
+
+// mtsFrequency decodes an MTS frequency word: a semitone followed by a 14-bit
+// fraction of a semitone. 7F 7F 7F means "no change".
+func mtsFrequency(data []byte) (float64, bool) {
+	if data[0] == 0x7F && data[1] == 0x7F && data[2] == 0x7F {
+		return 0, false
+	}
+	fraction := int(data[1])<<7 | int(data[2])
+	return float64(data[0]) + float64(fraction)/16384, true
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPeakTarget     = -1.0  // dBFS
+	defaultLoudnessTarget = -16.0 // LUFS
+	// loudnessCeiling is the highest peak, in dBFS, that loudness
+	// normalization raises a recording to, so that it doesn't clip.
+	loudnessCeiling = -1.0
+)
+
+// normalization is how recordings are brought to a consistent level:
+// their sample peak or their integrated loudness (EBU R 128) set to a
+// target.
+type normalization struct {
+	mode   string  // off, peak or lufs
+	target float64 // dBFS for peak, LUFS for lufs
+}
+
+// parseNormalization parses -record-normalize: off, or peak or lufs with an
+// optional target after a colon, e.g. "lufs:-14".
+func parseNormalization(s string) (normalization, error) {
+	mode, target, hasTarget := strings.Cut(s, ":")
+	n := normalization{mode: mode}
+	switch mode {
+	case "off":
+		if hasTarget {
+			return n, fmt.Errorf("no target with off")
+		}
+		return n, nil
+	case "peak":
+		n.target = defaultPeakTarget
+	case "lufs":
+		n.target = defaultLoudnessTarget
+	default:
+		return n, fmt.Errorf("unknown normalization %q (want off, peak or lufs)", mode)
+	}
+	if hasTarget {
+		t, err := strconv.ParseFloat(target, 64)
+		if err != nil || t > 0 || t < -70 {
+			return n, fmt.Errorf("invalid target %q", target)
+		}
+		n.target = t
+	}
+	return n, nil
+}
+
+// gain returns the linear gain that normalizes a recording with the given
+// peak and loudness.
+func (n normalization) gain(peak, loudness float64) float64 {
+	if peak == 0 {
+		return 1 // silence stays silence
+	}
+	switch n.mode {
+	case "peak":
+		return dbToGain(n.target) / peak
+	case "lufs":
+		if math.IsInf(loudness, -1) {
+			return 1
+		}
+		return min(dbToGain(n.target-loudness), dbToGain(loudnessCeiling)/peak)
+	}
+	return 1
+}
+
+// loudnessMeter measures the sample peak and the integrated loudness of
+// stereo audio as in ITU-R BS.1770: K-weighted, in gated 400ms blocks
+// overlapping by 75%.
+type loudnessMeter struct {
+	peak        float64
+	shelf       *biquad
+	highPass    *biquad
+	left, right []float32 // K-weighted samples of a block
+	stepFrames  int       // frames in 100ms, a quarter of a block
+	frames      int       // frames in the current step
+	energy      float64   // weighted energy of the current step
+	steps       []float64 // mean square of every finished step
+}
+
+func newLoudnessMeter(sampleRate int) *loudnessMeter {
+	rate := float64(sampleRate)
+	// The BS.1770 filters, derived for any sample rate.
+	k := math.Tan(math.Pi * 1681.974450955533 / rate)
+	q := 0.7071752369554196
+	vh := math.Pow(10, 3.999843853973347/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := &biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	k = math.Tan(math.Pi * 38.13547087602444 / rate)
+	q = 0.5003270373238773
+	a0 = 1 + k/q + k*k
+	highPass := &biquad{b0: 1, b1: -2, b2: 1, a1: 2 * (k*k - 1) / a0, a2: (1 - k/q + k*k) / a0}
+	return &loudnessMeter{
+		shelf:      shelf,
+		highPass:   highPass,
+		stepFrames: max(1, sampleRate/10),
+	}
+}
+
+// process measures interleaved stereo samples.
+func (m *loudnessMeter) process(samples []float32) {
+	m.left, m.right = m.left[:0], m.right[:0]
+	for i := 0; i+1 < len(samples); i += 2 {
+		m.peak = max(m.peak, math.Abs(float64(samples[i])), math.Abs(float64(samples[i+1])))
+		m.left = append(m.left, samples[i])
+		m.right = append(m.right, samples[i+1])
+	}
+	for ch, weighted := range [][]float32{m.left, m.right} {
+		m.shelf.process(ch, weighted)
+		m.highPass.process(ch, weighted)
+	}
+	for i := range m.left {
+		l, r := float64(m.left[i]), float64(m.right[i])
+		m.energy += l*l + r*r
+		if m.frames++; m.frames == m.stepFrames {
+			m.steps = append(m.steps, m.energy/float64(m.stepFrames))
+			m.frames, m.energy = 0, 0
+		}
+	}
+}
+
+// loudness returns the integrated loudness in LUFS, or -Inf if the audio
+// is too short or too quiet to measure.
+func (m *loudnessMeter) loudness() float64 {
+	var blocks []float64
+	for i := 3; i < len(m.steps); i++ {
+		blocks = append(blocks, (m.steps[i-3]+m.steps[i-2]+m.steps[i-1]+m.steps[i])/4)
+	}
+	// Blocks below -70 LUFS are ignored, then those 10 LU below the
+	// loudness of the rest.
+	gated := func(threshold float64) float64 {
+		var sum float64
+		n := 0
+		for _, b := range blocks {
+			if lufs(b) > threshold {
+				sum += b
+				n++
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+	absolute := gated(-70)
+	if absolute == 0 {
+		return math.Inf(-1)
+	}
+	return lufs(gated(lufs(absolute) - 10))
+}
+
+// lufs converts a K-weighted mean square to LUFS.
+func lufs(meanSquare float64) float64 {
+	return -0.691 + 10*math.Log10(meanSquare)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// noteRangeConfig limits the keys an input port responds to, so pads,
+// faders sending notes or keys outside an instrument's range can be
+// ignored. Each port has its own ranges; a channel with several ranges
+// takes keys in any of them.
+type noteRangeConfig struct {
+	Input int        `json:"input"` // channel 1-16 after the port's channel map, 0 for all
+	Low   noteNumber `json:"low"`   // lowest key, default 0
+	High  noteNumber `json:"high"`  // highest key, default 127
+}
+
+func (r *noteRangeConfig) UnmarshalJSON(data []byte) error {
+	type plain noteRangeConfig
+	p := plain{High: 127}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	*r = noteRangeConfig(p)
+	return nil
+}
+
+func (r *noteRangeConfig) validate() error {
+	if r.Input < 0 || r.Input > channelCount {
+		return fmt.Errorf("input channel %d out of range 0-16", r.Input)
+	}
+	if r.Low > r.High {
+		return fmt.Errorf("low key %d is above high key %d", r.Low, r.High)
+	}
+	return nil
+}
+
+// inNoteRange reports whether a message passes the note ranges of its
+// input. Only note and polyphonic key pressure messages are filtered.
+func inNoteRange(ranges []noteRangeConfig, msg []byte) bool {
+	if len(ranges) == 0 || len(msg) < 2 {
+		return true
+	}
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90, 0xA0:
+	default:
+		return true
+	}
+	channel := int(msg[0]&0x0F) + 1
+	limited := false
+	for _, r := range ranges {
+		if r.Input != 0 && r.Input != channel {
+			continue
+		}
+		if msg[1] >= byte(r.Low) && msg[1] <= byte(r.High) {
+			return true
+		}
+		limited = true
+	}
+	return !limited
+}
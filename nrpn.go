@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// nrpnTarget is what an NRPN is mapped to: a controller on the same channel
+// or the master volume.
+type nrpnTarget struct {
+	cc           int32
+	masterVolume bool
+}
+
+func (t nrpnTarget) String() string {
+	if t.masterVolume {
+		return "master-volume"
+	}
+	return fmt.Sprintf("cc%d", t.cc)
+}
+
+// nrpnMap maps NRPN numbers (MSB<<7 | LSB) to targets.
+type nrpnMap map[int32]nrpnTarget
+
+// parseNrpnMap parses a comma separated list of "msb:lsb=target" entries,
+// where target is "ccN" or "master-volume", e.g. "1:32=cc1,0:7=master-volume".
+func parseNrpnMap(s string) (nrpnMap, error) {
+	m := make(nrpnMap)
+	if s == "" {
+		return m, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		number, target, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid NRPN mapping %q", entry)
+		}
+		msbText, lsbText, ok := strings.Cut(number, ":")
+		msb, err1 := strconv.Atoi(msbText)
+		lsb, err2 := strconv.Atoi(lsbText)
+		if !ok || err1 != nil || err2 != nil || msb < 0 || msb > 127 || lsb < 0 || lsb > 127 {
+			return nil, fmt.Errorf("invalid NRPN number %q", number)
+		}
+
+		var t nrpnTarget
+		switch {
+		case target == "master-volume":
+			t.masterVolume = true
+		case strings.HasPrefix(target, "cc"):
+			cc, err := strconv.Atoi(target[2:])
+			if err != nil || cc < 0 || cc > 119 {
+				return nil, fmt.Errorf("invalid NRPN target %q", target)
+			}
+			switch cc {
+			case 0x06, 0x26, 0x62, 0x63, 0x64, 0x65:
+				// These would select or enter parameters themselves.
+				return nil, fmt.Errorf("NRPN target %q is a parameter controller", target)
+			}
+			t.cc = int32(cc)
+		default:
+			return nil, fmt.Errorf("invalid NRPN target %q", target)
+		}
+		m[int32(msb)<<7|int32(lsb)] = t
+	}
+	return m, nil
+}
+
+// nonRegisteredParameter handles data entry for the selected NRPN. Mapped
+// NRPNs are applied to their target, controllers as if they came from the
+// input; others are logged once each at debug level, so editors' traffic
+// is visible when building a mapping.
+func (h *midiHandler) nonRegisteredParameter(channel, cc, value int32) {
+	state := &h.rpn[channel]
+	number := state.msb<<7 | state.lsb
+	switch cc {
+	case 0x06: // Data Entry MSB
+		state.data = value << 7
+	case 0x26: // Data Entry LSB
+		state.data = state.data&^0x7F | value
+	}
+
+	target, ok := h.nrpn[number]
+	if !ok {
+		if !h.nrpnLogged[number] {
+			h.nrpnLogged[number] = true
+			slog.Debug("Unmapped NRPN", "channel", channel+1, "nrpn", fmt.Sprintf("%d:%d", state.msb, state.lsb), "value", state.data)
+		}
+		return
+	}
+	switch {
+	case target.masterVolume:
+		h.synthesizer.MasterVolume = float32(state.data) / 16383
+	case cc == 0x06:
+		// Controllers only take the coarse value.
+		h.controlChange(channel, target.cc, value)
+	}
+	slog.Debug("NRPN", "channel", channel+1, "nrpn", fmt.Sprintf("%d:%d", state.msb, state.lsb), "value", state.data, "target", target)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// describePipeWireNode names the audio stream for PipeWire, so it shows up
+// as an instrument in graph tools like qpwgraph or Helvum instead of a
+// generic ALSA playback stream. oto plays through ALSA, whose PipeWire
+// plugin creates the stream and reads these properties and the latency
+// hint from the environment. Variables the user has set are left alone.
+func describePipeWireNode(name string, sampleRate, blockFrames int) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("PipeWire is only supported on Linux")
+	}
+	if _, ok := os.LookupEnv("PIPEWIRE_PROPS"); !ok {
+		props, err := json.Marshal(map[string]string{
+			"node.name":        name,
+			"node.description": name + " synthesizer",
+			"application.name": name,
+			"media.type":       "Audio",
+			"media.category":   "Playback",
+			"media.role":       "Production",
+		})
+		if err != nil {
+			return err
+		}
+		os.Setenv("PIPEWIRE_PROPS", string(props))
+	}
+	if _, ok := os.LookupEnv("PIPEWIRE_LATENCY"); !ok {
+		// Ask for the renderer's block size as the quantum.
+		os.Setenv("PIPEWIRE_LATENCY", fmt.Sprintf("%d/%d", blockFrames, sampleRate))
+	}
+	return nil
+}
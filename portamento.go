@@ -0,0 +1,55 @@
+package main
+
+import "time"
+
+// maxPortamentoTime is the glide time at CC5 127.
+const maxPortamentoTime = 4 * time.Second
+
+// portamento is the portamento state of a channel. The synthesizer has no
+// portamento, so a glide retunes the whole channel from the previous note
+// towards the new one, which suits the mono and legato channels it is
+// used on.
+type portamento struct {
+	on      bool  // CC65
+	time    int32 // CC5
+	last    byte  // key of the previous note
+	played  bool  // whether last is set
+	gliding bool
+	from    float64 // semitones from the target at the start of the glide
+	offset  float64 // current distance from the target
+	start   time.Time
+	length  time.Duration
+}
+
+// portamentoLength maps CC5 to a glide time, finer at short times.
+func portamentoLength(value int32) time.Duration {
+	x := float64(value) / 127
+	return time.Duration(x * x * float64(maxPortamentoTime))
+}
+
+// glideTo starts a glide from the previous note of a channel to key, if
+// portamento is on.
+func (h *midiHandler) glideTo(channel int32, key byte) {
+	p := &h.portamento[channel]
+	last, played := p.last, p.played
+	p.last, p.played = key, true
+	if !p.on || !played || p.time == 0 || h.retuned(channel) {
+		if p.gliding {
+			p.gliding, p.offset = false, 0
+			h.updatePitch(channel)
+		}
+		return
+	}
+	// Start from where the pitch is now, mid-glide or not.
+	current := float64(last)
+	if p.gliding {
+		current += p.offset
+	}
+	p.from = current - float64(key)
+	p.offset = p.from
+	p.start = time.Now()
+	p.length = portamentoLength(p.time)
+	p.gliding = true
+	h.updatePitch(channel)
+	h.startPitchModulation()
+}
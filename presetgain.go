@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+)
+
+// presetGains are gain offsets in dB for presets of a sound font, to level
+// unbalanced presets without editing it.
+type presetGains map[presetRef]float64
+
+// loadPresetGains reads a gain table in the format analyze -out writes:
+// [{"bank": 0, "program": 0, "gainDb": -3.5}, ...].
+func loadPresetGains(path string) (presetGains, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var table []gainTrim
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	gains := make(presetGains, len(table))
+	for i, t := range table {
+		if t.Bank < 0 || t.Bank > 128 || t.Program < 0 || t.Program > 127 {
+			return nil, fmt.Errorf("%s: entry %d: no preset %d:%d", path, i+1, t.Bank, t.Program)
+		}
+		if math.Abs(t.GainDb) > maxGainTrimDb {
+			return nil, fmt.Errorf("%s: entry %d: gain %.1f dB out of range ±%d", path, i+1, t.GainDb, maxGainTrimDb)
+		}
+		gains[presetRef{t.Bank, t.Program}] = t.GainDb
+	}
+	return gains, nil
+}
+
+// presetVelocity applies the gain of the preset a channel plays to the
+// velocity of a note. meltysynth's note gain is the square of the velocity,
+// so the velocity scales by half the gain in decibels. Velocities can't go
+// above 127, which limits how much loud notes can be boosted.
+func (h *midiHandler) presetVelocity(channel int32, velocity byte) byte {
+	preset, _ := h.presets.resolve(h.banks[channel], h.mix[channel].program, h.fallback)
+	if preset == nil {
+		return velocity
+	}
+	gain, ok := h.presetGains[presetRef{preset.BankNumber, preset.PatchNumber}]
+	if !ok {
+		return velocity
+	}
+	return byte(max(1, min(127, math.Round(float64(velocity)*math.Pow(10, gain/40)))))
+}
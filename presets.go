@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// presetIndex finds the presets of a sound font by bank and program.
+type presetIndex map[int32]*meltysynth.Preset
+
+func newPresetIndex(soundFont *meltysynth.SoundFont) presetIndex {
+	idx := make(presetIndex, len(soundFont.Presets))
+	for _, preset := range soundFont.Presets {
+		idx[presetID(preset.BankNumber, preset.PatchNumber)] = preset
+	}
+	return idx
+}
+
+// presetID packs a bank and program the same way meltysynth does.
+func presetID(bank, program int32) int32 {
+	return bank<<16 | program
+}
+
+func (idx presetIndex) lookup(bank, program int32) *meltysynth.Preset {
+	return idx[presetID(bank, program)]
+}
+
+// resolve returns the preset to use for a program change. If the requested
+// bank and program are missing, the configured fallback is used, then the
+// same program in bank 0 (or the standard kit for drum banks), then the
+// closest program in bank 0.
+func (idx presetIndex) resolve(bank, program int32, fallback *presetRef) (*meltysynth.Preset, bool) {
+	if preset := idx.lookup(bank, program); preset != nil {
+		return preset, true
+	}
+	if fallback != nil {
+		if preset := idx.lookup(fallback.bank, fallback.program); preset != nil {
+			return preset, false
+		}
+	}
+	if bank >= 128 {
+		if preset := idx.lookup(128, 0); preset != nil {
+			return preset, false
+		}
+	}
+	if preset := idx.lookup(0, program); preset != nil {
+		return preset, false
+	}
+
+	var nearest *meltysynth.Preset
+	var nearestDistance int32
+	for _, preset := range idx {
+		if preset.BankNumber != 0 {
+			continue
+		}
+		distance := abs(preset.PatchNumber - program)
+		if nearest == nil || distance < nearestDistance ||
+			(distance == nearestDistance && preset.PatchNumber < nearest.PatchNumber) {
+			nearest, nearestDistance = preset, distance
+		}
+	}
+	return nearest, false
+}
+
+// selectBank sets the bank of a channel. meltysynth adds 128 to the bank of
+// the percussion channel, which is compensated here so that any channel can
+// use any bank.
+func selectBank(synthesizer *meltysynth.Synthesizer, channel, bank int32) {
+	if channel == percussionChannel {
+		bank -= 128
+	}
+	synthesizer.ProcessMidiMessage(channel, 0xB0, 0x00, bank)
+}
+
+// presetRef is a bank and program given on the command line.
+type presetRef struct {
+	bank    int32
+	program int32
+}
+
+// parsePresetRef parses "program" or "bank:program".
+func parsePresetRef(s string) (*presetRef, error) {
+	bankText, programText := "0", s
+	if i := strings.Index(s, ":"); i >= 0 {
+		bankText, programText = s[:i], s[i+1:]
+	}
+	bank, err := strconv.Atoi(bankText)
+	if err != nil || bank < 0 || bank > 128 {
+		return nil, fmt.Errorf("invalid bank %q", bankText)
+	}
+	program, err := strconv.Atoi(programText)
+	if err != nil || program < 0 || program > 127 {
+		return nil, fmt.Errorf("invalid program %q", programText)
+	}
+	return &presetRef{bank: int32(bank), program: int32(program)}, nil
+}
+
+func abs(x int32) int32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// qwertyKeys maps computer keys to semitones above the base note, laid out
+// like a piano: the home row plays the white keys and the row above it the
+// black keys.
+var qwertyKeys = map[byte]int{
+	'a': 0, 'w': 1, 's': 2, 'e': 3, 'd': 4, 'f': 5, 't': 6, 'g': 7,
+	'y': 8, 'h': 9, 'u': 10, 'j': 11, 'k': 12, 'o': 13, 'l': 14, 'p': 15,
+	';': 16, '\'': 17,
+}
+
+// qwertyNoteLength is how long a key press sounds. Terminals don't report
+// key releases, so notes end by themselves; holding a key down keeps the
+// note going through auto-repeat.
+const qwertyNoteLength = 400 * time.Millisecond
+
+// qwertyInput plays notes from the computer keyboard, for machines without
+// MIDI hardware.
+type qwertyInput struct {
+	send     func(msg []byte)
+	mu       sync.Mutex
+	octave   int // octave of the base note, C4 = 60
+	velocity int
+	sounding map[byte]*time.Timer // release timers by key
+}
+
+// startQwertyInput switches the terminal to unbuffered input and plays keys
+// in the background. The returned function restores the terminal.
+func startQwertyInput(send func(msg []byte)) (func(), error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("no terminal: %w", err)
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, err
+	}
+	q := &qwertyInput{send: send, octave: 4, velocity: 100, sounding: make(map[byte]*time.Timer)}
+	slog.Info("Computer keyboard: A-' white keys, W E T Y U O P black keys, Z/X octave down/up, C/V velocity down/up")
+	go q.run()
+	return func() { stty(strings.TrimSpace(state)) }, nil
+}
+
+// stty runs stty on the terminal of standard input.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+func (q *qwertyInput) run() {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		q.press(c)
+	}
+}
+
+// press handles one key press.
+func (q *qwertyInput) press(c byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch c {
+	case 'z':
+		q.octave = max(-1, q.octave-1)
+		slog.Info("Octave", "base", fmt.Sprintf("C%d", q.octave))
+	case 'x':
+		q.octave = min(8, q.octave+1)
+		slog.Info("Octave", "base", fmt.Sprintf("C%d", q.octave))
+	case 'c':
+		q.velocity = max(1, q.velocity-20)
+		slog.Info("Velocity", "velocity", q.velocity)
+	case 'v':
+		q.velocity = min(127, q.velocity+20)
+		slog.Info("Velocity", "velocity", q.velocity)
+	default:
+		semitone, ok := qwertyKeys[c]
+		note := (q.octave+1)*12 + semitone
+		if !ok || note > 127 {
+			return
+		}
+		key := byte(note)
+		if timer, ok := q.sounding[key]; ok {
+			// Auto-repeat of a held key: keep the note sounding.
+			timer.Reset(qwertyNoteLength)
+			return
+		}
+		q.send([]byte{0x90, key, byte(q.velocity)})
+		q.sounding[key] = time.AfterFunc(qwertyNoteLength, func() { q.release(key) })
+	}
+}
+
+func (q *qwertyInput) release(key byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.sounding, key)
+	q.send([]byte{0x80, key, 0})
+}
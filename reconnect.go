@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log/slog"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mattrtaylor/go-rtmidi"
+)
+
+// alsaPortAddress is the client:port suffix ALSA adds to port names. The
+// client number changes when a device is plugged in again, so it's ignored
+// when looking for the device.
+var alsaPortAddress = regexp.MustCompile(`\s+\d+:\d+$`)
+
+// alsaSeqPortName is the port other sequencer clients connect to with
+// -alsa-seq.
+const alsaSeqPortName = "MIDI In"
+
+// newMidiIn creates the input reopened ports are read through.
+var newMidiIn = rtmidi.NewMIDIInDefault
+
+// midiInput is the open MIDI input port. A watchdog notices when its device
+// goes away, releases the notes it left sounding and reopens the port when
+// the device returns.
+type midiInput struct {
+	mu       sync.Mutex
+	in       rtmidi.MIDIIn // nil while the device is missing
+	callback func(rtmidi.MIDIIn, []byte, float64)
+	name     string // device of the open port
+	open     bool   // false while the device is missing
+	handler  *midiHandler
+}
+
+// setCallback sets the function incoming data is passed to, also for the
+// ports opened later.
+func (m *midiInput) setCallback(callback func(rtmidi.MIDIIn, []byte, float64)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.callback = callback
+	if m.in == nil {
+		return nil
+	}
+	return m.in.SetCallback(callback)
+}
+
+// reopen opens port on a new rtmidi input and closes the old one. Closing
+// frees an rtmidi input, so it can't open a port again. The caller holds
+// m.mu.
+func (m *midiInput) reopen(port int) error {
+	in, err := newMidiIn()
+	if err != nil {
+		return err
+	}
+	// Receive SysEx (tuning dumps) and timing (clock sync), but keep ignoring active sensing
+	if err := in.IgnoreTypes(false, false, true); err != nil {
+		in.Close()
+		return err
+	}
+	if m.callback != nil {
+		if err := in.SetCallback(m.callback); err != nil {
+			in.Close()
+			return err
+		}
+	}
+	if err := in.OpenPort(port, ""); err != nil {
+		in.Close()
+		return err
+	}
+	old := m.in
+	m.in = in
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// Close closes the port.
+func (m *midiInput) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.in == nil {
+		return nil
+	}
+	err := m.in.Close()
+	m.in = nil
+	return err
+}
+
+// openPort switches to another port of names, keeping the callback.
+func (m *midiInput) openPort(port int, names []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.reopen(port); err != nil {
+		return err
+	}
+	m.name, m.open = portDevice(names[port]), true
+	return nil
+}
+
+// watch checks for the device at every interval until the program exits.
+func (m *midiInput) watch(interval time.Duration) {
+	probe, err := rtmidi.NewMIDIInDefault()
+	if err != nil {
+		slog.Warn("MIDI reconnect disabled", "err", err)
+		return
+	}
+	defer probe.Close()
+	for range time.Tick(interval) {
+		names, err := midiInputNames(probe)
+		if err != nil {
+			continue
+		}
+		m.check(names)
+	}
+}
+
+// check closes or reopens the port depending on whether its device is in
+// names.
+func (m *midiInput) check(names []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	port := slices.IndexFunc(names, func(name string) bool { return portDevice(name) == m.name })
+	switch {
+	case m.open && port < 0:
+		slog.Warn("MIDI input device disconnected", "name", m.name)
+		m.in.Close()
+		m.in = nil
+		m.open = false
+		m.handler.releaseAll()
+	case !m.open && port >= 0:
+		if err := m.reopen(port); err != nil {
+			slog.Warn("Failed to reopen MIDI port", "name", m.name, "err", err)
+			return
+		}
+		m.open = true
+		slog.Info("MIDI input device reconnected", "port", port, "name", names[port])
+	}
+}
+
+// portDevice strips the parts of a port name that change when the device
+// is plugged in again.
+func portDevice(port string) string {
+	return alsaPortAddress.ReplaceAllString(port, "")
+}
+
+// releaseAll lets go of every note and sustain pedal, as a device that
+// went away can't send their Note Off or pedal release anymore.
+func (h *midiHandler) releaseAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range int32(channelCount) {
+		h.synthesizer.ProcessMidiMessage(ch, 0xB0, 0x40, 0)
+	}
+	h.synthesizer.NoteOffAll(false)
+	if h.stuck != nil {
+		h.stuck.clear(-1)
+	}
+	if h.humanize != nil {
+		h.humanize.clear()
+	}
+	if h.arp != nil {
+		h.arp.held = nil
+		h.arp.pressed = 0
+	}
+}
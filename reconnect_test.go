@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+	"github.com/mattrtaylor/go-rtmidi"
+)
+
+// fakeMidiIn is an rtmidi input that delivers messages when told to.
+type fakeMidiIn struct {
+	rtmidi.MIDIIn
+	port     int
+	callback func(rtmidi.MIDIIn, []byte, float64)
+	closed   bool
+}
+
+func (f *fakeMidiIn) OpenPort(port int, name string) error {
+	if f.closed {
+		panic("port opened on a closed input")
+	}
+	f.port = port
+	return nil
+}
+
+func (f *fakeMidiIn) IgnoreTypes(midiSysex, midiTime, midiSense bool) error { return nil }
+
+func (f *fakeMidiIn) SetCallback(callback func(rtmidi.MIDIIn, []byte, float64)) error {
+	f.callback = callback
+	return nil
+}
+
+func (f *fakeMidiIn) Close() error {
+	if f.closed {
+		panic("input closed twice")
+	}
+	f.closed = true
+	f.callback = nil
+	return nil
+}
+
+// deliver passes msg to the callback, as rtmidi does for incoming data.
+func (f *fakeMidiIn) deliver(msg []byte) {
+	if f.callback != nil && !f.closed {
+		f.callback(f, msg, 0)
+	}
+}
+
+func TestMidiInputReconnectDelivers(t *testing.T) {
+	var created []*fakeMidiIn
+	defer func(saved func() (rtmidi.MIDIIn, error)) { newMidiIn = saved }(newMidiIn)
+	newMidiIn = func() (rtmidi.MIDIIn, error) {
+		f := &fakeMidiIn{}
+		created = append(created, f)
+		return f, nil
+	}
+
+	soundFont, err := fallbackSoundFont()
+	if err != nil {
+		t.Fatal(err)
+	}
+	synthesizer, err := meltysynth.NewSynthesizer(soundFont, meltysynth.NewSynthesizerSettings(44100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := &fakeMidiIn{}
+	m := &midiInput{in: first, name: "Keys", open: true, handler: newMidiHandler(synthesizer)}
+	var received int
+	if err := m.setCallback(func(_ rtmidi.MIDIIn, msg []byte, _ float64) { received++ }); err != nil {
+		t.Fatal(err)
+	}
+	first.deliver([]byte{0x90, 60, 100})
+
+	// The device goes away and comes back on another port.
+	m.check([]string{"Pads"})
+	if m.open || !first.closed {
+		t.Fatalf("port left open after its device went away")
+	}
+	m.check([]string{"Pads", "Keys"})
+	if !m.open || len(created) != 1 || m.in != created[0] {
+		t.Fatalf("port not reopened on a new input")
+	}
+	if created[0].port != 1 {
+		t.Errorf("reopened port %d, want 1", created[0].port)
+	}
+	created[0].deliver([]byte{0x80, 60, 0})
+	if received != 2 {
+		t.Errorf("received %d messages, want 2", received)
+	}
+	m.Close()
+}
+
+func TestMidiInputSwitchPortDelivers(t *testing.T) {
+	var created []*fakeMidiIn
+	defer func(saved func() (rtmidi.MIDIIn, error)) { newMidiIn = saved }(newMidiIn)
+	newMidiIn = func() (rtmidi.MIDIIn, error) {
+		f := &fakeMidiIn{}
+		created = append(created, f)
+		return f, nil
+	}
+
+	m := &midiInput{in: &fakeMidiIn{}}
+	var received int
+	if err := m.setCallback(func(_ rtmidi.MIDIIn, msg []byte, _ float64) { received++ }); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"Keys", "Pads"}
+	for _, port := range []int{0, 1} {
+		if err := m.openPort(port, names); err != nil {
+			t.Fatal(err)
+		}
+		created[port].deliver([]byte{0x90, 60, 100})
+	}
+	if received != 2 {
+		t.Errorf("received %d messages, want 2", received)
+	}
+	if !created[0].closed || m.in != created[1] || m.name != "Pads" {
+		t.Errorf("switched to %q, old input closed: %v", m.name, created[0].closed)
+	}
+	m.Close()
+}
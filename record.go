@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mewkiz/flac"
+	"github.com/mewkiz/flac/frame"
+	"github.com/mewkiz/flac/meta"
+)
+
+// flacBlockFrames is the block size of recorded FLAC frames, the one the
+// reference encoder uses.
+const flacBlockFrames = 4096
+
+// audioFile is a 16-bit stereo recording being written.
+type audioFile interface {
+	write(samples []int16) error // interleaved stereo
+	Close() error
+}
+
+// audioRecorder records the output to a WAV or FLAC file, chosen by the
+// file extension. It measures the recording as it goes; to normalize it,
+// the audio is kept as floats in a temporary file until the recording
+// ends and its level is known.
+type audioRecorder struct {
+	taps      *audioTaps
+	blocks    chan []float32
+	file      audioFile
+	dither    *ditherer
+	meter     *loudnessMeter
+	normalize normalization
+	raw       *os.File // unnormalized audio, nil unless normalizing
+	done      chan error
+
+	gain float64 // applied by normalization, known once closed
+}
+
+func newAudioRecorder(path string, taps *audioTaps, sampleRate int, dither *ditherer, normalize normalization) (*audioRecorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	var file audioFile
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".wav":
+		file, err = newWavFile(f, sampleRate)
+	case ".flac":
+		file, err = newFlacFile(f, sampleRate)
+	default:
+		err = fmt.Errorf("unsupported recording format %q (use .wav or .flac)", ext)
+	}
+	var raw *os.File
+	if err == nil && normalize.mode != "off" {
+		raw, err = os.CreateTemp(filepath.Dir(path), ".recording-*.f32")
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+
+	// A recording must not lose audio, so allow for a few seconds of disk
+	// stalls.
+	r := &audioRecorder{
+		taps:      taps,
+		blocks:    taps.add(1024),
+		file:      file,
+		dither:    dither,
+		meter:     newLoudnessMeter(sampleRate),
+		normalize: normalize,
+		raw:       raw,
+		done:      make(chan error, 1),
+		gain:      1,
+	}
+	go r.run()
+	return r, nil
+}
+
+func (r *audioRecorder) run() {
+	var samples []int16
+	var raw *bufio.Writer
+	if r.raw != nil {
+		raw = bufio.NewWriter(r.raw)
+	}
+	var err error
+	for block := range r.blocks {
+		if err != nil {
+			continue // keep draining until the recording is closed
+		}
+		r.meter.process(block)
+		if raw != nil {
+			err = binary.Write(raw, binary.LittleEndian, block)
+		} else {
+			samples = r.dither.int16s(samples[:0], block)
+			err = r.file.write(samples)
+		}
+		if err != nil {
+			slog.Error("Failed to write recording", "err", err)
+		}
+	}
+	if raw != nil {
+		if err == nil {
+			err = raw.Flush()
+		}
+		if err == nil {
+			err = r.writeNormalized()
+		}
+		name := r.raw.Name()
+		err = errors.Join(err, r.raw.Close(), os.Remove(name))
+	}
+	r.done <- errors.Join(err, r.file.Close())
+}
+
+// writeNormalized writes the recorded audio to the file with the gain
+// that normalizes it.
+func (r *audioRecorder) writeNormalized() error {
+	r.gain = r.normalize.gain(r.meter.peak, r.meter.loudness())
+	if _, err := r.raw.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	in := bufio.NewReader(r.raw)
+	buf := make([]byte, 4*2*1024)
+	var block []float32
+	var samples []int16
+	for {
+		n, err := io.ReadFull(in, buf)
+		if err == io.EOF {
+			return nil
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		block = block[:0]
+		for i := 0; i+4 <= n; i += 4 {
+			v := math.Float32frombits(binary.LittleEndian.Uint32(buf[i:]))
+			block = append(block, float32(float64(v)*r.gain))
+		}
+		samples = r.dither.int16s(samples[:0], block)
+		if err := r.file.write(samples); err != nil {
+			return err
+		}
+	}
+}
+
+// Close stops recording and finishes the file.
+func (r *audioRecorder) Close() error {
+	r.taps.remove(r.blocks)
+	close(r.blocks)
+	return <-r.done
+}
+
+// report returns the level of the finished recording as log attributes:
+// its peak and loudness before normalization and the gain applied.
+func (r *audioRecorder) report() []any {
+	attrs := []any{"peak", formatDb(r.meter.peak) + " dBFS", "loudness", fmt.Sprintf("%.1f LUFS", r.meter.loudness())}
+	if r.normalize.mode != "off" {
+		attrs = append(attrs, "gain", fmt.Sprintf("%+.1f dB", toDb(r.gain)))
+	}
+	return attrs
+}
+
+// wavFile writes a WAV file, filling in the sizes when it's closed.
+type wavFile struct {
+	f          *os.File
+	w          *bufio.Writer
+	sampleRate int
+	dataSize   uint32
+}
+
+func newWavFile(f *os.File, sampleRate int) (*wavFile, error) {
+	w := &wavFile{f: f, w: bufio.NewWriter(f), sampleRate: sampleRate}
+	if _, err := w.w.Write(wavHeader(sampleRate, 2, 16, 0)); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wavFile) write(samples []int16) error {
+	w.dataSize += uint32(2 * len(samples))
+	_, err := w.w.Write(le(samples))
+	return err
+}
+
+func (w *wavFile) Close() error {
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if _, err := w.f.WriteAt(wavHeader(w.sampleRate, 2, 16, w.dataSize), 0); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// flacFile writes a FLAC file, buffering samples into full blocks.
+type flacFile struct {
+	enc         *flac.Encoder
+	sampleRate  int
+	left, right []int32
+}
+
+func newFlacFile(f *os.File, sampleRate int) (*flacFile, error) {
+	info := &meta.StreamInfo{
+		BlockSizeMin:  flacBlockFrames,
+		BlockSizeMax:  flacBlockFrames,
+		SampleRate:    uint32(sampleRate),
+		NChannels:     2,
+		BitsPerSample: 16,
+	}
+	enc, err := flac.NewEncoder(f, info)
+	if err != nil {
+		return nil, err
+	}
+	return &flacFile{enc: enc, sampleRate: sampleRate}, nil
+}
+
+func (w *flacFile) write(samples []int16) error {
+	for i := 0; i+1 < len(samples); i += 2 {
+		w.left = append(w.left, int32(samples[i]))
+		w.right = append(w.right, int32(samples[i+1]))
+		if len(w.left) == flacBlockFrames {
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush encodes the buffered samples as one frame.
+func (w *flacFile) flush() error {
+	n := len(w.left)
+	if n == 0 {
+		return nil
+	}
+	f := &frame.Frame{
+		Header: frame.Header{
+			HasFixedBlockSize: true,
+			BlockSize:         uint16(n),
+			SampleRate:        uint32(w.sampleRate),
+			Channels:          frame.ChannelsMidSide,
+			BitsPerSample:     16,
+		},
+		Subframes: []*frame.Subframe{
+			{SubHeader: frame.SubHeader{Pred: frame.PredVerbatim}, Samples: w.left, NSamples: n},
+			{SubHeader: frame.SubHeader{Pred: frame.PredVerbatim}, Samples: w.right, NSamples: n},
+		},
+	}
+	err := w.enc.WriteFrame(f)
+	w.left, w.right = w.left[:0], w.right[:0]
+	return err
+}
+
+// Close writes the last, shorter frame and updates the stream info.
+func (w *flacFile) Close() error {
+	if err := w.flush(); err != nil {
+		w.enc.Close()
+		return err
+	}
+	return w.enc.Close()
+}
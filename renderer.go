@@ -0,0 +1,183 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+const (
+	renderBlockFrames = 512 // frames rendered at a time
+	renderBlocks      = 4   // blocks the ring buffer holds
+
+	// Low-latency outputs render smaller blocks, so that note events take
+	// effect sooner and less audio is buffered ahead.
+	lowLatencyBlockFrames = 64
+)
+
+// renderer runs the synthesizer on its own goroutine, keeping a ring buffer
+// filled ahead of the audio output. Scheduling hiccups then eat into the
+// buffered audio instead of delaying oto's Read. meltysynth isn't safe for
+// concurrent use, so every block is rendered under the lock that MIDI
+// handling and the handler's timers hold while they change the synthesizer.
+type renderer struct {
+	synthesizer *meltysynth.Synthesizer
+	lock        sync.Locker // the handler's lock
+	metronome   *metronome  // click mixed into the output, may be nil
+	stats       *audioStats // may be nil
+	voices      *voiceMeter // may be nil
+	ring        *ringBuffer // interleaved stereo samples
+	sampleRate  int
+	blockFrames int // frames rendered at a time
+	taps        audioTaps
+	resample    *resampler     // converts to the output rate, may be nil
+	width       float32        // stereo width: 0 is mono, 1 unchanged, above 1 wider
+	effects     []Effect       // master bus chain, run in order
+	extra       []*midiHandler // further sound modules mixed in
+	events      *eventQueue    // MIDI played at its frame, may be nil
+	clock       eventClock
+
+	next        atomic.Pointer[meltysynth.Synthesizer] // replacement taken over at the next block
+	nextEffects atomic.Pointer[[]Effect]               // replacement effect chain
+	muted       atomic.Bool                            // output silence
+}
+
+// newRenderer creates a renderer whose ring buffer holds the given number
+// of blocks.
+func newRenderer(synthesizer *meltysynth.Synthesizer, lock sync.Locker, sampleRate, blockFrames, blocks int) *renderer {
+	return &renderer{
+		synthesizer: synthesizer,
+		lock:        lock,
+		ring:        newRingBuffer(2 * blockFrames * blocks),
+		sampleRate:  sampleRate,
+		blockFrames: blockFrames,
+		width:       1,
+	}
+}
+
+// setOutputRate resamples the output for a device running at another rate
+// than the synthesizer. Taps still get audio at the synthesizer's rate. It
+// must be called before start.
+func (r *renderer) setOutputRate(rate int) {
+	if rate == r.sampleRate {
+		return
+	}
+	r.resample = newResampler(r.sampleRate, rate)
+	blocks := len(r.ring.data) / (2 * r.blockFrames)
+	r.ring = newRingBuffer(2 * r.resample.maxOutput(r.blockFrames) * blocks)
+}
+
+// scheduleEvents plays the events of queue at their frame within a block,
+// delayed by the ring buffer. It must be called before start.
+func (r *renderer) scheduleEvents(queue *eventQueue) {
+	delay := float64(len(r.ring.data) / 2)
+	if r.resample != nil {
+		delay *= r.resample.step
+	}
+	r.events = queue
+	r.clock = eventClock{delay: int64(delay), rate: float64(r.sampleRate)}
+}
+
+// replaceSynthesizer switches to another synthesizer between two blocks.
+func (r *renderer) replaceSynthesizer(synthesizer *meltysynth.Synthesizer) {
+	r.next.Store(synthesizer)
+}
+
+// replaceEffects switches to another effect chain between two blocks.
+func (r *renderer) replaceEffects(chain []Effect) {
+	r.nextEffects.Store(&chain)
+}
+
+// start renders in the background until the program exits.
+func (r *renderer) start() {
+	go r.run()
+}
+
+func (r *renderer) run() {
+	left := make([]float32, r.blockFrames)
+	right := make([]float32, r.blockFrames)
+	extraLeft := make([]float32, r.blockFrames)
+	extraRight := make([]float32, r.blockFrames)
+	block := make([]float32, 2*r.blockFrames)
+	need := len(block) // ring space one block takes
+	var resampled []float32
+	if r.resample != nil {
+		need = 2 * r.resample.maxOutput(r.blockFrames)
+	}
+	blockDuration := time.Duration(float64(r.blockFrames) * float64(time.Second) / float64(r.sampleRate))
+
+	for {
+		if r.ring.free() < need {
+			time.Sleep(blockDuration / 4)
+			continue
+		}
+
+		if next := r.next.Swap(nil); next != nil {
+			r.synthesizer = next
+		}
+		if next := r.nextEffects.Swap(nil); next != nil {
+			r.effects = *next
+		}
+		start := time.Now()
+		if r.events != nil {
+			r.renderScheduled(left, right)
+		} else {
+			r.render(left, right)
+		}
+		for _, h := range r.extra {
+			h.mu.Lock()
+			h.synthesizer.Render(extraLeft, extraRight)
+			h.mu.Unlock()
+			for i := range left {
+				left[i] += extraLeft[i]
+				right[i] += extraRight[i]
+			}
+		}
+		for _, effect := range r.effects {
+			effect.Process(left, right)
+		}
+		if r.stats != nil {
+			r.stats.record(r.blockFrames, time.Since(start))
+		}
+		if r.voices != nil {
+			r.lock.Lock()
+			active := activeVoices(r.synthesizer)
+			r.lock.Unlock()
+			r.voices.update(active)
+		}
+
+		for i := range r.blockFrames {
+			l, rt := left[i], right[i]
+			if r.metronome != nil {
+				click := r.metronome.next()
+				l += click
+				rt += click
+			}
+			if r.width != 1 {
+				mid, side := (l+rt)/2, (l-rt)/2*r.width
+				l, rt = mid+side, mid-side
+			}
+			block[2*i] = l
+			block[2*i+1] = rt
+		}
+		if r.muted.Load() {
+			clear(block)
+		}
+		r.taps.send(block)
+		if r.resample != nil {
+			resampled = r.resample.process(resampled[:0], block)
+			r.ring.push(resampled)
+		} else {
+			r.ring.push(block)
+		}
+	}
+}
+
+// render renders part of a block under the lock.
+func (r *renderer) render(left, right []float32) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.synthesizer.Render(left, right)
+}
@@ -0,0 +1,91 @@
+package main
+
+import "math"
+
+const (
+	resampleTaps   = 16  // zero crossings of the kernel on each side
+	resamplePhases = 256 // kernel table entries per input sample
+)
+
+// resampler converts interleaved stereo audio between sample rates with a
+// Blackman-windowed sinc filter, for devices that can't run at the
+// synthesizer's rate. When downsampling, the kernel is widened to filter
+// out what the lower rate can't represent.
+type resampler struct {
+	step    float64   // input frames per output frame
+	width   int       // kernel half-width in input frames
+	kernel  []float32 // kernel over distances 0..width, resamplePhases per frame
+	history []float32 // interleaved input frames not yet consumed
+	pos     float64   // position of the next output frame in history
+}
+
+func newResampler(inputRate, outputRate int) *resampler {
+	step := float64(inputRate) / float64(outputRate)
+	// Keep a little below the lower Nyquist frequency.
+	cutoff := 0.95 * min(1, 1/step)
+	width := int(math.Ceil(resampleTaps / cutoff))
+	kernel := make([]float32, width*resamplePhases+2)
+	for i := range kernel {
+		d := float64(i) / resamplePhases
+		if d >= float64(width) {
+			break
+		}
+		x := math.Pi * cutoff * d
+		sinc := 1.0
+		if x != 0 {
+			sinc = math.Sin(x) / x
+		}
+		w := 0.42 + 0.5*math.Cos(math.Pi*d/float64(width)) + 0.08*math.Cos(2*math.Pi*d/float64(width))
+		kernel[i] = float32(cutoff * sinc * w)
+	}
+	// Start with silence before the first input frame, so the first output
+	// frames have their left neighbours.
+	return &resampler{
+		step:    step,
+		width:   width,
+		kernel:  kernel,
+		history: make([]float32, 2*width),
+		pos:     float64(width),
+	}
+}
+
+// maxOutput returns the most frames process can return for the given
+// number of input frames.
+func (r *resampler) maxOutput(frames int) int {
+	return int(math.Ceil(float64(frames)/r.step)) + 1
+}
+
+// process resamples input and appends the result to out.
+func (r *resampler) process(out, input []float32) []float32 {
+	r.history = append(r.history, input...)
+	frames := len(r.history) / 2
+	for r.pos+float64(r.width) < float64(frames) {
+		center := int(r.pos)
+		frac := r.pos - float64(center)
+		var left, right float32
+		for k := center - r.width + 1; k <= center+r.width; k++ {
+			h := r.tap(math.Abs(float64(k-center) - frac))
+			left += h * r.history[2*k]
+			right += h * r.history[2*k+1]
+		}
+		out = append(out, left, right)
+		r.pos += r.step
+	}
+
+	// Drop the frames no future output frame reaches.
+	drop := max(0, int(r.pos)-r.width+1)
+	r.history = append(r.history[:0], r.history[2*drop:]...)
+	r.pos -= float64(drop)
+	return out
+}
+
+// tap interpolates the kernel at a distance in input frames.
+func (r *resampler) tap(d float64) float32 {
+	x := d * resamplePhases
+	i := int(x)
+	if i >= len(r.kernel)-1 {
+		return 0
+	}
+	f := float32(x - float64(i))
+	return r.kernel[i] + f*(r.kernel[i+1]-r.kernel[i])
+}
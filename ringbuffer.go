@@ -0,0 +1,50 @@
+package main
+
+import "sync/atomic"
+
+// ringBuffer is a lock-free queue of samples for one producer and one
+// consumer, which only synchronize through the atomic positions.
+type ringBuffer struct {
+	data  []float32
+	mask  uint64
+	write atomic.Uint64 // samples written since the start
+	read  atomic.Uint64 // samples read since the start
+}
+
+// newRingBuffer creates a buffer holding at least size samples.
+func newRingBuffer(size int) *ringBuffer {
+	capacity := 1
+	for capacity < size {
+		capacity <<= 1
+	}
+	return &ringBuffer{data: make([]float32, capacity), mask: uint64(capacity - 1)}
+}
+
+// free returns the number of samples that can be written.
+func (r *ringBuffer) free() int {
+	return len(r.data) - int(r.write.Load()-r.read.Load())
+}
+
+// push writes as many samples as fit and returns how many it wrote. Only the
+// producer may call it.
+func (r *ringBuffer) push(samples []float32) int {
+	w := r.write.Load()
+	n := min(len(samples), len(r.data)-int(w-r.read.Load()))
+	for i := range n {
+		r.data[(w+uint64(i))&r.mask] = samples[i]
+	}
+	r.write.Store(w + uint64(n))
+	return n
+}
+
+// pop reads up to len(out) samples and returns how many it read. Only the
+// consumer may call it.
+func (r *ringBuffer) pop(out []float32) int {
+	rd := r.read.Load()
+	n := min(len(out), int(r.write.Load()-rd))
+	for i := range n {
+		out[i] = r.data[(rd+uint64(i))&r.mask]
+	}
+	r.read.Store(rd + uint64(n))
+	return n
+}
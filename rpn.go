@@ -0,0 +1,109 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+const rpnNull = 0x7F
+
+// rpnState tracks the parameter selection of a channel. Selecting an RPN
+// deselects the NRPN and vice versa, so both share the parameter number.
+type rpnState struct {
+	msb, lsb int32 // selected parameter number
+	nrpn     bool  // whether msb/lsb select an NRPN
+	data     int32 // last 14-bit NRPN data entry
+
+	bendSemitones int32
+	bendCents     int32
+}
+
+func defaultRpnStates() [channelCount]rpnState {
+	var states [channelCount]rpnState
+	for i := range states {
+		states[i] = rpnState{msb: rpnNull, lsb: rpnNull, bendSemitones: 2}
+	}
+	return states
+}
+
+// registeredParameter handles RPN and NRPN selection (CC101/100 and
+// CC99/98) and data entry (CC6/38). RPN 0,0 (pitch bend range) and 0,2
+// (coarse tuning, used as transpose) are supported; they are applied
+// through our own RPN messages rather than forwarding the raw data entry, so
+// the fine tuning used by the retuner can't be overwritten by RPN 1.
+func (h *midiHandler) registeredParameter(channel, cc, value int32) {
+	state := &h.rpn[channel]
+	switch cc {
+	case 0x65: // RPN MSB
+		state.msb, state.nrpn = value, false
+		return
+	case 0x64: // RPN LSB
+		state.lsb, state.nrpn = value, false
+		return
+	case 0x63: // NRPN MSB
+		state.msb, state.nrpn = value, true
+		return
+	case 0x62: // NRPN LSB
+		state.lsb, state.nrpn = value, true
+		return
+	}
+
+	if state.nrpn {
+		h.nonRegisteredParameter(channel, cc, value)
+		return
+	}
+	if state.msb != 0 {
+		return
+	}
+	switch state.lsb {
+	case 0:
+		switch cc {
+		case 0x06: // Data Entry MSB: semitones
+			state.bendSemitones = value
+			state.bendCents = 0
+		case 0x26: // Data Entry LSB: cents
+			state.bendCents = value
+		}
+		for _, ch := range h.targetChannels(channel) {
+			setPitchBendRange(h.synthesizer, ch, state.bendSemitones, state.bendCents)
+		}
+		slog.Info("Pitch bend range", "channel", channel+1, "semitones", state.bendSemitones, "cents", state.bendCents)
+	case 2:
+		if cc == 0x06 { // Data Entry MSB: semitones above 64
+			h.setTranspose(channel, value-64)
+			slog.Info("Transpose", "channel", channel+1, "semitones", value-64)
+		}
+	}
+}
+
+// setTranspose shifts a channel by semitones with RPN 2, together with the
+// other pitch offsets.
+func (h *midiHandler) setTranspose(channel, semitones int32) {
+	h.mix[channel].transpose = semitones
+	h.updatePitch(channel)
+}
+
+// setPitchBendRange sends RPN 0 to set the pitch bend range of a channel.
+func setPitchBendRange(synthesizer *meltysynth.Synthesizer, channel, semitones, cents int32) {
+	sendRpn(synthesizer, channel, 0, semitones, cents)
+}
+
+// setFineTune sends RPN 1 to detune a channel by up to ±100 cents.
+func setFineTune(synthesizer *meltysynth.Synthesizer, channel int32, cents float64) {
+	value := int32(math.Round(8192 + cents/100*8192))
+	value = max(0, min(16383, value))
+	sendRpn(synthesizer, channel, 1, value>>7, value&0x7F)
+}
+
+// sendRpn sets a registered parameter and deselects it again, so stray data
+// entry can't change it afterwards.
+func sendRpn(synthesizer *meltysynth.Synthesizer, channel, parameter, msb, lsb int32) {
+	synthesizer.ProcessMidiMessage(channel, 0xB0, 0x65, 0)
+	synthesizer.ProcessMidiMessage(channel, 0xB0, 0x64, parameter)
+	synthesizer.ProcessMidiMessage(channel, 0xB0, 0x06, msb)
+	synthesizer.ProcessMidiMessage(channel, 0xB0, 0x26, lsb)
+	synthesizer.ProcessMidiMessage(channel, 0xB0, 0x65, rpnNull)
+	synthesizer.ProcessMidiMessage(channel, 0xB0, 0x64, rpnNull)
+}
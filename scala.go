@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// scalaScale is a parsed Scala .scl file. Degrees holds the pitch of every
+// scale degree in cents above the tonic; the last degree is the period.
+type scalaScale struct {
+	Description string
+	Degrees     []float64
+}
+
+// keyboardMapping is a parsed Scala .kbm file.
+type keyboardMapping struct {
+	FirstNote     int
+	LastNote      int
+	MiddleNote    int     // key where scale degree 0 is mapped
+	ReferenceNote int     // key that sounds at ReferenceFreq
+	ReferenceFreq float64 // Hz
+	OctaveDegree  int     // scale degree of the formal octave, 0 means the scale size
+	Mapping       []int   // scale degree per key, -1 for unmapped keys
+}
+
+// defaultKeyboardMapping maps the scale linearly with degree 0 on middle C
+// and A4 at 440 Hz, which is what Scala assumes without a .kbm file.
+func defaultKeyboardMapping() *keyboardMapping {
+	return &keyboardMapping{
+		FirstNote:     0,
+		LastNote:      127,
+		MiddleNote:    60,
+		ReferenceNote: 69,
+		ReferenceFreq: 440,
+	}
+}
+
+// readScalaLines returns the non-comment lines of a Scala file.
+func readScalaLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.HasPrefix(line, "!") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// loadScalaScale reads a .scl file.
+func loadScalaScale(path string) (*scalaScale, error) {
+	lines, err := readScalaLines(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("%s: missing description or note count", path)
+	}
+
+	scale := &scalaScale{Description: strings.TrimSpace(lines[0])}
+	count, err := strconv.Atoi(firstField(lines[1]))
+	if err != nil || count < 1 {
+		return nil, fmt.Errorf("%s: invalid note count %q", path, lines[1])
+	}
+	if len(lines)-2 < count {
+		return nil, fmt.Errorf("%s: expected %d pitches, found %d", path, count, len(lines)-2)
+	}
+	for _, line := range lines[2 : 2+count] {
+		cents, err := parseScalaPitch(firstField(line))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		scale.Degrees = append(scale.Degrees, cents)
+	}
+	return scale, nil
+}
+
+// parseScalaPitch converts a pitch line to cents. Values containing a period
+// are cents, everything else is a ratio such as 3/2 or 2.
+func parseScalaPitch(s string) (float64, error) {
+	if strings.Contains(s, ".") {
+		cents, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid pitch %q", s)
+		}
+		return cents, nil
+	}
+
+	num, den := s, "1"
+	if i := strings.Index(s, "/"); i >= 0 {
+		num, den = s[:i], s[i+1:]
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || n <= 0 || d <= 0 {
+		return 0, fmt.Errorf("invalid pitch %q", s)
+	}
+	return 1200 * math.Log2(n/d), nil
+}
+
+// loadKeyboardMapping reads a .kbm file.
+func loadKeyboardMapping(path string) (*keyboardMapping, error) {
+	lines, err := readScalaLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	for _, line := range lines {
+		if f := firstField(line); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("%s: incomplete keyboard mapping header", path)
+	}
+
+	ints := make([]int, 7)
+	for i, f := range fields[:7] {
+		if i == 5 {
+			continue
+		}
+		ints[i], err = strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid value %q", path, f)
+		}
+	}
+	freq, err := strconv.ParseFloat(fields[5], 64)
+	if err != nil || freq <= 0 {
+		return nil, fmt.Errorf("%s: invalid reference frequency %q", path, fields[5])
+	}
+
+	kbm := &keyboardMapping{
+		FirstNote:     ints[1],
+		LastNote:      ints[2],
+		MiddleNote:    ints[3],
+		ReferenceNote: ints[4],
+		ReferenceFreq: freq,
+		OctaveDegree:  ints[6],
+	}
+	size := ints[0]
+	for _, f := range fields[7:] {
+		if len(kbm.Mapping) == size {
+			break
+		}
+		if f == "x" || f == "X" {
+			kbm.Mapping = append(kbm.Mapping, -1)
+			continue
+		}
+		degree, err := strconv.Atoi(f)
+		if err != nil || degree < 0 {
+			return nil, fmt.Errorf("%s: invalid mapping entry %q", path, f)
+		}
+		kbm.Mapping = append(kbm.Mapping, degree)
+	}
+	// Missing trailing entries are unmapped.
+	for len(kbm.Mapping) < size {
+		kbm.Mapping = append(kbm.Mapping, -1)
+	}
+	return kbm, nil
+}
+
+// newScalaTuning computes the pitch of every key from a scale and mapping.
+func newScalaTuning(scale *scalaScale, kbm *keyboardMapping) (*tuning, error) {
+	n := len(scale.Degrees)
+	degreeCents := func(degree int) float64 {
+		octave, step := floorDivMod(degree, n)
+		cents := float64(octave) * scale.Degrees[n-1]
+		if step > 0 {
+			cents += scale.Degrees[step-1]
+		}
+		return cents
+	}
+
+	// keyCents returns the pitch of a key relative to the middle note.
+	keyCents := func(key int) (float64, bool) {
+		offset := key - kbm.MiddleNote
+		if len(kbm.Mapping) == 0 {
+			return degreeCents(offset), true
+		}
+		octaveDegree := kbm.OctaveDegree
+		if octaveDegree == 0 {
+			octaveDegree = n
+		}
+		octave, index := floorDivMod(offset, len(kbm.Mapping))
+		degree := kbm.Mapping[index]
+		if degree < 0 {
+			return 0, false
+		}
+		return float64(octave)*degreeCents(octaveDegree) + degreeCents(degree), true
+	}
+
+	refCents, ok := keyCents(kbm.ReferenceNote)
+	if !ok {
+		return nil, fmt.Errorf("reference note %d is unmapped", kbm.ReferenceNote)
+	}
+
+	t := new(tuning)
+	for key := range t {
+		t[key] = math.NaN()
+		if key < kbm.FirstNote || key > kbm.LastNote {
+			continue
+		}
+		cents, ok := keyCents(key)
+		if !ok {
+			continue
+		}
+		freq := kbm.ReferenceFreq * math.Pow(2, (cents-refCents)/1200)
+		t[key] = 69 + 12*math.Log2(freq/440)
+	}
+	return t, nil
+}
+
+// loadScalaTuning loads a .scl file and an optional .kbm file.
+func loadScalaTuning(sclPath, kbmPath string) (*tuning, string, error) {
+	scale, err := loadScalaScale(sclPath)
+	if err != nil {
+		return nil, "", err
+	}
+	kbm := defaultKeyboardMapping()
+	if kbmPath != "" {
+		kbm, err = loadKeyboardMapping(kbmPath)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	t, err := newScalaTuning(scale, kbm)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", kbmPath, err)
+	}
+	return t, scale.Description, nil
+}
+
+func firstField(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func floorDivMod(a, b int) (int, int) {
+	q, r := a/b, a%b
+	if r < 0 {
+		q--
+		r += b
+	}
+	return q, r
+}
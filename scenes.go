@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// sceneConfig is a named setup for one song: channel programs and mix,
+// key zones and the effect chain, switched all at once.
+type sceneConfig struct {
+	Name     string         `json:"name"`
+	Channels []sceneChannel `json:"channels"`
+	// Zones replace the key zones of the config while the scene is active;
+	// omitted, the config's zones apply.
+	Zones []zoneConfig `json:"zones"`
+	// Effects replaces the -effects chain; omitted, -effects applies.
+	Effects *string `json:"effects"`
+}
+
+// sceneChannel sets up a synthesizer channel, numbered 1-16. Omitted
+// settings are left as they are.
+type sceneChannel struct {
+	Channel   int    `json:"channel"`
+	Bank      *int32 `json:"bank"`
+	Program   *int32 `json:"program"`
+	Volume    *int32 `json:"volume"`
+	Pan       *int32 `json:"pan"`
+	Transpose *int32 `json:"transpose"`
+}
+
+func (s *sceneConfig) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("no name")
+	}
+	for _, c := range s.Channels {
+		if c.Channel < 1 || c.Channel > channelCount {
+			return fmt.Errorf("channel %d out of range 1-16", c.Channel)
+		}
+		for name, v := range map[string]*int32{"program": c.Program, "volume": c.Volume, "pan": c.Pan} {
+			if v != nil && (*v < 0 || *v > 127) {
+				return fmt.Errorf("channel %d: %s %d out of range 0-127", c.Channel, name, *v)
+			}
+		}
+		if c.Transpose != nil && (*c.Transpose < -48 || *c.Transpose > 48) {
+			return fmt.Errorf("channel %d: transpose %d out of range -48-48", c.Channel, *c.Transpose)
+		}
+		if c.Bank != nil && c.Program == nil {
+			return fmt.Errorf("channel %d: bank requires a program", c.Channel)
+		}
+	}
+	for i, z := range s.Zones {
+		if err := z.validate(); err != nil {
+			return fmt.Errorf("zone %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// sceneSwitcher switches between the scenes of the config.
+type sceneSwitcher struct {
+	scenes     []sceneConfig
+	zones      []zoneConfig // the config's own zones
+	effects    string       // the -effects chain
+	renderer   *renderer
+	sampleRate int
+	channel    int32 // incoming channel whose program changes pick scenes, -1 for none
+}
+
+// newSceneSwitcher checks the effect chains of the scenes up front, so a
+// typo shows at start-up rather than in the middle of a set.
+func newSceneSwitcher(cfg *config, effects string, render *renderer, sampleRate, channel int) (*sceneSwitcher, error) {
+	for _, s := range cfg.Scenes {
+		if s.Effects != nil {
+			if _, err := parseEffects(*s.Effects, sampleRate); err != nil {
+				return nil, fmt.Errorf("scene %q: %w", s.Name, err)
+			}
+		}
+	}
+	return &sceneSwitcher{
+		scenes:     cfg.Scenes,
+		zones:      cfg.Zones,
+		effects:    effects,
+		renderer:   render,
+		sampleRate: sampleRate,
+		channel:    int32(channel - 1),
+	}, nil
+}
+
+// find looks a scene up by name or by number, counted from 1.
+func (s *sceneSwitcher) find(ref string) (int, error) {
+	for i, scene := range s.scenes {
+		if strings.EqualFold(scene.Name, ref) {
+			return i, nil
+		}
+	}
+	if n, err := strconv.Atoi(ref); err == nil && n >= 1 && n <= len(s.scenes) {
+		return n - 1, nil
+	}
+	return 0, fmt.Errorf("no scene %q", ref)
+}
+
+// handleSceneChange switches scenes on a program change of the scene
+// channel, program 0 being the first scene. It reports whether the message
+// was used.
+func (h *midiHandler) handleSceneChange(msg []byte) bool {
+	if h.scenes == nil || h.scenes.channel < 0 || len(msg) < 2 || msg[0] != 0xC0|byte(h.scenes.channel) {
+		return false
+	}
+	if int(msg[1]) >= len(h.scenes.scenes) {
+		slog.Warn("No scene for program change", "program", msg[1])
+		return true
+	}
+	h.applyScene(int(msg[1]))
+	return true
+}
+
+// selectScene switches to a scene by name or number.
+func (h *midiHandler) selectScene(ref string) error {
+	if h.scenes == nil {
+		return fmt.Errorf("no scenes in the config")
+	}
+	index, err := h.scenes.find(ref)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.applyScene(index)
+	return nil
+}
+
+// applyScene sets up a scene. Sounding notes are released, since the keys
+// may lead elsewhere afterwards.
+func (h *midiHandler) applyScene(index int) {
+	scene := h.scenes.scenes[index]
+	h.synthesizer.NoteOffAll(false)
+
+	zones := h.scenes.zones
+	if scene.Zones != nil {
+		zones = scene.Zones
+	}
+	h.zones = newKeyZones(zones)
+	h.applyZoneSettings()
+
+	for _, c := range scene.Channels {
+		ch := int32(c.Channel - 1)
+		if c.Program != nil {
+			if c.Bank != nil {
+				h.banks[ch] = *c.Bank
+			}
+			h.programChange(ch, *c.Program)
+		}
+		if c.Volume != nil {
+			h.controlChange(ch, 0x07, *c.Volume)
+		}
+		if c.Pan != nil {
+			h.controlChange(ch, 0x0A, *c.Pan)
+		}
+		if c.Transpose != nil {
+			h.setTranspose(ch, *c.Transpose)
+		}
+	}
+
+	effects := h.scenes.effects
+	if scene.Effects != nil {
+		effects = *scene.Effects
+	}
+	chain, err := parseEffects(effects, h.scenes.sampleRate)
+	if err != nil {
+		slog.Warn("Invalid scene effects", "scene", scene.Name, "err", err)
+	} else {
+		h.scenes.renderer.replaceEffects(chain)
+	}
+	slog.Info("Scene", "number", index+1, "name", scene.Name)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// scheduleFrames is the synthesizer block size used with sample-accurate
+// scheduling. The synthesizer only applies events between its own blocks,
+// so this bounds the timing error.
+const scheduleFrames = 16
+
+// timedEvent is MIDI data stamped with its arrival time.
+type timedEvent struct {
+	at   time.Time
+	data []byte
+}
+
+// eventQueue holds incoming MIDI messages until the renderer plays them at
+// their place in a block. Events are delayed by the renderer's buffer, so
+// that they keep their spacing however the blocks are rendered.
+type eventQueue struct {
+	mu      sync.Mutex
+	pending []timedEvent
+	play    func([]byte) // handles a message at its time
+}
+
+func newEventQueue(play func([]byte)) *eventQueue {
+	return &eventQueue{play: play}
+}
+
+// push stamps data with the current time and queues it.
+func (q *eventQueue) push(data []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = append(q.pending, timedEvent{time.Now(), slices.Clone(data)})
+}
+
+// due removes and returns the events that arrived before t.
+func (q *eventQueue) due(t time.Time) []timedEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for n < len(q.pending) && q.pending[n].at.Before(t) {
+		n++
+	}
+	events := slices.Clone(q.pending[:n])
+	q.pending = slices.Delete(q.pending, 0, n)
+	return events
+}
+
+// eventClock maps arrival times to frames of the rendered audio. Frame
+// positions run ahead of the wall clock by up to the ring buffer, so events
+// are placed that far behind their arrival to be rendered on time.
+type eventClock struct {
+	origin time.Time // wall time of frame 0, before the delay
+	frame  int64     // first frame of the next block
+	delay  int64     // frames between arrival and playback
+	rate   float64
+}
+
+// at returns the wall time of frame, before the delay.
+func (c *eventClock) at(frame int64) time.Time {
+	return c.origin.Add(time.Duration(float64(frame-c.delay) * float64(time.Second) / c.rate))
+}
+
+// offset returns the frame of the next block at which an event arriving at
+// t plays, clamped to the block.
+func (c *eventClock) offset(t time.Time, frames int) int {
+	frame := int64(t.Sub(c.origin).Seconds()*c.rate) + c.delay
+	return int(max(0, min(int64(frames-1), frame-c.frame)))
+}
+
+// advance moves to the next block. Rendering normally runs ahead of the
+// wall clock by up to the delay; if it falls behind, after an underrun or a
+// stall, or drifts too far ahead with the device clock, the clock restarts
+// from now.
+func (c *eventClock) advance(frames int) {
+	c.frame += int64(frames)
+	now := time.Now()
+	lead := c.at(c.frame + c.delay).Sub(now)
+	if c.origin.IsZero() || lead < -c.duration() || lead > 2*c.duration() {
+		c.origin = now.Add(-time.Duration(float64(c.frame) * float64(time.Second) / c.rate))
+	}
+}
+
+// duration returns the length of the delay.
+func (c *eventClock) duration() time.Duration {
+	return time.Duration(float64(c.delay) * float64(time.Second) / c.rate)
+}
+
+// renderScheduled renders a block of the synthesizer, playing the queued
+// events that fall into it at their frame.
+func (r *renderer) renderScheduled(left, right []float32) {
+	if r.clock.origin.IsZero() {
+		r.clock.advance(0) // start the clock
+	}
+	frames := len(left)
+	done := 0
+	for _, e := range r.events.due(r.clock.at(r.clock.frame + int64(frames))) {
+		if offset := r.clock.offset(e.at, frames); offset > done {
+			r.render(left[done:offset], right[done:offset])
+			done = offset
+		}
+		r.events.play(e.data)
+	}
+	r.render(left[done:], right[done:])
+	r.clock.advance(frames)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// channelMix is the state of a channel that is saved with the session.
+type channelMix struct {
+	program    int32  // last program change
+	volume     int32  // channel volume (CC7)
+	expression int32  // expression (CC11)
+	pan        int32  // pan (CC10)
+	transpose  int32  // coarse tuning (RPN 2) in semitones
+	preset     string // name of the preset playing, not saved
+}
+
+func defaultChannelMixes() [channelCount]channelMix {
+	var mixes [channelCount]channelMix
+	for i := range mixes {
+		mixes[i] = channelMix{volume: 100, expression: 127, pan: 64}
+	}
+	return mixes
+}
+
+// session is the runtime state saved on exit with -session, so a live rig
+// comes back as it was left.
+type session struct {
+	SoundFont string           `json:"soundFont"`
+	Channels  []channelSession `json:"channels"`
+}
+
+// channelSession is the saved state of a channel, numbered 1-16.
+type channelSession struct {
+	Channel    int    `json:"channel"`
+	Bank       int32  `json:"bank"`
+	Program    int32  `json:"program"`
+	Volume     int32  `json:"volume"`
+	Expression *int32 `json:"expression,omitempty"` // missing in older sessions
+	Pan        int32  `json:"pan"`
+	Transpose  int32  `json:"transpose"`
+	Muted      bool   `json:"muted"`
+	Soloed     bool   `json:"soloed"`
+}
+
+// loadSession reads a session file. A missing file is not an error: the
+// session starts fresh and is created on exit.
+func loadSession(path string) (*session, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, c := range s.Channels {
+		if c.Channel < 1 || c.Channel > channelCount {
+			return nil, fmt.Errorf("%s: channel %d out of range 1-16", path, c.Channel)
+		}
+	}
+	return &s, nil
+}
+
+// save writes the session file, replacing it only once it's complete.
+func (s *session) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// session captures the current channel state.
+func (h *midiHandler) session() *session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := &session{SoundFont: h.soundFont}
+	for ch := range channelCount {
+		mix := h.mix[ch]
+		s.Channels = append(s.Channels, channelSession{
+			Channel:    ch + 1,
+			Bank:       h.banks[ch],
+			Program:    mix.program,
+			Volume:     mix.volume,
+			Expression: &mix.expression,
+			Pan:        mix.pan,
+			Transpose:  mix.transpose,
+			Muted:      h.muted[ch],
+			Soloed:     h.soloed[ch],
+		})
+	}
+	return s
+}
+
+// restoreSession applies a saved channel state.
+func (h *midiHandler) restoreSession(s *session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, c := range s.Channels {
+		ch := int32(c.Channel - 1)
+		h.banks[ch] = c.Bank
+		h.programChange(ch, c.Program)
+		h.controlChange(ch, 0x07, c.Volume)
+		h.controlChange(ch, 0x0A, c.Pan)
+		if c.Expression != nil {
+			h.controlChange(ch, 0x0B, *c.Expression)
+		}
+		if c.Transpose != 0 {
+			h.setTranspose(ch, c.Transpose)
+		}
+		h.muted[ch], h.soloed[ch] = c.Muted, c.Soloed
+	}
+	slog.Info("Restored session", "channels", len(s.Channels))
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// SF2 generator operators used by sf2Bank.
+const (
+	genPan                = 17
+	genAttackVolEnv       = 34
+	genHoldVolEnv         = 35
+	genDecayVolEnv        = 36
+	genSustainVolEnv      = 37
+	genReleaseVolEnv      = 38
+	genInstrument         = 41
+	genKeyRange           = 43
+	genVelRange           = 44
+	genInitialAttenuation = 48
+	genCoarseTune         = 51
+	genFineTune           = 52
+	genSampleID           = 53
+	genSampleModes        = 54
+	genExclusiveClass     = 57
+	genOverridingRootKey  = 58
+)
+
+// sf2SampleGuard is the number of zero samples SF2 requires after each
+// sample.
+const sf2SampleGuard = 46
+
+// sf2Bank is a sound bank built in memory and serialized as SF2, so that
+// instrument formats meltysynth can't read play through the same engine.
+type sf2Bank struct {
+	name        string
+	samples     []sf2Sample
+	instruments []sf2Instrument
+	presets     []sf2Preset
+}
+
+type sf2Sample struct {
+	name       string
+	data       []int16
+	sampleRate int
+	rootKey    int
+	correction int // pitch correction in cents
+	loopStart  int // loop points relative to the sample start
+	loopEnd    int
+}
+
+type sf2Instrument struct {
+	name  string
+	zones []sf2Zone
+}
+
+// sf2Zone is an instrument zone playing one sample.
+type sf2Zone struct {
+	sample     int
+	keyLow     int
+	keyHigh    int
+	velLow     int
+	velHigh    int
+	generators []sf2Generator
+}
+
+type sf2Generator struct {
+	op     uint16
+	amount int16
+}
+
+type sf2Preset struct {
+	name       string
+	bank       int
+	program    int
+	instrument int
+}
+
+// set adds a generator to the zone.
+func (z *sf2Zone) set(op uint16, amount int) {
+	amount = max(math.MinInt16, min(math.MaxInt16, amount))
+	z.generators = append(z.generators, sf2Generator{op: op, amount: int16(amount)})
+}
+
+// timecents converts seconds to SF2 timecents.
+func timecents(seconds float64) int {
+	if seconds <= 0.001 {
+		return -12000
+	}
+	return int(math.Round(1200 * math.Log2(seconds)))
+}
+
+// soundFont serializes the bank and loads it into meltysynth.
+func (b *sf2Bank) soundFont() (*meltysynth.SoundFont, error) {
+	return meltysynth.NewSoundFont(bytes.NewReader(b.bytes()))
+}
+
+// bytes serializes the bank as an SF2 file.
+func (b *sf2Bank) bytes() []byte {
+	info := riffList("INFO",
+		riffChunk("ifil", le(uint16(2), uint16(1))),
+		riffChunk("isng", []byte("EMU8000\x00")),
+		riffChunk("INAM", append([]byte(b.name), 0)),
+	)
+
+	var smpl bytes.Buffer
+	var shdr bytes.Buffer
+	guard := make([]int16, sf2SampleGuard)
+	for _, s := range b.samples {
+		start := uint32(smpl.Len() / 2)
+		binary.Write(&smpl, binary.LittleEndian, s.data)
+		binary.Write(&smpl, binary.LittleEndian, guard)
+		shdr.Write(sf2Name(s.name))
+		shdr.Write(le(start, start+uint32(len(s.data)), start+uint32(s.loopStart), start+uint32(s.loopEnd),
+			uint32(s.sampleRate), uint8(s.rootKey), int8(s.correction), uint16(0), uint16(1)))
+	}
+	shdr.Write(sf2Name("EOS"))
+	shdr.Write(make([]byte, 26))
+	sdta := riffList("sdta", riffChunk("smpl", smpl.Bytes()))
+
+	var inst, ibag, igen bytes.Buffer
+	for _, instrument := range b.instruments {
+		inst.Write(sf2Name(instrument.name))
+		inst.Write(le(uint16(ibag.Len() / 4)))
+		for _, z := range instrument.zones {
+			ibag.Write(le(uint16(igen.Len()/4), uint16(0)))
+			// Ranges come first and the sample last, as the format requires.
+			igen.Write(le(uint16(genKeyRange), uint8(z.keyLow), uint8(z.keyHigh)))
+			igen.Write(le(uint16(genVelRange), uint8(z.velLow), uint8(z.velHigh)))
+			for _, g := range z.generators {
+				igen.Write(le(g.op, g.amount))
+			}
+			igen.Write(le(uint16(genSampleID), uint16(z.sample)))
+		}
+	}
+	inst.Write(sf2Name("EOI"))
+	inst.Write(le(uint16(ibag.Len() / 4)))
+	ibag.Write(le(uint16(igen.Len()/4), uint16(0)))
+	igen.Write(make([]byte, 4))
+
+	var phdr, pbag, pgen bytes.Buffer
+	for i, p := range b.presets {
+		phdr.Write(sf2Name(p.name))
+		phdr.Write(le(uint16(p.program), uint16(p.bank), uint16(i), uint32(0), uint32(0), uint32(0)))
+		pbag.Write(le(uint16(i), uint16(0)))
+		pgen.Write(le(uint16(genInstrument), uint16(p.instrument)))
+	}
+	phdr.Write(sf2Name("EOP"))
+	phdr.Write(le(uint16(0), uint16(0), uint16(len(b.presets)), uint32(0), uint32(0), uint32(0)))
+	pbag.Write(le(uint16(len(b.presets)), uint16(0)))
+	pgen.Write(make([]byte, 4))
+
+	pdta := riffList("pdta",
+		riffChunk("phdr", phdr.Bytes()),
+		riffChunk("pbag", pbag.Bytes()),
+		riffChunk("pmod", make([]byte, 10)),
+		riffChunk("pgen", pgen.Bytes()),
+		riffChunk("inst", inst.Bytes()),
+		riffChunk("ibag", ibag.Bytes()),
+		riffChunk("imod", make([]byte, 10)),
+		riffChunk("igen", igen.Bytes()),
+		riffChunk("shdr", shdr.Bytes()),
+	)
+	return riffChunk("RIFF", append([]byte("sfbk"), bytes.Join([][]byte{info, sdta, pdta}, nil)...))
+}
+
+// riffChunk encodes a RIFF chunk. SF2 requires even chunk sizes, so odd
+// data is padded and the padding counted in the size.
+func riffChunk(id string, data []byte) []byte {
+	if len(data)%2 != 0 {
+		data = append(data, 0)
+	}
+	out := append([]byte(id), le(uint32(len(data)))...)
+	return append(out, data...)
+}
+
+func riffList(listType string, chunks ...[]byte) []byte {
+	return riffChunk("LIST", append([]byte(listType), bytes.Join(chunks, nil)...))
+}
+
+// sf2Name encodes a 20-byte, zero-padded name.
+func sf2Name(name string) []byte {
+	out := make([]byte, 20)
+	copy(out[:19], name)
+	return out
+}
+
+// le encodes fixed-size values in little-endian order.
+func le(values ...any) []byte {
+	var buf bytes.Buffer
+	for _, v := range values {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/jfreymuth/oggvorbis"
+)
+
+// sf3Compressed is the sample type flag of Ogg Vorbis compressed samples.
+const sf3Compressed = 0x10
+
+// sf2HeaderSize is the size of a sample header record.
+const sf2HeaderSize = 46
+
+// riffEntry is a chunk of a RIFF file.
+type riffEntry struct {
+	id   string
+	data []byte
+}
+
+// readRiffChunks splits RIFF data into its chunks. A truncated last chunk
+// keeps the data that is there.
+func readRiffChunks(data []byte) []riffEntry {
+	var chunks []riffEntry
+	for pos := 0; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		end := min(len(data), pos+8+size)
+		chunks = append(chunks, riffEntry{id: string(data[pos : pos+4]), data: data[pos+8 : end]})
+		pos += 8 + size + size%2
+	}
+	return chunks
+}
+
+// list returns the type and chunks of a LIST chunk.
+func (e riffEntry) list() (string, []riffEntry) {
+	if e.id != "LIST" || len(e.data) < 4 {
+		return "", nil
+	}
+	return string(e.data[:4]), readRiffChunks(e.data[4:])
+}
+
+// decompressSoundFont converts an SF3 file, whose samples are Ogg Vorbis
+// streams, to SF2 by decoding every sample, since meltysynth only reads PCM
+// sample data. Other files are returned unchanged.
+func decompressSoundFont(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "sfbk" {
+		return data, nil
+	}
+	lists := make(map[string][]riffEntry)
+	for _, chunk := range readRiffChunks(data[12:min(len(data), 8+int(binary.LittleEndian.Uint32(data[4:])))]) {
+		if listType, sub := chunk.list(); listType != "" {
+			lists[listType] = sub
+		}
+	}
+
+	var smpl, shdr []byte
+	for _, c := range lists["sdta"] {
+		if c.id == "smpl" {
+			smpl = c.data
+		}
+	}
+	for _, c := range lists["pdta"] {
+		if c.id == "shdr" {
+			shdr = c.data
+		}
+	}
+	compressed := false
+	for pos := 0; pos+sf2HeaderSize <= len(shdr); pos += sf2HeaderSize {
+		if binary.LittleEndian.Uint16(shdr[pos+44:])&sf3Compressed != 0 {
+			compressed = true
+		}
+	}
+	if !compressed {
+		return data, nil
+	}
+
+	// Decode the samples into a new sample pool, rewriting their headers.
+	var pool bytes.Buffer
+	headers := bytes.Clone(shdr)
+	guard := make([]byte, sf2SampleGuard*2)
+	for pos := 0; pos+sf2HeaderSize <= len(headers)-sf2HeaderSize; pos += sf2HeaderSize {
+		h := headers[pos:]
+		start := binary.LittleEndian.Uint32(h[20:])
+		end := binary.LittleEndian.Uint32(h[24:])
+		loopStart := binary.LittleEndian.Uint32(h[28:])
+		loopEnd := binary.LittleEndian.Uint32(h[32:])
+		sampleType := binary.LittleEndian.Uint16(h[44:])
+
+		var samples []byte
+		if sampleType&sf3Compressed != 0 {
+			// Compressed samples are addressed in bytes and their loops are
+			// relative to the sample start.
+			if start > end || int(end) > len(smpl) {
+				return nil, fmt.Errorf("sample %d out of range", pos/sf2HeaderSize)
+			}
+			decoded, format, err := oggvorbis.ReadAll(bytes.NewReader(smpl[start:end]))
+			if err != nil {
+				return nil, fmt.Errorf("sample %d: %w", pos/sf2HeaderSize, err)
+			}
+			samples = make([]byte, 0, len(decoded)/format.Channels*2)
+			for i := 0; i < len(decoded); i += format.Channels {
+				v := int16(max(-32768, min(32767, math.Round(float64(decoded[i])*32767))))
+				samples = binary.LittleEndian.AppendUint16(samples, uint16(v))
+			}
+			sampleType &^= sf3Compressed
+		} else {
+			if start > end || int(end)*2 > len(smpl) {
+				return nil, fmt.Errorf("sample %d out of range", pos/sf2HeaderSize)
+			}
+			samples = smpl[start*2 : end*2]
+			loopStart -= start
+			loopEnd -= start
+		}
+
+		offset := uint32(pool.Len() / 2)
+		pool.Write(samples)
+		pool.Write(guard)
+		binary.LittleEndian.PutUint32(h[20:], offset)
+		binary.LittleEndian.PutUint32(h[24:], offset+uint32(len(samples)/2))
+		binary.LittleEndian.PutUint32(h[28:], offset+loopStart)
+		binary.LittleEndian.PutUint32(h[32:], offset+loopEnd)
+		binary.LittleEndian.PutUint16(h[44:], sampleType)
+	}
+
+	var info, pdta [][]byte
+	for _, c := range lists["INFO"] {
+		if c.id == "ifil" {
+			c.data = le(uint16(2), uint16(1))
+		}
+		info = append(info, riffChunk(c.id, c.data))
+	}
+	for _, c := range lists["pdta"] {
+		if c.id == "shdr" {
+			c.data = headers
+		}
+		pdta = append(pdta, riffChunk(c.id, c.data))
+	}
+	return riffChunk("RIFF", append([]byte("sfbk"), bytes.Join([][]byte{
+		riffList("INFO", info...),
+		riffList("sdta", riffChunk("smpl", pool.Bytes())),
+		riffList("pdta", pdta...),
+	}, nil)...)), nil
+}
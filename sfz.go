@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// sfzOpcode matches the start of an opcode. Values run until the next
+// opcode or header, since sample paths may contain spaces.
+var sfzOpcode = regexp.MustCompile(`([A-Za-z0-9_]+)=`)
+
+// sfzHeaders are the headers whose opcodes apply to the regions below them,
+// from the outermost in. A header resets the opcodes of the levels inside it.
+var sfzHeaders = []string{"global", "master", "group", "region"}
+
+// parseSfz splits an SFZ file into regions, each with the opcodes inherited
+// from its global, master and group headers, and returns the <control>
+// opcodes separately.
+func parseSfz(text string) (regions []map[string]string, control map[string]string, err error) {
+	control = make(map[string]string)
+	levels := make([]map[string]string, len(sfzHeaders))
+	current := control
+	inRegion := false
+	flush := func() {
+		if !inRegion {
+			return
+		}
+		region := make(map[string]string)
+		for _, level := range levels {
+			for k, v := range level {
+				region[k] = v
+			}
+		}
+		regions = append(regions, region)
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			return nil, nil, fmt.Errorf("preprocessor directives are not supported: %s", line)
+		}
+		for line != "" {
+			if line[0] == '<' {
+				end := strings.Index(line, ">")
+				if end < 0 {
+					return nil, nil, fmt.Errorf("unterminated header: %s", line)
+				}
+				header := line[1:end]
+				line = strings.TrimSpace(line[end+1:])
+				flush()
+				inRegion = header == "region"
+				if header == "control" {
+					current = control
+					continue
+				}
+				level := -1
+				for i, h := range sfzHeaders {
+					if h == header {
+						level = i
+					}
+				}
+				if level < 0 {
+					// Unsupported headers such as <curve> and <effect>: skip their opcodes.
+					current = make(map[string]string)
+					continue
+				}
+				for i := level; i < len(levels); i++ {
+					levels[i] = make(map[string]string)
+				}
+				current = levels[level]
+				continue
+			}
+
+			// Take the text up to the next header as a run of opcodes.
+			end := strings.Index(line, "<")
+			if end < 0 {
+				end = len(line)
+			}
+			run := line[:end]
+			line = line[end:]
+			matches := sfzOpcode.FindAllStringSubmatchIndex(run, -1)
+			if len(matches) == 0 || strings.TrimSpace(run[:matches[0][0]]) != "" {
+				return nil, nil, fmt.Errorf("invalid opcode: %s", strings.TrimSpace(run))
+			}
+			for i, m := range matches {
+				valueEnd := len(run)
+				if i+1 < len(matches) {
+					valueEnd = matches[i+1][0]
+				}
+				current[run[m[2]:m[3]]] = strings.TrimSpace(run[m[1]:valueEnd])
+			}
+		}
+	}
+	flush()
+	return regions, control, nil
+}
+
+// sfzKey parses a key given as a number or a note name such as "c#4".
+func sfzKey(value string) (int, error) {
+	if key, err := strconv.Atoi(value); err == nil {
+		if key < 0 || key > 127 {
+			return 0, fmt.Errorf("key %d out of range 0-127", key)
+		}
+		return key, nil
+	}
+	key, err := parseNoteName(value)
+	return int(key), err
+}
+
+// sfzSampleKey identifies a converted sample. SF2 keeps loop points in the
+// sample header, so regions looping one file differently get their own copy.
+type sfzSampleKey struct {
+	path               string
+	loopStart, loopEnd int
+}
+
+// loadSfz reads an SFZ instrument and converts it to a sound font with a
+// single preset, 0:0. Only a subset of SFZ is supported: key and velocity
+// ranges, tuning, volume and pan, loops and the amplitude envelope. Release
+// triggers and round robins beyond the first sample are ignored.
+func loadSfz(path string) (*meltysynth.SoundFont, error) {
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	regions, control, err := parseSfz(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	bank := &sf2Bank{name: name}
+	instrument := sf2Instrument{name: name}
+	wavs := make(map[string]*wavSound)
+	samples := make(map[sfzSampleKey][]int)
+	for i, region := range regions {
+		if region["trigger"] == "release" || region["trigger"] == "release_key" {
+			continue
+		}
+		if seq, ok := region["seq_position"]; ok && seq != "1" {
+			continue
+		}
+		zones, err := bank.sfzZones(region, control, filepath.Dir(path), wavs, samples)
+		if err != nil {
+			return nil, fmt.Errorf("%s: region %d: %w", path, i+1, err)
+		}
+		instrument.zones = append(instrument.zones, zones...)
+	}
+	if len(instrument.zones) == 0 {
+		return nil, fmt.Errorf("%s: no playable regions", path)
+	}
+	bank.instruments = []sf2Instrument{instrument}
+	bank.presets = []sf2Preset{{name: name}}
+	return bank.soundFont()
+}
+
+// sfzZones converts a region to instrument zones, one per sample channel.
+func (b *sf2Bank) sfzZones(region, control map[string]string, dir string, wavs map[string]*wavSound, samples map[sfzSampleKey][]int) ([]sf2Zone, error) {
+	sample, ok := region["sample"]
+	if !ok {
+		return nil, fmt.Errorf("no sample")
+	}
+	samplePath := filepath.Join(dir, filepath.FromSlash(strings.ReplaceAll(control["default_path"]+sample, `\`, "/")))
+	wav, ok := wavs[samplePath]
+	if !ok {
+		var err error
+		if wav, err = loadWav(samplePath); err != nil {
+			return nil, err
+		}
+		wavs[samplePath] = wav
+	}
+
+	number := func(opcode string, def float64) (float64, error) {
+		value, ok := region[opcode]
+		if !ok {
+			return def, nil
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q", opcode, value)
+		}
+		return v, nil
+	}
+	key := func(opcode string, def int) (int, error) {
+		value, ok := region[opcode]
+		if !ok {
+			return def, nil
+		}
+		k, err := sfzKey(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", opcode, err)
+		}
+		return k, nil
+	}
+
+	var err error
+	z := sf2Zone{keyLow: 0, keyHigh: 127, velLow: 0, velHigh: 127}
+	rootKey := 60
+	if k, ok := region["key"]; ok {
+		if z.keyLow, err = sfzKey(k); err != nil {
+			return nil, fmt.Errorf("invalid key: %w", err)
+		}
+		z.keyHigh, rootKey = z.keyLow, z.keyLow
+	}
+	if z.keyLow, err = key("lokey", z.keyLow); err != nil {
+		return nil, err
+	}
+	if z.keyHigh, err = key("hikey", z.keyHigh); err != nil {
+		return nil, err
+	}
+	if rootKey, err = key("pitch_keycenter", rootKey); err != nil {
+		return nil, err
+	}
+	velLow, err := number("lovel", 0)
+	if err != nil {
+		return nil, err
+	}
+	velHigh, err := number("hivel", 127)
+	if err != nil {
+		return nil, err
+	}
+	z.velLow, z.velHigh = int(max(0, velLow)), int(min(127, velHigh))
+	if z.keyLow > z.keyHigh || z.velLow > z.velHigh {
+		return nil, fmt.Errorf("empty key or velocity range")
+	}
+	z.set(genOverridingRootKey, rootKey)
+
+	transpose, err := number("transpose", 0)
+	if err != nil {
+		return nil, err
+	}
+	tune, err := number("tune", 0)
+	if err != nil {
+		return nil, err
+	}
+	z.set(genCoarseTune, int(transpose)+int(tune)/100)
+	z.set(genFineTune, int(tune)%100)
+
+	volume, err := number("volume", 0)
+	if err != nil {
+		return nil, err
+	}
+	if volume < 0 {
+		z.set(genInitialAttenuation, int(math.Round(-volume*10)))
+	}
+
+	for opcode, gen := range map[string]uint16{
+		"ampeg_attack":  genAttackVolEnv,
+		"ampeg_hold":    genHoldVolEnv,
+		"ampeg_decay":   genDecayVolEnv,
+		"ampeg_release": genReleaseVolEnv,
+	} {
+		if _, ok := region[opcode]; !ok {
+			continue
+		}
+		seconds, err := number(opcode, 0)
+		if err != nil {
+			return nil, err
+		}
+		z.set(gen, timecents(seconds))
+	}
+	if _, ok := region["ampeg_sustain"]; ok {
+		percent, err := number("ampeg_sustain", 100)
+		if err != nil {
+			return nil, err
+		}
+		// Sustain is given as attenuation in centibels; 144 dB is silence.
+		attenuation := 1440
+		if percent > 0 {
+			attenuation = int(math.Round(-200 * math.Log10(min(percent, 100)/100)))
+		}
+		z.set(genSustainVolEnv, attenuation)
+	}
+
+	loopStart, loopEnd := wav.loopStart, wav.loopEnd
+	looped := wav.looped
+	if _, ok := region["loop_start"]; ok {
+		v, err := number("loop_start", 0)
+		if err != nil {
+			return nil, err
+		}
+		loopStart, looped = int(v), true
+	}
+	if _, ok := region["loop_end"]; ok {
+		v, err := number("loop_end", 0)
+		if err != nil {
+			return nil, err
+		}
+		loopEnd, looped = int(v)+1, true
+	}
+	frames := len(wav.channels[0])
+	if loopStart < 0 || loopEnd > frames || loopStart >= loopEnd {
+		loopStart, loopEnd, looped = 0, frames, false
+	}
+	switch region["loop_mode"] {
+	case "loop_continuous":
+		z.set(genSampleModes, 1)
+	case "loop_sustain":
+		z.set(genSampleModes, 3)
+	case "no_loop", "one_shot":
+	case "":
+		if looped {
+			z.set(genSampleModes, 1)
+		}
+	default:
+		return nil, fmt.Errorf("invalid loop_mode %q", region["loop_mode"])
+	}
+
+	id := sfzSampleKey{samplePath, loopStart, loopEnd}
+	indices, ok := samples[id]
+	if !ok {
+		for c, data := range wav.channels {
+			indices = append(indices, len(b.samples))
+			b.samples = append(b.samples, sf2Sample{
+				name:       fmt.Sprintf("%s %d", filepath.Base(samplePath), c+1),
+				data:       data,
+				sampleRate: wav.sampleRate,
+				rootKey:    rootKey,
+				loopStart:  loopStart,
+				loopEnd:    loopEnd,
+			})
+		}
+		samples[id] = indices
+	}
+
+	pan, err := number("pan", 0)
+	if err != nil {
+		return nil, err
+	}
+	zones := make([]sf2Zone, len(indices))
+	for c, index := range indices {
+		zone := z
+		zone.generators = append([]sf2Generator(nil), z.generators...)
+		zone.sample = index
+		// SF2 pan runs from -500 to 500; the channels of a stereo sample are
+		// panned apart, around the region's pan.
+		p := pan * 5
+		if len(indices) == 2 {
+			p += float64(c*2-1) * 500
+		}
+		zone.set(genPan, int(max(-500, min(500, p))))
+		zones[c] = zone
+	}
+	return zones, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// maxControlSmoothing bounds -cc-smoothing, beyond which controllers lag
+// noticeably.
+const maxControlSmoothing = 200 * time.Millisecond
+
+// smoothedControllers are the controllers glided with -cc-smoothing:
+// Channel Volume, Pan and Expression. powerOnControls are their values on
+// reset.
+var (
+	smoothedControllers = [...]int32{0x07, 0x0A, 0x0B}
+	powerOnControls     = [...]int32{100, 64, 127}
+)
+
+// controlSlew glides a controller to its latest value through its 14-bit
+// resolution, instead of the audible steps of 7-bit values.
+type controlSlew struct {
+	set      bool    // whether level is known, otherwise it's the power-on value
+	level    float64 // current 14-bit value
+	from, to float64
+	start    time.Time
+	moving   bool
+}
+
+// smoothControl starts gliding a smoothed controller to a new value. A
+// coarse value sets the whole 14-bit target; a fine one its low 7 bits.
+func (h *midiHandler) smoothControl(channel, cc, value int32) bool {
+	if h.controlSmoothing == 0 {
+		return false
+	}
+	i := slices.Index(smoothedControllers[:], cc&^0x20)
+	if i < 0 {
+		return false
+	}
+	s := &h.slews[channel][i]
+	if !s.set {
+		s.level, s.set = float64(powerOnControls[i]<<7), true
+	}
+	target := int32(math.Round(s.level))
+	if s.moving {
+		target = int32(s.to)
+	}
+	if cc < 0x20 {
+		target = value << 7
+	} else {
+		target = target&^0x7F | value
+	}
+	s.from, s.to, s.start, s.moving = s.level, float64(target), time.Now(), true
+	if !h.smoothing {
+		h.smoothing = true
+		go h.runControlSmoothing()
+	}
+	return true
+}
+
+// runControlSmoothing moves gliding controllers until none is left.
+func (h *midiHandler) runControlSmoothing() {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.mu.Lock()
+		active := false
+		for ch := range int32(channelCount) {
+			for i, cc := range smoothedControllers {
+				s := &h.slews[ch][i]
+				if !s.moving {
+					continue
+				}
+				progress := float64(time.Since(s.start)) / float64(h.controlSmoothing)
+				if progress >= 1 {
+					s.level, s.moving = s.to, false
+				} else {
+					s.level = s.from + (s.to-s.from)*progress
+					active = true
+				}
+				value := int32(math.Round(s.level))
+				for _, target := range h.targetChannels(ch) {
+					h.synthesizer.ProcessMidiMessage(target, 0xB0, cc, value>>7)
+					h.synthesizer.ProcessMidiMessage(target, 0xB0, cc|0x20, value&0x7F)
+				}
+			}
+		}
+		if !active {
+			h.smoothing = false
+			h.mu.Unlock()
+			return
+		}
+		h.mu.Unlock()
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "math"
+
+// softVelocity lowers the velocity of a note played with the soft pedal
+// (CC67) down. With the default modulators of a sound font, a lower
+// velocity is both quieter and darker, like a piano's una corda.
+func (h *midiHandler) softVelocity(channel int32, velocity byte) byte {
+	if !h.softPedal[channel] || h.softAmount == 0 {
+		return velocity
+	}
+	return byte(max(1, math.Round(float64(velocity)*(1-h.softAmount))))
+}
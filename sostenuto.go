@@ -0,0 +1,55 @@
+package main
+
+// sostenutoPedal implements the sostenuto pedal (CC66) of a channel, which
+// the synthesizer doesn't: the notes held when it goes down keep sounding
+// after their keys are released, until it goes up. Notes played while it
+// is down aren't affected.
+type sostenutoPedal struct {
+	down    bool
+	keys    map[byte]bool // keys down
+	latched map[byte]bool // keys down when the pedal went down
+	waiting map[byte]bool // latched keys released while the pedal is down
+}
+
+func (p *sostenutoPedal) press(key byte) {
+	if p.keys == nil {
+		p.keys = make(map[byte]bool)
+	}
+	p.keys[key] = true
+	// The key's Note Off will release the old note as well.
+	delete(p.waiting, key)
+}
+
+// release reports whether a key's Note Off has to wait for the pedal.
+func (p *sostenutoPedal) release(key byte) bool {
+	delete(p.keys, key)
+	if !p.latched[key] {
+		return false
+	}
+	if p.waiting == nil {
+		p.waiting = make(map[byte]bool)
+	}
+	p.waiting[key] = true
+	return true
+}
+
+// sostenuto handles CC66 on a channel.
+func (h *midiHandler) sostenuto(channel int32, down bool) {
+	p := &h.sostenutoPedals[channel]
+	if down == p.down {
+		return
+	}
+	p.down = down
+	if down {
+		p.latched = make(map[byte]bool, len(p.keys))
+		for key := range p.keys {
+			p.latched[key] = true
+		}
+		return
+	}
+	waiting := p.waiting
+	p.latched, p.waiting = nil, nil
+	for key := range waiting {
+		h.noteOff(channel, key)
+	}
+}
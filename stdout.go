@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// pcmWriter streams the rendered audio as raw interleaved stereo PCM, for
+// piping into ffmpeg, aplay or streaming tools. Writes are paced to real
+// time, so live input stays in sync with what the reader hears.
+type pcmWriter struct {
+	out        *bufio.Writer
+	ring       *ringBuffer
+	stats      *audioStats // may be nil
+	sampleRate int
+	format     string    // f32le or s16le
+	dither     *ditherer // for s16le
+}
+
+func newPCMWriter(out io.Writer, ring *ringBuffer, sampleRate int, format string, dither *ditherer) (*pcmWriter, error) {
+	if format != "f32le" && format != "s16le" {
+		return nil, fmt.Errorf("unknown PCM format %q (available: f32le, s16le)", format)
+	}
+	return &pcmWriter{out: bufio.NewWriter(out), ring: ring, sampleRate: sampleRate, format: format, dither: dither}, nil
+}
+
+// run writes blocks of the given size until writing fails, e.g. when the
+// reading end of the pipe closes.
+func (w *pcmWriter) run(blockFrames int) error {
+	samples := make([]float32, 2*blockFrames)
+	var buf []byte
+	var ints []int16
+	start := time.Now()
+	var frames int64
+	for {
+		// Wait until the block is due.
+		due := start.Add(time.Duration(frames * int64(time.Second) / int64(w.sampleRate)))
+		time.Sleep(time.Until(due))
+
+		if got := w.ring.pop(samples); got < len(samples) {
+			clear(samples[got:])
+			if w.stats != nil {
+				w.stats.underrun()
+			}
+		}
+		buf = buf[:0]
+		if w.format == "s16le" {
+			ints = w.dither.int16s(ints[:0], samples)
+			for _, v := range ints {
+				buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+			}
+		} else {
+			for _, v := range samples {
+				buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(v))
+			}
+		}
+		if _, err := w.out.Write(buf); err != nil {
+			return err
+		}
+		if err := w.out.Flush(); err != nil {
+			return err
+		}
+		frames += int64(blockFrames)
+	}
+}
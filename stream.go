@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+)
+
+// audioStream serves the live output over HTTP as an endless 16-bit WAV
+// stream, which players like VLC, ffplay, mpv and browsers can open.
+type audioStream struct {
+	taps       *audioTaps
+	sampleRate int
+	dither     string // dither mode, each listener getting its own ditherer
+}
+
+// listen starts serving the stream in the background.
+func (s *audioStream) listen(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", s.handleStream)
+	mux.HandleFunc("GET /stream.wav", s.handleStream)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			slog.Error("Audio stream stopped", "err", err)
+		}
+	}()
+	return nil
+}
+
+func (s *audioStream) handleStream(w http.ResponseWriter, r *http.Request) {
+	// Take about a second of audio, so a listener on a slow link drops
+	// blocks instead of lagging further and further behind.
+	blocks := s.taps.add(32)
+	defer s.taps.remove(blocks)
+	slog.Info("Stream listener connected", "remote", r.RemoteAddr)
+	defer slog.Info("Stream listener disconnected", "remote", r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Header().Set("Cache-Control", "no-cache")
+	// The length is unknown, so the sizes are set to the largest value,
+	// which players treat as "until the connection closes".
+	if _, err := w.Write(wavHeader(s.sampleRate, 2, 16, math.MaxUint32-36)); err != nil {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+
+	dither, err := newDitherer(s.dither)
+	if err != nil {
+		return
+	}
+	var buf []byte
+	var ints []int16
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case block := <-blocks:
+			buf = buf[:0]
+			ints = dither.int16s(ints[:0], block)
+			for _, v := range ints {
+				buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+			}
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// stuckNotes tracks held keys so that notes whose Note Off got lost can be
+// released. A key counts as stuck once it has been held longer than the
+// timeout with the sustain pedal of its channel up.
+type stuckNotes struct {
+	timeout time.Duration
+	held    map[[2]int32]time.Time // since when a channel and key are held
+	pedal   [channelCount]bool     // sustain pedal down
+}
+
+func newStuckNotes(timeout time.Duration) *stuckNotes {
+	return &stuckNotes{timeout: timeout, held: make(map[[2]int32]time.Time)}
+}
+
+func (s *stuckNotes) noteOn(channel int32, key byte) {
+	s.held[[2]int32{channel, int32(key)}] = time.Now()
+}
+
+func (s *stuckNotes) noteOff(channel int32, key byte) {
+	delete(s.held, [2]int32{channel, int32(key)})
+}
+
+// sustain tracks the pedal. Keys held under the pedal get a fresh timeout
+// when it goes up.
+func (s *stuckNotes) sustain(channel int32, down bool) {
+	if s.pedal[channel] && !down {
+		now := time.Now()
+		for k := range s.held {
+			if k[0] == channel {
+				s.held[k] = now
+			}
+		}
+	}
+	s.pedal[channel] = down
+}
+
+// clear forgets the keys of a channel, or of every channel if channel is
+// negative.
+func (s *stuckNotes) clear(channel int32) {
+	for k := range s.held {
+		if channel < 0 || k[0] == channel {
+			delete(s.held, k)
+		}
+	}
+	if channel < 0 {
+		s.pedal = [channelCount]bool{}
+	}
+}
+
+// watchStuckNotes releases stuck notes until the program exits.
+func (h *midiHandler) watchStuckNotes() {
+	for range time.Tick(max(h.stuck.timeout/4, 100*time.Millisecond)) {
+		h.releaseStuckNotes()
+	}
+}
+
+func (h *midiHandler) releaseStuckNotes() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for k, since := range h.stuck.held {
+		if h.stuck.pedal[k[0]] || time.Since(since) < h.stuck.timeout {
+			continue
+		}
+		slog.Warn("Releasing stuck note", "channel", k[0]+1, "key", k[1], "held", time.Since(since).Round(time.Second))
+		h.noteOff(k[0], byte(k[1]))
+	}
+}
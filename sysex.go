@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+)
+
+// sysExHandler handles one kind of System Exclusive message.
+type sysExHandler struct {
+	name   string
+	match  func(msg []byte) bool
+	handle func(h *midiHandler, msg []byte)
+}
+
+// sysExHandlers are tried in order; the first that matches a message
+// handles it. Messages arrive complete, from F0 to F7, even when the
+// interface splits them into several packets.
+var sysExHandlers = []sysExHandler{
+	{"MIDI Tuning Standard", isMtsMessage, (*midiHandler).handleMts},
+	{"GM Master Volume", isMasterVolume, (*midiHandler).handleMasterVolume},
+	{"GM System On", isGMSystemOn, func(h *midiHandler, _ []byte) { h.resetChannels("GM System On") }},
+	{"GS Reset", isGSReset, func(h *midiHandler, _ []byte) { h.resetChannels("GS Reset") }},
+	{"XG System On", isXGSystemOn, func(h *midiHandler, _ []byte) { h.resetChannels("XG System On") }},
+	{"GS rhythm part", isGSRhythmPart, (*midiHandler).handleGSRhythmPart},
+}
+
+// handleSysEx processes System Exclusive messages.
+func (h *midiHandler) handleSysEx(msg []byte) {
+	for _, handler := range sysExHandlers {
+		if handler.match(msg) {
+			handler.handle(h, msg)
+			return
+		}
+	}
+	slog.Debug("Unhandled SysEx", "bytes", fmt.Sprintf("% X", msg))
+}
+
+// handleMts applies MIDI Tuning Standard messages through the retuner, if
+// that is enabled.
+func (h *midiHandler) handleMts(msg []byte) {
+	if !h.mts {
+		slog.Debug("Ignoring MTS message (retuning is off, see -mts)")
+		return
+	}
+	if h.mpe != nil {
+		// MPE already uses every channel the retuner would need.
+		return
+	}
+	if h.tuning == nil {
+		// Notes started before the switch can't be released through
+		// the retuner, so release them now.
+		h.synthesizer.NoteOffAll(false)
+		h.useTuning(equalTemperament())
+	}
+	if err := applyMtsMessage(h.tuning.table, msg); err != nil {
+		slog.Warn("Ignoring MTS message", "err", err)
+		return
+	}
+	slog.Info("Tuning updated by MTS message")
+}
+
+// handleMasterVolume sets the master volume from its 14-bit value.
+func (h *midiHandler) handleMasterVolume(msg []byte) {
+	value := int32(msg[6])<<7 | int32(msg[5])
+	h.synthesizer.MasterVolume = float32(value) / 16383
+	slog.Info("Master volume", "value", value)
+}
+
+// handleGSRhythmPart switches a part between drums and instruments.
+func (h *midiHandler) handleGSRhythmPart(msg []byte) {
+	channel, drums := gsRhythmPart(msg)
+	h.setDrumChannel(channel, drums)
+	slog.Info("GS part mode", "channel", channel+1, "rhythm", drums)
+}
+
+// resetChannels returns every channel to its power-on state, like a sound
+// module does when a sequencer sends a system reset at the start of a song.
+func (h *midiHandler) resetChannels(reason string) {
+	h.synthesizer.Reset()
+	h.applyDrumChannels()
+	h.rpn = defaultRpnStates()
+	h.mix = defaultChannelMixes()
+	h.bankSel = [channelCount]bankSelect{}
+	h.keyPressure = [channelCount]map[int32]int32{}
+	h.sostenutoPedals = [channelCount]sostenutoPedal{}
+	h.softPedal = [channelCount]bool{}
+	h.portamento = [channelCount]portamento{}
+	h.modulation = [channelCount]int32{}
+	h.modPressure = [channelCount]int32{}
+	h.monoMode = h.monoDefault
+	h.mono = [channelCount]monoChannel{}
+	h.slews = [channelCount][len(smoothedControllers)]controlSlew{}
+	if h.stuck != nil {
+		h.stuck.clear(-1)
+	}
+	if h.humanize != nil {
+		h.humanize.clear()
+	}
+	if h.mpe != nil {
+		h.mpe = newMpeZone(h.synthesizer, h.mpe.bendRange)
+	}
+	if h.tuning != nil {
+		h.tuning.reset()
+	}
+	if h.collapse != nil {
+		h.configureCollapse()
+	}
+	h.applyBendRanges()
+	if h.masterTune != 0 {
+		h.applyMasterTuning()
+	}
+	h.applyZoneSettings()
+	slog.Info("Channels reset", "reason", reason)
+}
+
+// setDrumChannel switches a channel between the drum bank and bank 0.
+func (h *midiHandler) setDrumChannel(channel int32, drums bool) {
+	bank := int32(0)
+	if drums {
+		bank = 128
+	}
+	h.banks[channel] = bank
+	selectBank(h.synthesizer, channel, bank)
+}
+
+// isGMSystemOn matches GM1 and GM2 System On: F0 7E <dev> 09 01|03 F7.
+func isGMSystemOn(msg []byte) bool {
+	return len(msg) >= 5 && msg[1] == 0x7E && msg[3] == 0x09 && (msg[4] == 0x01 || msg[4] == 0x03)
+}
+
+// isMasterVolume matches the universal real-time Master Volume:
+// F0 7F <dev> 04 01 <lsb> <msb> F7.
+func isMasterVolume(msg []byte) bool {
+	return len(msg) >= 7 && msg[1] == 0x7F && msg[3] == 0x04 && msg[4] == 0x01
+}
+
+// isGSReset matches F0 41 <dev> 42 12 40 00 7F 00 41 F7.
+func isGSReset(msg []byte) bool {
+	return len(msg) >= 10 && msg[1] == 0x41 && msg[3] == 0x42 && msg[4] == 0x12 &&
+		bytes.Equal(msg[5:9], []byte{0x40, 0x00, 0x7F, 0x00})
+}
+
+// isXGSystemOn matches F0 43 1n 4C 00 00 7E 00 F7.
+func isXGSystemOn(msg []byte) bool {
+	return len(msg) >= 8 && msg[1] == 0x43 && msg[2]&0xF0 == 0x10 && msg[3] == 0x4C &&
+		bytes.Equal(msg[4:8], []byte{0x00, 0x00, 0x7E, 0x00})
+}
+
+// isGSRhythmPart matches the GS "use for rhythm part" parameter:
+// F0 41 <dev> 42 12 40 1x 15 <map> <checksum> F7.
+func isGSRhythmPart(msg []byte) bool {
+	return len(msg) >= 9 && msg[1] == 0x41 && msg[3] == 0x42 && msg[4] == 0x12 &&
+		msg[5] == 0x40 && msg[6]&0xF0 == 0x10 && msg[7] == 0x15
+}
+
+// gsRhythmPart decodes the channel and drum flag of a rhythm part message.
+// GS numbers its parts so that block 0 is part 10, blocks 1-9 are parts 1-9
+// and blocks A-F are parts 11-16.
+func gsRhythmPart(msg []byte) (int32, bool) {
+	block := int32(msg[6] & 0x0F)
+	channel := block - 1
+	if block == 0 {
+		channel = percussionChannel
+	} else if block >= 0x0A {
+		channel = block
+	}
+	return channel, msg[8] != 0
+}
@@ -0,0 +1,46 @@
+package main
+
+import "sync"
+
+// audioTaps hands copies of the rendered audio to listeners such as network
+// streams. A listener that falls behind loses blocks rather than stalling
+// the renderer.
+type audioTaps struct {
+	mu        sync.Mutex
+	listeners map[chan []float32]struct{}
+}
+
+// add registers a listener that can queue up to buffer blocks.
+func (t *audioTaps) add(buffer int) chan []float32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.listeners == nil {
+		t.listeners = make(map[chan []float32]struct{})
+	}
+	ch := make(chan []float32, buffer)
+	t.listeners[ch] = struct{}{}
+	return ch
+}
+
+func (t *audioTaps) remove(ch chan []float32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.listeners, ch)
+}
+
+// send gives a block of interleaved stereo samples to every listener. The
+// listeners share one copy, which they must not modify.
+func (t *audioTaps) send(block []float32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.listeners) == 0 {
+		return
+	}
+	shared := append([]float32(nil), block...)
+	for ch := range t.listeners {
+		select {
+		case ch <- shared:
+		default:
+		}
+	}
+}
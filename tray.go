@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"fyne.io/systray"
+)
+
+// soundFontExtensions are the files offered in the tray's sound font menu.
+var soundFontExtensions = []string{".sf2", ".sf3", ".sfz", ".dls"}
+
+// trayMenu is the system tray icon, with quick actions for running the
+// synth minimized.
+type trayMenu struct {
+	handler  *midiHandler
+	renderer *renderer
+	ports    []string        // MIDI input ports, empty without MIDI input
+	port     int             // open port
+	openPort func(int) error // switches the MIDI input port
+}
+
+// run shows the tray icon. It blocks until systray.Quit is called, from
+// the menu or elsewhere.
+func (t *trayMenu) run() {
+	systray.Run(t.ready, nil)
+}
+
+func (t *trayMenu) ready() {
+	systray.SetIcon(trayIcon())
+	systray.SetTitle("meltysynth")
+	systray.SetTooltip("meltysynth-test MIDI synthesizer")
+
+	onClick(systray.AddMenuItem("Panic", "Silence all notes"), t.handler.panic)
+	mute := systray.AddMenuItemCheckbox("Mute", "Mute the audio output", false)
+	onClick(mute, func() {
+		if mute.Checked() {
+			mute.Uncheck()
+			t.renderer.muted.Store(false)
+		} else {
+			mute.Check()
+			t.renderer.muted.Store(true)
+		}
+	})
+	systray.AddSeparator()
+
+	if len(t.ports) > 0 {
+		menu := systray.AddMenuItem("MIDI input", "Choose the MIDI input port")
+		items := make([]*systray.MenuItem, len(t.ports))
+		for i, name := range t.ports {
+			items[i] = menu.AddSubMenuItemCheckbox(name, "", i == t.port)
+			onClick(items[i], func() {
+				if err := t.openPort(i); err != nil {
+					slog.Warn("Failed to open MIDI port", "port", i, "err", err)
+					return
+				}
+				slog.Info("MIDI input", "port", i, "name", name)
+				t.port = i
+				checkOnly(items, i)
+			})
+		}
+	}
+
+	if files := t.handler.nearbySoundFonts(); len(files) > 0 {
+		menu := systray.AddMenuItem("Sound font", "Choose the sound font")
+		items := make([]*systray.MenuItem, len(files))
+		current := t.handler.soundFontPath()
+		for i, path := range files {
+			items[i] = menu.AddSubMenuItemCheckbox(filepath.Base(path), path, path == current)
+			onClick(items[i], func() {
+				if err := t.handler.switchSoundFont(path, t.renderer); err != nil {
+					slog.Warn("Failed to load sound font", "err", err)
+					return
+				}
+				checkOnly(items, i)
+			})
+		}
+	}
+
+	systray.AddSeparator()
+	onClick(systray.AddMenuItem("Quit", "Quit the synthesizer"), systray.Quit)
+}
+
+// soundFontPath returns the absolute path of the current sound font, or ""
+// for downloaded and built-in ones.
+func (h *midiHandler) soundFontPath() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.soundFont == "" || isURL(h.soundFont) {
+		return ""
+	}
+	path, err := filepath.Abs(h.soundFont)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// soundFonts lists the sound fonts next to the current one, or in the
+// working directory.
+func (h *midiHandler) nearbySoundFonts() []string {
+	dir := "."
+	if current := h.soundFontPath(); current != "" {
+		dir = filepath.Dir(current)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && slices.Contains(soundFontExtensions, strings.ToLower(filepath.Ext(e.Name()))) {
+			if path, err := filepath.Abs(filepath.Join(dir, e.Name())); err == nil {
+				files = append(files, path)
+			}
+		}
+	}
+	return files
+}
+
+// onClick calls f whenever a menu item is clicked.
+func onClick(item *systray.MenuItem, f func()) {
+	go func() {
+		for range item.ClickedCh {
+			f()
+		}
+	}()
+}
+
+// checkOnly checks one item of a list of choices.
+func checkOnly(items []*systray.MenuItem, checked int) {
+	for i, item := range items {
+		if i == checked {
+			item.Check()
+		} else {
+			item.Uncheck()
+		}
+	}
+}
+
+// trayIcon draws the tray icon, a few piano keys. Windows wants an ICO
+// file, which may hold a PNG image.
+func trayIcon() []byte {
+	const size = 32
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	background := color.NRGBA{0x30, 0x50, 0xA0, 0xFF}
+	for y := range size {
+		for x := range size {
+			c := background
+			if y >= 4 && y < 28 && x >= 4 && x < 28 && (x-4)%6 != 5 {
+				c = color.NRGBA{0xFF, 0xFF, 0xFF, 0xFF} // white key
+				if y < 18 && (x-4)%6 >= 3 && x < 26 && (x-4)/6 != 2 {
+					c = color.NRGBA{0x10, 0x10, 0x10, 0xFF} // black key
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	if runtime.GOOS != "windows" {
+		return buf.Bytes()
+	}
+
+	var ico bytes.Buffer
+	binary.Write(&ico, binary.LittleEndian, []uint16{0, 1, 1})
+	ico.Write([]byte{size, size, 0, 0})
+	binary.Write(&ico, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(&ico, binary.LittleEndian, []uint32{uint32(buf.Len()), 22})
+	ico.Write(buf.Bytes())
+	return ico.Bytes()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"math"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// tuning holds the pitch of every MIDI key as a fractional MIDI note number,
+// e.g. 69.0 for A4 at 440 Hz. NaN marks keys that should not sound.
+type tuning [128]float64
+
+// equalTemperament returns the standard 12-TET tuning.
+func equalTemperament() *tuning {
+	t := new(tuning)
+	for key := range t {
+		t[key] = float64(key)
+	}
+	return t
+}
+
+// tunedChannel is a synthesizer channel used by the retuner.
+type tunedChannel struct {
+	index    int32
+	cents    float64   // fine tune currently applied to the channel, NaN if unknown
+	preset   presetRef // preset of the notes sounding on the channel
+	held     int       // notes currently sounding on the channel
+	lastUsed uint64
+}
+
+// tunedNote remembers where an incoming key was played.
+type tunedNote struct {
+	channel *tunedChannel
+	key     int32
+}
+
+// retuner plays notes at arbitrary pitches. meltysynth can only tune whole
+// channels, so every note is played on the nearest key of a channel whose
+// fine tune matches the remaining cents, rotating through the channels. The
+// channel gets the preset of the incoming channel, so every part keeps its
+// instrument; controllers are shared by all of them.
+type retuner struct {
+	synthesizer *meltysynth.Synthesizer
+	table       *tuning
+	offset      float64                 // master tuning in semitones, added to the table
+	drums       *[channelCount]bool     // channels played as they are, never used for notes
+	presets     [channelCount]presetRef // preset of every incoming channel
+	channels    []*tunedChannel
+	notes       map[[2]int32]tunedNote // by incoming channel and key
+	clock       uint64
+}
+
+// newRetuner plays notes on the channels that aren't drum channels, which
+// keep their notes and tuning. offset shifts every pitch, in semitones.
+func newRetuner(synthesizer *meltysynth.Synthesizer, table *tuning, offset float64, drums *[channelCount]bool) *retuner {
+	r := &retuner{
+		synthesizer: synthesizer,
+		table:       table,
+		offset:      offset,
+		drums:       drums,
+		notes:       make(map[[2]int32]tunedNote),
+	}
+	for ch := range int32(channelCount) {
+		r.channels = append(r.channels, &tunedChannel{index: ch, cents: math.NaN()})
+	}
+	return r
+}
+
+// setPreset sets the preset that notes of an incoming channel play with.
+func (r *retuner) setPreset(channel int32, preset presetRef) {
+	r.presets[channel] = preset
+}
+
+// noteOn plays key of an incoming channel at its tuned pitch.
+func (r *retuner) noteOn(channel int32, key, velocity byte) {
+	r.noteOff(channel, key)
+
+	pitch := r.table[key] + r.offset
+	if math.IsNaN(pitch) {
+		return
+	}
+	synthKey := math.Round(pitch)
+	if synthKey < 0 || synthKey > 127 {
+		return
+	}
+	cents := (pitch - synthKey) * 100
+
+	preset := r.presets[channel]
+	ch := r.allocate(cents, preset)
+	if ch == nil {
+		return
+	}
+	if ch.held == 0 || ch.preset != preset {
+		// An idle channel's preset may have been changed since, e.g. by
+		// a GS part mode message, so it is always selected again.
+		selectBank(r.synthesizer, ch.index, preset.bank)
+		r.synthesizer.ProcessMidiMessage(ch.index, 0xC0, preset.program, 0)
+		ch.preset = preset
+	}
+	if ch.cents != cents {
+		setFineTune(r.synthesizer, ch.index, cents)
+		ch.cents = cents
+	}
+	r.clock++
+	ch.lastUsed = r.clock
+	ch.held++
+	r.notes[[2]int32{channel, int32(key)}] = tunedNote{channel: ch, key: int32(synthKey)}
+	r.synthesizer.NoteOn(ch.index, int32(synthKey), int32(velocity))
+}
+
+// noteOff releases key of an incoming channel if it is playing.
+func (r *retuner) noteOff(channel int32, key byte) {
+	id := [2]int32{channel, int32(key)}
+	note, ok := r.notes[id]
+	if !ok {
+		return
+	}
+	delete(r.notes, id)
+	note.channel.held--
+	r.synthesizer.NoteOff(note.channel.index, note.key)
+}
+
+// lookup returns the synthesizer channel and key a playing key of an
+// incoming channel sounds on.
+func (r *retuner) lookup(channel int32, key byte) (int32, int32, bool) {
+	note, ok := r.notes[[2]int32{channel, int32(key)}]
+	if !ok {
+		return 0, 0, false
+	}
+	return note.channel.index, note.key, true
+}
+
+// channelIndexes returns the synthesizer channels notes may be played on.
+func (r *retuner) channelIndexes() []int32 {
+	var indexes []int32
+	for _, ch := range r.channels {
+		if !r.drums[ch.index] {
+			indexes = append(indexes, ch.index)
+		}
+	}
+	return indexes
+}
+
+// reset forgets all notes and channel tunings, for use after the
+// synthesizer channels have been reset.
+func (r *retuner) reset() {
+	clear(r.notes)
+	r.presets = [channelCount]presetRef{}
+	for _, ch := range r.channels {
+		ch.cents = math.NaN()
+		ch.held = 0
+	}
+}
+
+// allocate picks a channel for a note with a preset, detuned by cents.
+// Channels already playing that preset tuned that way are shared; otherwise
+// the least recently used idle channel is retuned, so release tails of
+// earlier notes keep their pitch as long as possible. When every channel is
+// busy the least recently used one is taken. Drum channels are skipped; it
+// returns nil if every channel is one.
+func (r *retuner) allocate(cents float64, preset presetRef) *tunedChannel {
+	var idle, oldest *tunedChannel
+	for _, ch := range r.channels {
+		if r.drums[ch.index] {
+			continue
+		}
+		if ch.held > 0 && ch.preset == preset && math.Abs(ch.cents-cents) < 0.01 {
+			return ch
+		}
+		if ch.held == 0 && (idle == nil || ch.lastUsed < idle.lastUsed) {
+			idle = ch
+		}
+		if oldest == nil || ch.lastUsed < oldest.lastUsed {
+			oldest = ch
+		}
+	}
+	if idle != nil {
+		return idle
+	}
+	return oldest
+}
+
+// useTuning plays the melodic channels through a retuner for table,
+// starting from their current presets.
+func (h *midiHandler) useTuning(table *tuning) {
+	h.tuning = newRetuner(h.synthesizer, table, h.masterTune, &h.drums)
+	for ch := range int32(channelCount) {
+		h.tuning.setPreset(ch, presetRef{h.banks[ch], h.mix[ch].program})
+	}
+}
+
+// retuned reports whether notes of an incoming channel go through the
+// retuner. Drum channels never do.
+func (h *midiHandler) retuned(channel int32) bool {
+	return h.tuning != nil && !h.drums[channel]
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// umpPacketWords is the length in 32-bit words of a Universal MIDI Packet,
+// by message type.
+var umpPacketWords = [16]int{1, 1, 1, 2, 2, 4, 1, 1, 2, 2, 2, 3, 3, 4, 4, 4}
+
+// umpDecoder translates Universal MIDI Packets (MIDI 2.0) into MIDI 1.0
+// messages for the synthesizer. High-resolution values are scaled down;
+// per-note controllers, per-note pitch bend and relative controllers have
+// no MIDI 1.0 equivalent and are dropped. All groups play the same 16
+// channels.
+type umpDecoder struct {
+	words []uint32 // packet in progress
+	sysex []byte   // System Exclusive message in progress
+}
+
+// feed decodes words and calls emit for every MIDI 1.0 message.
+func (d *umpDecoder) feed(words []uint32, emit func([]byte)) {
+	for _, w := range words {
+		d.words = append(d.words, w)
+		if len(d.words) < umpPacketWords[d.words[0]>>28] {
+			continue
+		}
+		d.decode(d.words, emit)
+		d.words = d.words[:0]
+	}
+}
+
+func (d *umpDecoder) decode(p []uint32, emit func([]byte)) {
+	status := byte(p[0] >> 16)
+	b2, b3 := byte(p[0]>>8)&0x7F, byte(p[0])&0x7F
+	switch p[0] >> 28 {
+	case 0x1, 0x2: // System Real-Time and Common, MIDI 1.0 Channel Voice
+		if status >= 0x80 && status != 0xF0 && status != 0xF7 {
+			emit([]byte{status, b2, b3}[:1+messageDataLength(status)])
+		}
+	case 0x3: // 7-bit System Exclusive, up to six bytes a packet
+		d.decodeSysEx(p, emit)
+	case 0x4: // MIDI 2.0 Channel Voice
+		d.decodeChannelVoice(p, emit)
+	}
+}
+
+// decodeSysEx collects the pieces of a System Exclusive message.
+func (d *umpDecoder) decodeSysEx(p []uint32, emit func([]byte)) {
+	form := p[0] >> 20 & 0xF
+	n := int(p[0] >> 16 & 0xF)
+	data := []byte{byte(p[0] >> 8), byte(p[0]), byte(p[1] >> 24), byte(p[1] >> 16), byte(p[1] >> 8), byte(p[1])}
+	if form == 0 || form == 1 { // complete, start
+		d.sysex = []byte{0xF0}
+	} else if d.sysex == nil {
+		return // continuation without a start
+	}
+	d.sysex = append(d.sysex, data[:min(n, len(data))]...)
+	if len(d.sysex) > maxSysExLength {
+		d.sysex = nil
+		return
+	}
+	if form == 0 || form == 3 { // complete, end
+		emit(append(d.sysex, 0xF7))
+		d.sysex = nil
+	}
+}
+
+// decodeChannelVoice scales a MIDI 2.0 channel voice message down to MIDI
+// 1.0.
+func (d *umpDecoder) decodeChannelVoice(p []uint32, emit func([]byte)) {
+	status := byte(p[0] >> 16)
+	channel := status & 0x0F
+	index, lsb := byte(p[0]>>8)&0x7F, byte(p[0])&0x7F
+	value := p[1]
+	switch status & 0xF0 {
+	case 0x80: // Note Off
+		emit([]byte{status, index, byte(value >> 25)})
+	case 0x90: // Note On; velocity 0 is a note, not a Note Off
+		emit([]byte{status, index, max(1, byte(value>>25))})
+	case 0xA0: // Poly Pressure
+		emit([]byte{status, index, byte(value >> 25)})
+	case 0xB0: // Control Change
+		emit([]byte{status, index, byte(value >> 25)})
+	case 0xC0: // Program Change, with an optional bank
+		if p[0]&1 != 0 {
+			emit([]byte{0xB0 | channel, 0x00, byte(value>>8) & 0x7F})
+			emit([]byte{0xB0 | channel, 0x20, byte(value) & 0x7F})
+		}
+		emit([]byte{status, byte(value>>24) & 0x7F})
+	case 0xD0: // Channel Pressure
+		emit([]byte{status, byte(value >> 25)})
+	case 0xE0: // Pitch Bend
+		bend := value >> 18
+		emit([]byte{status, byte(bend) & 0x7F, byte(bend >> 7)})
+	case 0x20, 0x30: // Registered and Assignable (NRPN) Controller
+		msb, lsbCC := byte(0x65), byte(0x64)
+		if status&0xF0 == 0x30 {
+			msb, lsbCC = 0x63, 0x62
+		}
+		data := value >> 18
+		emit([]byte{0xB0 | channel, msb, index})
+		emit([]byte{0xB0 | channel, lsbCC, lsb})
+		emit([]byte{0xB0 | channel, 0x06, byte(data>>7) & 0x7F})
+		emit([]byte{0xB0 | channel, 0x26, byte(data) & 0x7F})
+	default:
+		slog.Debug("Dropping MIDI 2.0 message without a MIDI 1.0 equivalent", "status", status)
+	}
+}
+
+// openUmpInput reads Universal MIDI Packets from a device, such as an ALSA
+// UMP device (/dev/snd/umpC1D0) on Linux 6.5 and later, and plays them.
+// The decoder emits whole MIDI 1.0 messages, so play needs no parser.
+func openUmpInput(path string, play func([]byte)) (io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		var d umpDecoder
+		buf := make([]byte, 1024)
+		words := make([]uint32, 0, len(buf)/4)
+		pending := 0
+		for {
+			n, err := f.Read(buf[pending:])
+			if err != nil {
+				if err != io.EOF {
+					slog.Warn("MIDI 2.0 input stopped", "path", path, "err", err)
+				}
+				return
+			}
+			n += pending
+			words = words[:0]
+			for i := 0; i+4 <= n; i += 4 {
+				words = append(words, binary.NativeEndian.Uint32(buf[i:]))
+			}
+			pending = copy(buf, buf[4*len(words):n])
+			d.feed(words, play)
+		}
+	}()
+	return f, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// vibratoStart is the phase reference of the vibrato LFO.
+var vibratoStart = time.Now()
+
+// modWheel handles CC1 when the handler makes the vibrato itself. Under a
+// tuning table, which owns fine tuning, the sound font's own modulation
+// handles it instead.
+func (h *midiHandler) modWheel(channel, value int32) bool {
+	return h.setModulation(channel, &h.modulation[channel], value)
+}
+
+// modulationPressure handles aftertouch routed to modulation the same way
+// as the mod wheel. The deeper of the two sets the vibrato.
+func (h *midiHandler) modulationPressure(channel, pressure int32) bool {
+	return h.setModulation(channel, &h.modPressure[channel], pressure)
+}
+
+// setModulation stores a modulation source of a channel if the handler
+// makes the vibrato, reporting whether it does.
+func (h *midiHandler) setModulation(channel int32, source *int32, value int32) bool {
+	if h.vibratoDepth == 0 || h.retuned(channel) {
+		return false
+	}
+	*source = value
+	if h.modDepth(channel) == 0 {
+		h.updatePitch(channel)
+	}
+	h.startPitchModulation()
+	return true
+}
+
+// modDepth returns the vibrato depth of a channel, 0-127.
+func (h *midiHandler) modDepth(channel int32) int32 {
+	return max(h.modulation[channel], h.modPressure[channel])
+}
+
+// vibrato returns the current vibrato of a channel in semitones.
+func (h *midiHandler) vibrato(channel int32) float64 {
+	depth := h.modDepth(channel)
+	if depth == 0 {
+		return 0
+	}
+	phase := 2 * math.Pi * h.vibratoRate * time.Since(vibratoStart).Seconds()
+	return h.vibratoDepth / 100 * float64(depth) / 127 * math.Sin(phase)
+}
+
+// startPitchModulation starts moving the pitch of channels that glide or
+// have vibrato, unless that is already running.
+func (h *midiHandler) startPitchModulation() {
+	if !h.modulating {
+		h.modulating = true
+		go h.runPitchModulation()
+	}
+}
+
+// runPitchModulation updates the glides and vibrato until no channel has
+// either.
+func (h *midiHandler) runPitchModulation() {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for range ticker.C {
+		h.mu.Lock()
+		active := false
+		for ch := range int32(channelCount) {
+			p := &h.portamento[ch]
+			if p.gliding {
+				progress := float64(time.Since(p.start)) / float64(p.length)
+				if progress >= 1 {
+					p.gliding, p.offset = false, 0
+				} else {
+					p.offset = p.from * (1 - progress)
+					active = true
+				}
+			} else if h.modDepth(ch) == 0 {
+				continue
+			}
+			if h.modDepth(ch) != 0 {
+				active = true
+			}
+			h.updatePitch(ch)
+		}
+		if !active {
+			h.modulating = false
+			h.mu.Unlock()
+			return
+		}
+		h.mu.Unlock()
+	}
+}
+
+// updatePitch detunes a channel by its legato shift, glide and vibrato on
+// top of its transpose and the master tuning, through coarse and fine
+// tuning. The retuner owns the fine tuning of its channels and applies the
+// master tuning to every note, so retuned channels only get the transpose.
+func (h *midiHandler) updatePitch(channel int32) {
+	if h.retuned(channel) {
+		for _, ch := range h.targetChannels(channel) {
+			sendRpn(h.synthesizer, ch, 2, h.mix[channel].transpose+64, 0)
+		}
+		return
+	}
+	total := float64(h.mix[channel].transpose) + h.masterTune + h.mono[channel].shift + h.portamento[channel].offset + h.vibrato(channel)
+	coarse := math.Floor(total)
+	for _, ch := range h.targetChannels(channel) {
+		sendRpn(h.synthesizer, ch, 2, int32(coarse)+64, 0)
+		setFineTune(h.synthesizer, ch, (total-coarse)*100)
+	}
+}
+
+// applyMasterTuning tunes every channel to the master tuning. Under a
+// tuning table, the retuner applies it to every retuned note instead.
+func (h *midiHandler) applyMasterTuning() {
+	for ch := range int32(channelCount) {
+		if !h.retuned(ch) {
+			h.updatePitch(ch)
+		}
+	}
+}
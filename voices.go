@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/ezmidi/go-meltysynth/meltysynth"
+)
+
+// activeVoices returns the number of voices the synthesizer is playing.
+// meltysynth keeps the count private, so it is read through reflection.
+func activeVoices(synthesizer *meltysynth.Synthesizer) int {
+	voices := reflect.ValueOf(synthesizer).Elem().FieldByName("voices")
+	if voices.IsNil() {
+		return 0
+	}
+	return int(voices.Elem().FieldByName("activeVoiceCount").Int())
+}
+
+// voiceMeter tracks voice usage. The renderer updates it between blocks,
+// reading the count under the handler's lock.
+type voiceMeter struct {
+	maximum  int
+	active   atomic.Int64
+	peak     atomic.Int64 // since the last report
+	lastWarn time.Time    // only used by the renderer
+}
+
+func newVoiceMeter(maximum int) *voiceMeter {
+	return &voiceMeter{maximum: maximum}
+}
+
+// update records the current voice count, warning when voices are about to
+// be stolen.
+func (m *voiceMeter) update(active int) {
+	m.active.Store(int64(active))
+	for {
+		peak := m.peak.Load()
+		if int64(active) <= peak || m.peak.CompareAndSwap(peak, int64(active)) {
+			break
+		}
+	}
+	if active*10 >= m.maximum*9 && time.Since(m.lastWarn) > time.Second {
+		m.lastWarn = time.Now()
+		slog.Warn("Running out of voices; new notes steal old ones", "active", active, "maximum", m.maximum)
+	}
+}
+
+// report returns the current and peak voice counts, and starts a new peak
+// measurement.
+func (m *voiceMeter) report() (active, peak int) {
+	return int(m.active.Load()), int(m.peak.Swap(m.active.Load()))
+}
+
+// printEvery prints the voice count at every interval, in the background.
+func (m *voiceMeter) printEvery(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			active, peak := m.report()
+			slog.Info("Voices", "active", active, "maximum", m.maximum, "peak", peak)
+		}
+	}()
+}
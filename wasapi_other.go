@@ -0,0 +1,25 @@
+//go:build !windows || !(amd64 || arm64)
+
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// wasapiOutput is only available on 64-bit Windows.
+type wasapiOutput struct{}
+
+func openWasapiExclusive(sampleRate int, period time.Duration) (*wasapiOutput, error) {
+	return nil, fmt.Errorf("WASAPI exclusive mode is only available on 64-bit Windows")
+}
+
+func (o *wasapiOutput) sampleRate() int { return 0 }
+
+func (o *wasapiOutput) bufferFrames() int { return 0 }
+
+func (o *wasapiOutput) play(ring *ringBuffer, stats *audioStats) {}
+
+func listWasapiDevices() ([]string, error) {
+	return nil, fmt.Errorf("WASAPI is only available on 64-bit Windows")
+}
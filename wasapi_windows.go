@@ -0,0 +1,429 @@
+//go:build windows && (amd64 || arm64)
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	ole32                             = syscall.NewLazyDLL("ole32.dll")
+	procCoInitializeEx                = ole32.NewProc("CoInitializeEx")
+	procCoCreateInstance              = ole32.NewProc("CoCreateInstance")
+	procCoTaskMemFree                 = ole32.NewProc("CoTaskMemFree")
+	procPropVariantClear              = ole32.NewProc("PropVariantClear")
+	kernel32                          = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventW                  = kernel32.NewProc("CreateEventW")
+	avrt                              = syscall.NewLazyDLL("avrt.dll")
+	procAvSetMmThreadCharacteristicsW = avrt.NewProc("AvSetMmThreadCharacteristicsW")
+)
+
+type guid struct {
+	data1        uint32
+	data2, data3 uint16
+	data4        [8]byte
+}
+
+var (
+	clsidMMDeviceEnumerator = guid{0xBCDE0395, 0xE52F, 0x467C, [8]byte{0x8E, 0x3D, 0xC4, 0x57, 0x92, 0x91, 0x69, 0x2E}}
+	iidIMMDeviceEnumerator  = guid{0xA95664D2, 0x9614, 0x4F35, [8]byte{0xA7, 0x46, 0xDE, 0x8D, 0xB6, 0x36, 0x17, 0xE6}}
+	iidIAudioClient         = guid{0x1CB9AD4C, 0xDBFA, 0x4C32, [8]byte{0xB1, 0x78, 0xC2, 0xF5, 0x68, 0xA7, 0x03, 0xB2}}
+	iidIAudioRenderClient   = guid{0xF294ACFC, 0x3146, 0x4483, [8]byte{0xA7, 0xBF, 0xAD, 0xDC, 0xA7, 0xC2, 0x60, 0xE2}}
+	pkeyDeviceFriendlyName  = propertyKey{guid{0xA45C254E, 0xDF1C, 0x4EFD, [8]byte{0x80, 0x20, 0x67, 0xD1, 0x46, 0xA8, 0x50, 0xE0}}, 14}
+	subtypeFloat            = guid{0x00000003, 0x0000, 0x0010, [8]byte{0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}}
+	subtypePCM              = guid{0x00000001, 0x0000, 0x0010, [8]byte{0x80, 0x00, 0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71}}
+)
+
+// propertyKey is PROPERTYKEY.
+type propertyKey struct {
+	fmtid guid
+	pid   uint32
+}
+
+// propVariant is PROPVARIANT, holding a string.
+type propVariant struct {
+	vt       uint16
+	reserved [3]uint16
+	value    *uint16
+	padding  uintptr
+}
+
+const (
+	clsctxAll                   = 0x17
+	eRender                     = 0
+	deviceStateActive           = 0x1
+	stgmRead                    = 0
+	audclntShareModeExclusive   = 1
+	audclntStreamFlagsEvent     = 0x00040000
+	audclntBufferFlagsSilent    = 0x2
+	audclntBufferSizeNotAligned = 0x88890019
+)
+
+// Method indices in the COM vtables.
+const (
+	methodRelease                 = 2
+	methodEnumAudioEndpoints      = 3 // IMMDeviceEnumerator
+	methodGetDefaultAudioEndpoint = 4
+	methodGetCount                = 3 // IMMDeviceCollection
+	methodItem                    = 4
+	methodActivate                = 3 // IMMDevice
+	methodOpenPropertyStore       = 4
+	methodGetId                   = 5
+	methodGetValue                = 5 // IPropertyStore
+	methodInitialize              = 3 // IAudioClient
+	methodGetBufferSize           = 4
+	methodIsFormatSupported       = 7
+	methodGetDevicePeriod         = 9
+	methodStart                   = 10
+	methodSetEventHandle          = 13
+	methodGetService              = 14
+	methodGetBuffer               = 3 // IAudioRenderClient
+	methodReleaseBuffer           = 4
+)
+
+// comObject is a COM interface pointer.
+type comObject struct {
+	p unsafe.Pointer
+}
+
+// call invokes a vtable method and converts a failed HRESULT to an error.
+func (o comObject) call(name string, method int, args ...uintptr) error {
+	vtable := *(*unsafe.Pointer)(o.p)
+	fn := *(*uintptr)(unsafe.Add(vtable, method*int(unsafe.Sizeof(uintptr(0)))))
+	hr, _, _ := syscall.SyscallN(fn, append([]uintptr{uintptr(o.p)}, args...)...)
+	if int32(hr) < 0 {
+		return hresultError{name, uint32(hr)}
+	}
+	return nil
+}
+
+func (o comObject) release() {
+	if o.p != nil {
+		o.call("Release", methodRelease)
+	}
+}
+
+type hresultError struct {
+	call string
+	hr   uint32
+}
+
+func (e hresultError) Error() string {
+	return fmt.Sprintf("%s failed with HRESULT 0x%08X", e.call, e.hr)
+}
+
+// waveFormatExtensible is WAVEFORMATEXTENSIBLE.
+type waveFormatExtensible struct {
+	formatTag      uint16
+	channels       uint16
+	samplesPerSec  uint32
+	avgBytesPerSec uint32
+	blockAlign     uint16
+	bitsPerSample  uint16
+	size           uint16
+	validBits      uint16
+	channelMask    uint32
+	subFormat      guid
+}
+
+func newWaveFormat(sampleRate, bits, validBits int, subFormat guid) *waveFormatExtensible {
+	blockAlign := 2 * bits / 8
+	return &waveFormatExtensible{
+		formatTag:      0xFFFE,
+		channels:       2,
+		samplesPerSec:  uint32(sampleRate),
+		avgBytesPerSec: uint32(sampleRate * blockAlign),
+		blockAlign:     uint16(blockAlign),
+		bitsPerSample:  uint16(bits),
+		size:           22,
+		validBits:      uint16(validBits),
+		channelMask:    0x3, // front left and right
+		subFormat:      subFormat,
+	}
+}
+
+// wasapiOutput plays through WASAPI in exclusive, event driven mode, which
+// bypasses the Windows mixer for much lower latency than oto's shared
+// mode. All COM calls happen on one locked OS thread.
+type wasapiOutput struct {
+	frames int // device buffer size, in frames
+	rate   int // device sample rate
+	format *waveFormatExtensible
+	start  chan wasapiStart
+}
+
+type wasapiStart struct {
+	ring  *ringBuffer
+	stats *audioStats
+}
+
+// openWasapiExclusive opens the default playback device in exclusive mode,
+// at the given sample rate if the device supports it and otherwise at a
+// common one. A zero period uses the device's minimum.
+func openWasapiExclusive(sampleRate int, period time.Duration) (*wasapiOutput, error) {
+	o := &wasapiOutput{start: make(chan wasapiStart)}
+	opened := make(chan error)
+	go o.run(sampleRate, period, opened)
+	if err := <-opened; err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// sampleRate returns the rate the device runs at.
+func (o *wasapiOutput) sampleRate() int {
+	return o.rate
+}
+
+// bufferFrames returns the device buffer size in frames.
+func (o *wasapiOutput) bufferFrames() int {
+	return o.frames
+}
+
+// play starts pulling audio from the ring buffer.
+func (o *wasapiOutput) play(ring *ringBuffer, stats *audioStats) {
+	o.start <- wasapiStart{ring, stats}
+}
+
+func (o *wasapiOutput) run(sampleRate int, period time.Duration, opened chan<- error) {
+	runtime.LockOSThread()
+	procCoInitializeEx.Call(0, 0) // COINIT_MULTITHREADED
+
+	client, render, event, err := o.open(sampleRate, period)
+	opened <- err
+	if err != nil {
+		return
+	}
+	start := <-o.start
+
+	// Ask the scheduler to treat the thread as a pro audio thread.
+	var task uint32
+	if name, err := syscall.UTF16PtrFromString("Pro Audio"); err == nil && procAvSetMmThreadCharacteristicsW.Find() == nil {
+		procAvSetMmThreadCharacteristicsW.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&task)))
+	}
+
+	samples := make([]float32, 2*o.frames)
+	var data unsafe.Pointer
+	// Start with a buffer of silence, as WASAPI requires.
+	if render.call("GetBuffer", methodGetBuffer, uintptr(o.frames), uintptr(unsafe.Pointer(&data))) == nil {
+		render.call("ReleaseBuffer", methodReleaseBuffer, uintptr(o.frames), audclntBufferFlagsSilent)
+	}
+	if err := client.call("Start", methodStart); err != nil {
+		fatal("Failed to start WASAPI output", "err", err)
+	}
+	for {
+		syscall.WaitForSingleObject(event, 2000)
+		if err := render.call("GetBuffer", methodGetBuffer, uintptr(o.frames), uintptr(unsafe.Pointer(&data))); err != nil {
+			continue
+		}
+		if got := start.ring.pop(samples); got < len(samples) {
+			clear(samples[got:])
+			if start.stats != nil {
+				start.stats.underrun()
+			}
+		}
+		o.encode(unsafe.Slice((*byte)(data), o.frames*int(o.format.blockAlign)), samples)
+		render.call("ReleaseBuffer", methodReleaseBuffer, uintptr(o.frames), 0)
+	}
+}
+
+// open sets up the exclusive stream, trying sample formats from the best
+// to the most widely supported.
+func (o *wasapiOutput) open(sampleRate int, period time.Duration) (client, render comObject, event syscall.Handle, err error) {
+	var enumerator, device comObject
+	hr, _, _ := procCoCreateInstance.Call(uintptr(unsafe.Pointer(&clsidMMDeviceEnumerator)), 0, clsctxAll,
+		uintptr(unsafe.Pointer(&iidIMMDeviceEnumerator)), uintptr(unsafe.Pointer(&enumerator.p)))
+	if int32(hr) < 0 {
+		return client, render, 0, hresultError{"CoCreateInstance", uint32(hr)}
+	}
+	defer enumerator.release()
+	if err = enumerator.call("GetDefaultAudioEndpoint", methodGetDefaultAudioEndpoint, 0, 0, uintptr(unsafe.Pointer(&device.p))); err != nil {
+		return
+	}
+	defer device.release()
+
+	activate := func() (comObject, error) {
+		var c comObject
+		err := device.call("Activate", methodActivate, uintptr(unsafe.Pointer(&iidIAudioClient)), clsctxAll, 0, uintptr(unsafe.Pointer(&c.p)))
+		return c, err
+	}
+	if client, err = activate(); err != nil {
+		return
+	}
+
+	// Exclusive mode has no mixer to convert rates, so the renderer
+	// resamples if the device can't run at the requested one.
+	for _, rate := range []int{sampleRate, 48000, 44100, 96000, 88200, 192000} {
+		for _, f := range []*waveFormatExtensible{
+			newWaveFormat(rate, 32, 32, subtypeFloat),
+			newWaveFormat(rate, 32, 24, subtypePCM),
+			newWaveFormat(rate, 24, 24, subtypePCM),
+			newWaveFormat(rate, 16, 16, subtypePCM),
+		} {
+			if client.call("IsFormatSupported", methodIsFormatSupported, audclntShareModeExclusive, uintptr(unsafe.Pointer(f)), 0) == nil {
+				o.format = f
+				break
+			}
+		}
+		if o.format != nil {
+			o.rate = rate
+			break
+		}
+	}
+	if o.format == nil {
+		client.release()
+		return client, render, 0, fmt.Errorf("the device doesn't support stereo in exclusive mode")
+	}
+	sampleRate = o.rate
+
+	hns := int64(period / 100) // REFERENCE_TIME is in 100 ns units
+	if hns == 0 {
+		var defaultPeriod int64
+		if err = client.call("GetDevicePeriod", methodGetDevicePeriod, uintptr(unsafe.Pointer(&defaultPeriod)), uintptr(unsafe.Pointer(&hns))); err != nil {
+			client.release()
+			return
+		}
+	}
+	initialize := func() error {
+		return client.call("Initialize", methodInitialize, audclntShareModeExclusive, audclntStreamFlagsEvent,
+			uintptr(hns), uintptr(hns), uintptr(unsafe.Pointer(o.format)), 0)
+	}
+	err = initialize()
+	if e, ok := err.(hresultError); ok && e.hr == audclntBufferSizeNotAligned {
+		// Retry with the period rounded to the buffer size the device wants.
+		var frames uint32
+		client.call("GetBufferSize", methodGetBufferSize, uintptr(unsafe.Pointer(&frames)))
+		hns = int64(math.Round(1e7 * float64(frames) / float64(sampleRate)))
+		client.release()
+		if client, err = activate(); err != nil {
+			return
+		}
+		err = initialize()
+	}
+	if err != nil {
+		client.release()
+		return
+	}
+
+	var frames uint32
+	if err = client.call("GetBufferSize", methodGetBufferSize, uintptr(unsafe.Pointer(&frames))); err != nil {
+		client.release()
+		return
+	}
+	o.frames = int(frames)
+	h, _, callErr := procCreateEventW.Call(0, 0, 0, 0)
+	if h == 0 {
+		client.release()
+		return client, render, 0, callErr
+	}
+	event = syscall.Handle(h)
+	if err = client.call("SetEventHandle", methodSetEventHandle, uintptr(event)); err != nil {
+		client.release()
+		return
+	}
+	err = client.call("GetService", methodGetService, uintptr(unsafe.Pointer(&iidIAudioRenderClient)), uintptr(unsafe.Pointer(&render.p)))
+	return
+}
+
+// listWasapiDevices returns the names of the active playback devices,
+// marking the default one, which exclusive mode plays to.
+func listWasapiDevices() ([]string, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	procCoInitializeEx.Call(0, 0) // COINIT_MULTITHREADED
+
+	var enumerator, collection, defaultDevice comObject
+	hr, _, _ := procCoCreateInstance.Call(uintptr(unsafe.Pointer(&clsidMMDeviceEnumerator)), 0, clsctxAll,
+		uintptr(unsafe.Pointer(&iidIMMDeviceEnumerator)), uintptr(unsafe.Pointer(&enumerator.p)))
+	if int32(hr) < 0 {
+		return nil, hresultError{"CoCreateInstance", uint32(hr)}
+	}
+	defer enumerator.release()
+	if err := enumerator.call("EnumAudioEndpoints", methodEnumAudioEndpoints, eRender, deviceStateActive, uintptr(unsafe.Pointer(&collection.p))); err != nil {
+		return nil, err
+	}
+	defer collection.release()
+	defaultID := ""
+	if enumerator.call("GetDefaultAudioEndpoint", methodGetDefaultAudioEndpoint, eRender, 0, uintptr(unsafe.Pointer(&defaultDevice.p))) == nil {
+		defaultID = deviceID(defaultDevice)
+		defaultDevice.release()
+	}
+
+	var count uint32
+	if err := collection.call("GetCount", methodGetCount, uintptr(unsafe.Pointer(&count))); err != nil {
+		return nil, err
+	}
+	var names []string
+	for i := range count {
+		var device comObject
+		if err := collection.call("Item", methodItem, uintptr(i), uintptr(unsafe.Pointer(&device.p))); err != nil {
+			return nil, err
+		}
+		name := deviceName(device)
+		if id := deviceID(device); id != "" && id == defaultID {
+			name += " (default)"
+		}
+		device.release()
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// deviceID returns the endpoint ID of a device, or "" on failure.
+func deviceID(device comObject) string {
+	var id *uint16
+	if device.call("GetId", methodGetId, uintptr(unsafe.Pointer(&id))) != nil {
+		return ""
+	}
+	defer procCoTaskMemFree.Call(uintptr(unsafe.Pointer(id)))
+	return utf16PtrToString(id)
+}
+
+// deviceName returns the friendly name of a device, or "unnamed device".
+func deviceName(device comObject) string {
+	var store comObject
+	if device.call("OpenPropertyStore", methodOpenPropertyStore, stgmRead, uintptr(unsafe.Pointer(&store.p))) != nil {
+		return "unnamed device"
+	}
+	defer store.release()
+	var value propVariant
+	if store.call("GetValue", methodGetValue, uintptr(unsafe.Pointer(&pkeyDeviceFriendlyName)), uintptr(unsafe.Pointer(&value))) != nil {
+		return "unnamed device"
+	}
+	defer procPropVariantClear.Call(uintptr(unsafe.Pointer(&value)))
+	if value.vt != 31 || value.value == nil { // VT_LPWSTR
+		return "unnamed device"
+	}
+	return utf16PtrToString(value.value)
+}
+
+// utf16PtrToString converts a NUL terminated UTF-16 string.
+func utf16PtrToString(p *uint16) string {
+	n := 0
+	for *(*uint16)(unsafe.Add(unsafe.Pointer(p), 2*n)) != 0 {
+		n++
+	}
+	return syscall.UTF16ToString(unsafe.Slice(p, n))
+}
+
+// encode converts float samples to the device format.
+func (o *wasapiOutput) encode(out []byte, samples []float32) {
+	bytesPerSample := int(o.format.bitsPerSample / 8)
+	for i, v := range samples {
+		b := out[i*bytesPerSample:]
+		if o.format.subFormat == subtypeFloat {
+			*(*float32)(unsafe.Pointer(&b[0])) = v
+			continue
+		}
+		x := int32(math.Round(float64(max(-1, min(1, v))) * math.MaxInt32 * 0.999))
+		// Little-endian: the most significant bytes are the last ones.
+		for j := range bytesPerSample {
+			b[j] = byte(x >> (32 - 8*(bytesPerSample-j)))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+)
+
+// wavSound is a decoded WAV file.
+type wavSound struct {
+	channels   [][]int16 // samples of each channel, converted to 16 bits
+	sampleRate int
+	loopStart  int // first frame of the loop
+	loopEnd    int // frame after the loop
+	looped     bool
+}
+
+// loadWav reads a PCM or float WAV file, including the loop from its sampler
+// chunk if there is one.
+func loadWav(path string) (*wavSound, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%s: not a WAV file", path)
+	}
+
+	sound, err := decodeWave(readRiffChunks(data[12:]))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sound, nil
+}
+
+// decodeWave decodes the format and data chunks of a WAV file or of a
+// wave in a DLS bank.
+func decodeWave(chunks []riffEntry) (*wavSound, error) {
+	var (
+		format, channelCount, bits uint16
+		sound                      wavSound
+		samples                    []byte
+	)
+	for _, chunk := range chunks {
+		body := chunk.data
+		switch chunk.id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, fmt.Errorf("invalid format chunk")
+			}
+			format = binary.LittleEndian.Uint16(body[0:])
+			channelCount = binary.LittleEndian.Uint16(body[2:])
+			sound.sampleRate = int(binary.LittleEndian.Uint32(body[4:]))
+			bits = binary.LittleEndian.Uint16(body[14:])
+			if format == 0xFFFE && len(body) >= 26 {
+				// WAVE_FORMAT_EXTENSIBLE: the sub-format GUID starts with the format tag.
+				format = binary.LittleEndian.Uint16(body[24:])
+			}
+		case "data":
+			samples = body
+		case "smpl":
+			// The loop list follows 36 bytes of header; each loop is 24 bytes.
+			if len(body) >= 36+24 && binary.LittleEndian.Uint32(body[28:]) > 0 {
+				loop := body[36:]
+				sound.loopStart = int(binary.LittleEndian.Uint32(loop[8:]))
+				sound.loopEnd = int(binary.LittleEndian.Uint32(loop[12:])) + 1
+				sound.looped = true
+			}
+		}
+	}
+
+	if channelCount == 0 || samples == nil {
+		return nil, fmt.Errorf("missing format or data chunk")
+	}
+	decode, err := wavDecoder(format, bits)
+	if err != nil {
+		return nil, err
+	}
+	frameSize := int(channelCount) * int(bits/8)
+	frames := len(samples) / frameSize
+	sound.channels = make([][]int16, channelCount)
+	for c := range sound.channels {
+		sound.channels[c] = make([]int16, frames)
+	}
+	for i := range frames {
+		for c := range sound.channels {
+			offset := i*frameSize + c*int(bits/8)
+			sound.channels[c][i] = decode(samples[offset:])
+		}
+	}
+	if sound.loopEnd > frames || sound.loopStart >= sound.loopEnd {
+		sound.looped = false
+	}
+	return &sound, nil
+}
+
+// wavDecoder returns a function converting one sample to 16 bits.
+func wavDecoder(format, bits uint16) (func([]byte) int16, error) {
+	switch {
+	case format == 1 && bits == 8:
+		return func(b []byte) int16 { return int16(int(b[0])-128) << 8 }, nil
+	case format == 1 && bits == 16:
+		return func(b []byte) int16 { return int16(binary.LittleEndian.Uint16(b)) }, nil
+	case format == 1 && bits == 24:
+		return func(b []byte) int16 { return int16(uint16(b[1]) | uint16(b[2])<<8) }, nil
+	case format == 1 && bits == 32:
+		return func(b []byte) int16 { return int16(binary.LittleEndian.Uint32(b) >> 16) }, nil
+	case format == 3 && bits == 32:
+		return func(b []byte) int16 {
+			v := math.Float32frombits(binary.LittleEndian.Uint32(b))
+			return int16(max(-32768, min(32767, math.Round(float64(v)*32767))))
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported WAV format %d with %d bits", format, bits)
+}
+
+// wavHeader encodes the header of a PCM WAV file whose data chunk has the
+// given size.
+func wavHeader(sampleRate, channels, bits int, dataSize uint32) []byte {
+	blockAlign := channels * bits / 8
+	header := append([]byte("RIFF"), le(dataSize+36)...)
+	header = append(header, "WAVEfmt "...)
+	header = append(header, le(uint32(16), uint16(1), uint16(channels), uint32(sampleRate),
+		uint32(sampleRate*blockAlign), uint16(blockAlign), uint16(bits))...)
+	header = append(header, "data"...)
+	return append(header, le(dataSize)...)
+}
@@ -0,0 +1,14 @@
+//go:build !windows || !(amd64 || arm64)
+
+package main
+
+import "fmt"
+
+// winrtMidiInput is only available on 64-bit Windows.
+type winrtMidiInput struct{}
+
+func openWinrtMidi(spec string, play func([]byte)) (*winrtMidiInput, string, error) {
+	return nil, "", fmt.Errorf("the WinRT MIDI API is only available on 64-bit Windows")
+}
+
+func (in *winrtMidiInput) Close() error { return nil }
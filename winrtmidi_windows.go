@@ -0,0 +1,318 @@
+//go:build windows && (amd64 || arm64)
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	combase                       = syscall.NewLazyDLL("combase.dll")
+	procRoInitialize              = combase.NewProc("RoInitialize")
+	procRoGetActivationFactory    = combase.NewProc("RoGetActivationFactory")
+	procWindowsCreateString       = combase.NewProc("WindowsCreateString")
+	procWindowsDeleteString       = combase.NewProc("WindowsDeleteString")
+	procWindowsGetStringRawBuffer = combase.NewProc("WindowsGetStringRawBuffer")
+)
+
+var (
+	iidUnknown                  = guid{0x00000000, 0x0000, 0x0000, [8]byte{0xC0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x46}}
+	iidAgileObject              = guid{0x94EA2B94, 0xE9CC, 0x49E0, [8]byte{0xC0, 0xFF, 0xEE, 0x64, 0xCA, 0x8F, 0x5B, 0x90}}
+	iidAsyncInfo                = guid{0x00000036, 0x0000, 0x0000, [8]byte{0xC0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x46}}
+	iidClosable                 = guid{0x30D5A829, 0x7FA4, 0x4026, [8]byte{0x83, 0xBB, 0xD7, 0x5B, 0xAE, 0x4E, 0xA9, 0x9E}}
+	iidBufferByteAccess         = guid{0x905A0FEF, 0xBC53, 0x11DF, [8]byte{0x8C, 0x49, 0x00, 0x1E, 0x4F, 0xC6, 0x86, 0xDA}}
+	iidMidiInPortStatics        = guid{0x44C439DC, 0x67FF, 0x4A6E, [8]byte{0x8B, 0xAC, 0xFD, 0xB6, 0x61, 0x0C, 0xF2, 0x96}}
+	iidDeviceInformationStatics = guid{0xC17F100E, 0x3A46, 0x4A78, [8]byte{0x80, 0x13, 0x76, 0x9D, 0xC9, 0xB9, 0x73, 0x90}}
+	// TypedEventHandler<MidiInPort, MidiMessageReceivedEventArgs>
+	iidMidiMessageHandler = guid{0x50017240, 0xCC39, 0x5775, [8]byte{0x8A, 0x6B, 0xF6, 0xF2, 0x23, 0x86, 0xBF, 0xCA}}
+)
+
+const (
+	roInitMultithreaded = 1
+	asyncStarted        = 0
+	asyncCompleted      = 1
+	eNoInterface        = 0x80004002
+)
+
+// Method indices in the WinRT vtables, after the six of IInspectable.
+const (
+	methodQueryInterface        = 0
+	methodFromIdAsync           = 6 // IMidiInPortStatics
+	methodGetDeviceSelector     = 7
+	methodFindAllAsyncAqsFilter = 10 // IDeviceInformationStatics
+	methodAsyncStatus           = 7  // IAsyncInfo
+	methodAsyncErrorCode        = 8
+	methodGetResults            = 8 // IAsyncOperation
+	methodVectorGetAt           = 6 // IVectorView
+	methodVectorSize            = 7
+	methodDeviceID              = 6 // IDeviceInformation
+	methodDeviceName            = 7
+	methodAddMessageReceived    = 6 // IMidiInPort
+	methodRemoveMessageReceived = 7
+	methodClose                 = 6 // IClosable
+	methodGetMessage            = 6 // IMidiMessageReceivedEventArgs
+	methodGetRawData            = 7 // IMidiMessage
+	methodBufferLength          = 7 // IBuffer
+	methodBufferBytes           = 3 // IBufferByteAccess
+)
+
+// winrtMidiInput is a MIDI input port opened through the WinRT MIDI API.
+// With Windows MIDI Services installed, the port stays open to other
+// applications, so a DAW can play the same controller.
+type winrtMidiInput struct {
+	port    comObject
+	handler *midiMessageHandler
+	token   int64
+}
+
+// openWinrtMidi opens the WinRT MIDI input port matching spec, a port
+// number or part of its name, and passes its messages to play. It returns
+// the port's name.
+func openWinrtMidi(spec string, play func([]byte)) (*winrtMidiInput, string, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	procRoInitialize.Call(roInitMultithreaded)
+
+	ids, names, err := winrtMidiInputs()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(names) == 0 {
+		return nil, "", fmt.Errorf("no WinRT MIDI input devices found")
+	}
+	for i, name := range names {
+		slog.Info("MIDI input device", "port", i, "name", name)
+	}
+	index, err := findPort(names, spec)
+	if err != nil {
+		return nil, "", err
+	}
+
+	statics, err := activationFactory("Windows.Devices.Midi.MidiInPort", &iidMidiInPortStatics)
+	if err != nil {
+		return nil, "", err
+	}
+	defer statics.release()
+	id, err := newHString(ids[index])
+	if err != nil {
+		return nil, "", err
+	}
+	defer procWindowsDeleteString.Call(id)
+	var op comObject
+	if err := statics.call("FromIdAsync", methodFromIdAsync, id, uintptr(unsafe.Pointer(&op.p))); err != nil {
+		return nil, "", err
+	}
+	defer op.release()
+	in := &winrtMidiInput{handler: &midiMessageHandler{vtable: &midiMessageHandlerVtable, play: play}}
+	if err := await(op, &in.port); err != nil {
+		return nil, "", err
+	}
+	if in.port.p == nil {
+		return nil, "", fmt.Errorf("%s is unavailable", names[index])
+	}
+	if err := in.port.call("add_MessageReceived", methodAddMessageReceived, uintptr(unsafe.Pointer(in.handler)), uintptr(unsafe.Pointer(&in.token))); err != nil {
+		in.port.release()
+		return nil, "", err
+	}
+	return in, names[index], nil
+}
+
+func (in *winrtMidiInput) Close() error {
+	in.port.call("remove_MessageReceived", methodRemoveMessageReceived, uintptr(in.token))
+	var closable comObject
+	err := in.port.call("QueryInterface", methodQueryInterface, uintptr(unsafe.Pointer(&iidClosable)), uintptr(unsafe.Pointer(&closable.p)))
+	if err == nil {
+		err = closable.call("Close", methodClose)
+		closable.release()
+	}
+	in.port.release()
+	return err
+}
+
+// winrtMidiInputs returns the device IDs and names of the MIDI input ports.
+func winrtMidiInputs() (ids, names []string, err error) {
+	ports, err := activationFactory("Windows.Devices.Midi.MidiInPort", &iidMidiInPortStatics)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer ports.release()
+	var selector uintptr
+	if err := ports.call("GetDeviceSelector", methodGetDeviceSelector, uintptr(unsafe.Pointer(&selector))); err != nil {
+		return nil, nil, err
+	}
+	defer procWindowsDeleteString.Call(selector)
+
+	devices, err := activationFactory("Windows.Devices.Enumeration.DeviceInformation", &iidDeviceInformationStatics)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer devices.release()
+	var op, collection comObject
+	if err := devices.call("FindAllAsync", methodFindAllAsyncAqsFilter, selector, uintptr(unsafe.Pointer(&op.p))); err != nil {
+		return nil, nil, err
+	}
+	defer op.release()
+	if err := await(op, &collection); err != nil {
+		return nil, nil, err
+	}
+	defer collection.release()
+
+	var count uint32
+	if err := collection.call("get_Size", methodVectorSize, uintptr(unsafe.Pointer(&count))); err != nil {
+		return nil, nil, err
+	}
+	for i := range count {
+		var device comObject
+		if err := collection.call("GetAt", methodVectorGetAt, uintptr(i), uintptr(unsafe.Pointer(&device.p))); err != nil {
+			return nil, nil, err
+		}
+		var id, name uintptr
+		err := device.call("get_Id", methodDeviceID, uintptr(unsafe.Pointer(&id)))
+		if err == nil {
+			err = device.call("get_Name", methodDeviceName, uintptr(unsafe.Pointer(&name)))
+		}
+		device.release()
+		if err != nil {
+			procWindowsDeleteString.Call(id)
+			procWindowsDeleteString.Call(name)
+			return nil, nil, err
+		}
+		ids = append(ids, hstringToString(id))
+		names = append(names, hstringToString(name))
+	}
+	return ids, names, nil
+}
+
+// await waits for an IAsyncOperation to finish and stores its result.
+func await(op comObject, result *comObject) error {
+	var info comObject
+	if err := op.call("QueryInterface", methodQueryInterface, uintptr(unsafe.Pointer(&iidAsyncInfo)), uintptr(unsafe.Pointer(&info.p))); err != nil {
+		return err
+	}
+	defer info.release()
+	var status int32
+	for {
+		if err := info.call("get_Status", methodAsyncStatus, uintptr(unsafe.Pointer(&status))); err != nil {
+			return err
+		}
+		if status != asyncStarted {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if status != asyncCompleted {
+		var hr uint32
+		info.call("get_ErrorCode", methodAsyncErrorCode, uintptr(unsafe.Pointer(&hr)))
+		return hresultError{"asynchronous operation", hr}
+	}
+	return op.call("GetResults", methodGetResults, uintptr(unsafe.Pointer(&result.p)))
+}
+
+// activationFactory returns the interface iid of a runtime class's
+// activation factory.
+func activationFactory(class string, iid *guid) (comObject, error) {
+	name, err := newHString(class)
+	if err != nil {
+		return comObject{}, err
+	}
+	defer procWindowsDeleteString.Call(name)
+	var factory comObject
+	hr, _, _ := procRoGetActivationFactory.Call(name, uintptr(unsafe.Pointer(iid)), uintptr(unsafe.Pointer(&factory.p)))
+	if int32(hr) < 0 {
+		return comObject{}, hresultError{"RoGetActivationFactory " + class, uint32(hr)}
+	}
+	return factory, nil
+}
+
+// newHString creates an HSTRING, to be freed with WindowsDeleteString.
+func newHString(s string) (uintptr, error) {
+	u, err := syscall.UTF16FromString(s)
+	if err != nil {
+		return 0, err
+	}
+	var h uintptr
+	hr, _, _ := procWindowsCreateString.Call(uintptr(unsafe.Pointer(&u[0])), uintptr(len(u)-1), uintptr(unsafe.Pointer(&h)))
+	if int32(hr) < 0 {
+		return 0, hresultError{"WindowsCreateString", uint32(hr)}
+	}
+	return h, nil
+}
+
+// hstringToString converts an HSTRING and frees it.
+func hstringToString(h uintptr) string {
+	defer procWindowsDeleteString.Call(h)
+	var n uint32
+	p, _, _ := procWindowsGetStringRawBuffer.Call(h, uintptr(unsafe.Pointer(&n)))
+	if p == 0 || n == 0 {
+		return ""
+	}
+	// The buffer belongs to the string, outside the Go heap.
+	return syscall.UTF16ToString(unsafe.Slice(*(**uint16)(unsafe.Pointer(&p)), n))
+}
+
+// midiMessageHandler is a COM object implementing the MessageReceived
+// event handler. It is agile, so WinRT calls it on whichever thread
+// receives the message. It lives as long as its winrtMidiInput, so the
+// reference count is only kept for COM's sake.
+type midiMessageHandler struct {
+	vtable *[4]uintptr
+	refs   int32
+	play   func([]byte)
+}
+
+var midiMessageHandlerVtable = [4]uintptr{
+	syscall.NewCallback(func(h *midiMessageHandler, iid *guid, out *unsafe.Pointer) uintptr {
+		if *iid != iidUnknown && *iid != iidAgileObject && *iid != iidMidiMessageHandler {
+			*out = nil
+			return eNoInterface
+		}
+		atomic.AddInt32(&h.refs, 1)
+		*out = unsafe.Pointer(h)
+		return 0
+	}),
+	syscall.NewCallback(func(h *midiMessageHandler) uintptr {
+		return uintptr(atomic.AddInt32(&h.refs, 1))
+	}),
+	syscall.NewCallback(func(h *midiMessageHandler) uintptr {
+		return uintptr(atomic.AddInt32(&h.refs, -1))
+	}),
+	syscall.NewCallback(func(h *midiMessageHandler, sender, args unsafe.Pointer) uintptr {
+		if msg := messageBytes(comObject{args}); len(msg) > 0 {
+			h.play(msg)
+		}
+		return 0
+	}),
+}
+
+// messageBytes copies the raw bytes of a MidiMessageReceivedEventArgs'
+// message.
+func messageBytes(args comObject) []byte {
+	var message, data, access comObject
+	if args.call("get_Message", methodGetMessage, uintptr(unsafe.Pointer(&message.p))) != nil {
+		return nil
+	}
+	defer message.release()
+	if message.call("get_RawData", methodGetRawData, uintptr(unsafe.Pointer(&data.p))) != nil {
+		return nil
+	}
+	defer data.release()
+	var n uint32
+	if data.call("get_Length", methodBufferLength, uintptr(unsafe.Pointer(&n))) != nil || n == 0 {
+		return nil
+	}
+	if data.call("QueryInterface", methodQueryInterface, uintptr(unsafe.Pointer(&iidBufferByteAccess)), uintptr(unsafe.Pointer(&access.p))) != nil {
+		return nil
+	}
+	defer access.release()
+	var p *byte
+	if access.call("Buffer", methodBufferBytes, uintptr(unsafe.Pointer(&p))) != nil || p == nil {
+		return nil
+	}
+	return append([]byte(nil), unsafe.Slice(p, n)...)
+}
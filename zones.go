@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+)
+
+// keyZone routes a key range of an incoming channel to a synthesizer
+// channel. Zones of one channel with separate ranges split the keyboard,
+// overlapping ones layer sounds; channel-wide messages such as controllers
+// and pitch bend go to every zone of the channel.
+type keyZone struct {
+	input, channel int32
+	low, high      byte
+	bank, program  int32 // program -1 follows incoming program changes, bank -1 keeps the channel's
+	transpose      int
+	level          float64 // channel volume scale
+}
+
+func newKeyZones(configs []zoneConfig) []keyZone {
+	zones := make([]keyZone, 0, len(configs))
+	for _, c := range configs {
+		z := keyZone{
+			input:     int32(c.Input - 1),
+			channel:   int32(c.Channel - 1),
+			low:       byte(c.Low),
+			high:      byte(c.High),
+			program:   -1,
+			transpose: c.Transpose,
+			level:     c.Level,
+		}
+		if c.Program != nil {
+			z.program = *c.Program
+			z.bank = -1
+			if c.Bank != nil {
+				z.bank = *c.Bank
+			}
+		}
+		zones = append(zones, z)
+	}
+	return zones
+}
+
+// route returns the message to send to the zone's channel, if the zone
+// takes it.
+func (z keyZone) route(msg []byte) ([]byte, bool) {
+	switch msg[0] & 0xF0 {
+	case 0x80, 0x90, 0xA0: // Note Off, Note On, Polyphonic Key Pressure
+		if len(msg) < 3 || msg[1] < z.low || msg[1] > z.high {
+			return nil, false
+		}
+		key := int(msg[1]) + z.transpose
+		if key < 0 || key > 127 {
+			return nil, false
+		}
+		return []byte{msg[0]&0xF0 | byte(z.channel), byte(key), msg[2]}, true
+	case 0xB0: // Control Change
+		if len(msg) >= 3 && msg[1] == 0x07 {
+			return []byte{0xB0 | byte(z.channel), 0x07, z.volume(msg[2])}, true
+		}
+	case 0xC0: // Program Change
+		if z.program >= 0 {
+			return nil, false
+		}
+	}
+	out := slices.Clone(msg)
+	out[0] = msg[0]&0xF0 | byte(z.channel)
+	return out, true
+}
+
+// volume scales a channel volume by the zone's level.
+func (z keyZone) volume(value byte) byte {
+	return byte(math.Round(float64(value) * z.level))
+}
+
+// handleChannelMessage expands chords, routes a channel voice message
+// through the key zones of its channel, if it has any, and plays it.
+func (h *midiHandler) handleChannelMessage(msg []byte) {
+	if h.chord != nil {
+		if notes := h.chord.expand(msg); notes != nil {
+			for _, note := range notes {
+				h.routeChannelMessage(note)
+			}
+			return
+		}
+	}
+	h.routeChannelMessage(msg)
+}
+
+// routeChannelMessage sends a message to the key zones of its channel, or
+// plays it directly if the channel has none.
+func (h *midiHandler) routeChannelMessage(msg []byte) {
+	channel := int32(msg[0] & 0x0F)
+	zoned := false
+	for _, z := range h.zones {
+		if z.input != channel {
+			continue
+		}
+		zoned = true
+		if out, ok := z.route(msg); ok {
+			h.playChannelMessage(out)
+		}
+	}
+	if !zoned {
+		h.playChannelMessage(msg)
+	}
+}
+
+// applyZoneSettings selects the programs and levels configured for key
+// zones.
+func (h *midiHandler) applyZoneSettings() {
+	for _, z := range h.zones {
+		if z.level != 1 {
+			h.playChannelMessage([]byte{0xB0 | byte(z.channel), 0x07, z.volume(100)})
+		}
+		if z.program < 0 {
+			continue
+		}
+		if z.bank >= 0 {
+			h.banks[z.channel] = z.bank
+		}
+		h.programChange(z.channel, z.program)
+	}
+}
+
+// describeZones logs the key zones.
+func describeZones(zones []keyZone) {
+	for _, z := range zones {
+		program := "follows program changes"
+		if z.program >= 0 {
+			program = fmt.Sprintf("%d", z.program)
+			if z.bank >= 0 {
+				program = fmt.Sprintf("%d:%d", z.bank, z.program)
+			}
+		}
+		slog.Info("Key zone", "input", z.input+1, "keys", fmt.Sprintf("%d-%d", z.low, z.high),
+			"channel", z.channel+1, "program", program, "transpose", z.transpose, "level", z.level)
+	}
+}